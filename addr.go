@@ -0,0 +1,120 @@
+package nano
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// urlOption maps an address query parameter to an option.
+type urlOption struct {
+	name  string
+	parse func(string) (interface{}, error)
+}
+
+// urlOptions are the options that can be given in the address query, so
+// that addresses in config files can carry their tuning, e.g.
+//
+//	tcp://host:9000?snappy=1&nodelay=0&maxrecv=8m
+var urlOptions = map[string]urlOption{
+	"nohandshake":       {OptionNoHandshake, parseBool},
+	"snappy":            {OptionSnappy, parseBool},
+	"deflate":           {OptionDeflate, parseInt},
+	"maxrecv":           {OptionMaxRecvSize, parseSize},
+	"nodelay":           {OptionNoDelay, parseBool},
+	"keepalive":         {OptionKeepAlive, parseBool},
+	"keepaliveinterval": {OptionKeepAliveInterval, parseDuration},
+	"keepalivecount":    {OptionKeepAliveCount, parseInt},
+	"sndbuf":            {OptionSendBuffer, parseSize},
+	"rcvbuf":            {OptionRecvBuffer, parseSize},
+	"usertimeout":       {OptionUserTimeout, parseDuration},
+	"reuseport":         {OptionReusePort, parseBool},
+	"writeqlen":         {OptionWriteQLen, parseInt},
+	"maxconns":          {OptionMaxConnections, parseInt},
+	"maxconnsperip":     {OptionMaxConnsPerIP, parseInt},
+	"acceptrate":        {OptionAcceptRate, parseInt},
+	"allow":             {OptionAllowCIDR, parseList},
+	"deny":              {OptionDenyCIDR, parseList},
+}
+
+// splitAddrOptions splits the query off addr, and maps its parameters to
+// options.  Unknown parameters are refused with ErrBadOption, so that a
+// typo in a config file does not go unnoticed, unless keep is set: they
+// then stay in the address, for a transport that reads the query itself.
+func splitAddrOptions(addr string, keep bool) (string, map[string]interface{}, error) {
+	i := strings.Index(addr, "?")
+	if i < 0 {
+		return addr, nil, nil
+	}
+
+	var rest []string
+	opts := make(map[string]interface{})
+	for _, param := range strings.Split(addr[i+1:], "&") {
+		if param == "" {
+			continue
+		}
+		query, err := url.ParseQuery(param)
+		if err != nil {
+			return "", nil, ErrBadAddr
+		}
+		for k, vals := range query {
+			o, present := urlOptions[strings.ToLower(k)]
+			if !present {
+				if !keep {
+					return "", nil, ErrBadOption
+				}
+				rest = append(rest, param)
+				continue
+			}
+			v, err := o.parse(vals[len(vals)-1])
+			if err != nil {
+				return "", nil, ErrBadValue
+			}
+			opts[o.name] = v
+		}
+	}
+	if len(rest) > 0 {
+		return addr[:i] + "?" + strings.Join(rest, "&"), opts, nil
+	}
+	return addr[:i], opts, nil
+}
+
+func parseBool(s string) (interface{}, error) {
+	return strconv.ParseBool(s)
+}
+
+func parseInt(s string) (interface{}, error) {
+	return strconv.Atoi(s)
+}
+
+func parseDuration(s string) (interface{}, error) {
+	return time.ParseDuration(s)
+}
+
+func parseList(s string) (interface{}, error) {
+	return strings.Split(s, ","), nil
+}
+
+// parseSize parses a byte size with an optional k, m or g suffix.
+func parseSize(s string) (interface{}, error) {
+	mult := 1
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'm', 'M':
+			mult = 1 << 20
+		case 'g', 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, err
+	}
+	return v * mult, nil
+}
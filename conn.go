@@ -21,9 +21,10 @@ type connPipe struct {
 	reader *bufio.Reader
 	writer *bufio.Writer
 
-	proto Protocol
-	open  bool // true after handshake
-	props map[string]interface{}
+	proto   Protocol
+	open    bool // true after handshake
+	props   map[string]interface{}
+	maxRecv int64
 }
 
 // NewConnPipe allocates a new Pipe using the supplied net.Conn, and
@@ -46,6 +47,10 @@ func NewConnPipe(conn net.Conn, proto Protocol, props ...interface{}) (Pipe, err
 	for i := 0; i+1 < len(props); i += 2 {
 		this.props[props[i].(string)] = props[i+1]
 	}
+	this.maxRecv = defaultMaxMsgSize
+	if v, ok := this.props[OptionMaxRecvSize]; ok {
+		this.maxRecv = int64(v.(int))
+	}
 
 	Debugf("proto:%s, props:%v", proto.Number(), this.props)
 
@@ -150,7 +155,7 @@ func (this *connPipe) RecvMsg() (*Message, error) {
 
 	Debugf("sz: %d", sz)
 
-	if sz > defaultMaxMsgSize || sz < 0 {
+	if sz > this.maxRecv || sz < 0 {
 		this.conn.Close()
 		this.rlock.Unlock()
 		return nil, ErrTooLong
@@ -205,6 +210,35 @@ func (this *connPipe) SendMsg(msg *Message) error {
 	return nil
 }
 
+// SendTruncated implements the TruncatingPipe SendTruncated method.
+func (this *connPipe) SendTruncated(msg *Message) error {
+	this.wlock.Lock()
+	err := this.sendPartial(nil, msg)
+	this.wlock.Unlock()
+	return err
+}
+
+// sendPartial writes lead, the frame size and half the frame of msg.  An
+// empty frame loses half of its size instead.
+func (this *connPipe) sendPartial(lead []byte, msg *Message) error {
+	frame := make([]byte, 0, len(lead)+8+len(msg.Header)+len(msg.Body))
+	frame = append(frame, lead...)
+	frame = append(frame, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(frame[len(lead):], uint64(len(msg.Header)+len(msg.Body)))
+	frame = append(frame, msg.Header...)
+	frame = append(frame, msg.Body...)
+	msg.Free()
+
+	n := len(lead) + 8 + (len(frame)-len(lead)-8)/2
+	if n == len(lead)+8 {
+		n -= 4
+	}
+	if _, err := this.writer.Write(frame[:n]); err != nil {
+		return err
+	}
+	return this.writer.Flush()
+}
+
 func (this *connPipe) Flush() error {
 	// TODO is bytes.Buffer thread safe?
 	return this.writer.Flush()
@@ -241,10 +275,25 @@ func (this *connPipe) GetProp(name string) (interface{}, error) {
 // connPipeIpc is *almost* like a regular connPipe, but the IPC protocol insists
 // on stuffing a leading byte (valued 1) in front of messages.  This is for
 // compatibility with nanomsg -- the value cannot ever be anything but 1.
+//
+// As an extension, a message carrying file descriptors has the leading byte
+// ipcMsgFds, followed by a byte counting the descriptors attached to this
+// frame header.  It is only ever sent for messages with Fds, which nanomsg
+// peers have no way to produce.
 type connPipeIpc struct {
 	connPipe
+
+	compressed bool
 }
 
+const (
+	ipcMsgNormal = 1
+	ipcMsgFds    = 0x80
+
+	// maxMsgFds is the kernel limit of descriptors per message (SCM_MAX_FD)
+	maxMsgFds = 253
+)
+
 // NewConnPipeIPC allocates a new Pipe using the IPC exchange protocol.
 func NewConnPipeIPC(conn net.Conn, proto Protocol, props ...interface{}) (Pipe, error) {
 	this := &connPipeIpc{connPipe: connPipe{
@@ -263,6 +312,10 @@ func NewConnPipeIPC(conn net.Conn, proto Protocol, props ...interface{}) (Pipe,
 	for i := 0; i+1 < len(props); i += 2 {
 		this.props[props[i].(string)] = props[i+1]
 	}
+	this.maxRecv = defaultMaxMsgSize
+	if v, ok := this.props[OptionMaxRecvSize]; ok {
+		this.maxRecv = int64(v.(int))
+	}
 
 	v, err := this.GetProp(OptionNoHandshake)
 	if err != nil || !v.(bool) {
@@ -275,10 +328,12 @@ func NewConnPipeIPC(conn net.Conn, proto Protocol, props ...interface{}) (Pipe,
 	v, err = this.GetProp(OptionSnappy)
 	if err == nil && v.(bool) {
 		this.upgradeSnappy()
+		this.compressed = true
 	} else {
 		v, err = this.GetProp(OptionDeflate)
 		if err == nil {
 			this.upgradeDeflate(v.(int))
+			this.compressed = true
 		} else {
 			this.reader = bufio.NewReaderSize(conn, defaultBufferSize)
 			this.writer = bufio.NewWriterSize(conn, defaultBufferSize)
@@ -290,19 +345,19 @@ func NewConnPipeIPC(conn net.Conn, proto Protocol, props ...interface{}) (Pipe,
 
 func (this *connPipeIpc) SendMsg(msg *Message) error {
 	sz := uint64(len(msg.Header) + len(msg.Body))
-	one := [1]byte{1}
+	one := [1]byte{ipcMsgNormal}
 	var err error
 
 	// prevent interleaved writes
 	this.wlock.Lock()
 
 	// send length header
-	if _, err = this.writer.Write(one[:]); err != nil {
-		this.wlock.Unlock()
-		msg.Free()
-		return err
+	if len(msg.Fds) > 0 {
+		err = this.sendFdsHeader(sz, msg.Fds)
+	} else if _, err = this.writer.Write(one[:]); err == nil {
+		err = binary.Write(this.writer, binary.BigEndian, sz)
 	}
-	if err = binary.Write(this.writer, binary.BigEndian, sz); err != nil {
+	if err != nil {
 		this.wlock.Unlock()
 		msg.Free()
 		return err
@@ -328,6 +383,14 @@ func (this *connPipeIpc) SendMsg(msg *Message) error {
 	return nil
 }
 
+// SendTruncated implements the TruncatingPipe SendTruncated method.
+func (this *connPipeIpc) SendTruncated(msg *Message) error {
+	this.wlock.Lock()
+	err := this.sendPartial([]byte{ipcMsgNormal}, msg)
+	this.wlock.Unlock()
+	return err
+}
+
 func (this *connPipeIpc) RecvMsg() (*Message, error) {
 	var sz int64
 	var err error
@@ -337,17 +400,25 @@ func (this *connPipeIpc) RecvMsg() (*Message, error) {
 	// prevent interleaved reads
 	this.rlock.Lock()
 
-	if _, err = this.reader.Read(one[:]); err != nil {
+	if _, err = io.ReadFull(this.reader, one[:]); err != nil {
 		this.rlock.Unlock()
 		return nil, err
 	}
+	nfds := 0
+	if one[0] == ipcMsgFds {
+		if _, err = io.ReadFull(this.reader, one[:]); err != nil {
+			this.rlock.Unlock()
+			return nil, err
+		}
+		nfds = int(one[0])
+	}
 	if err = binary.Read(this.reader, binary.BigEndian, &sz); err != nil {
 		this.rlock.Unlock()
 		return nil, err
 	}
 
 	// TODO
-	if sz > defaultMaxMsgSize || sz < 0 {
+	if sz > this.maxRecv || sz < 0 {
 		this.conn.Close()
 		this.rlock.Unlock()
 		return nil, ErrTooLong
@@ -361,6 +432,43 @@ func (this *connPipeIpc) RecvMsg() (*Message, error) {
 		return nil, err
 	}
 
+	if nfds > 0 {
+		fc, ok := this.conn.(FdConn)
+		if !ok {
+			this.conn.Close()
+			this.rlock.Unlock()
+			msg.Free()
+			return nil, ErrFdPassing
+		}
+		if msg.Fds, err = fc.TakeFds(nfds); err != nil {
+			this.conn.Close()
+			this.rlock.Unlock()
+			msg.Free()
+			return nil, err
+		}
+	}
+
 	this.rlock.Unlock()
 	return msg, nil
 }
+
+// sendFdsHeader writes the frame header with the descriptors attached,
+// bypassing the buffered writer.  Must be called with wlock held.
+func (this *connPipeIpc) sendFdsHeader(sz uint64, fds []int) error {
+	fc, ok := this.conn.(FdConn)
+	if !ok || this.compressed || len(fds) > maxMsgFds {
+		return ErrFdPassing
+	}
+
+	// whatever is buffered must go first
+	if err := this.writer.Flush(); err != nil {
+		return err
+	}
+
+	var hdr [10]byte
+	hdr[0] = ipcMsgFds
+	hdr[1] = byte(len(fds))
+	binary.BigEndian.PutUint64(hdr[2:], sz)
+	_, err := fc.WriteFds(hdr[:], fds)
+	return err
+}
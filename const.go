@@ -39,9 +39,27 @@ const (
 	// value is a tls.ConnectionState.  It is only valid when TLS is used.
 	PropTlsConnState = "TLS-STATE"
 
+	// PropPeerIdentity is the verified identity of the TLS peer, from its
+	// certificate.  The value is a *PeerIdentity.  It only exists when the
+	// peer certificate chain was verified, e.g. for listeners when the
+	// tls.Config has ClientAuth set to tls.RequireAndVerifyClientCert.
+	PropPeerIdentity = "PEER-IDENTITY"
+
+	// PropPeerPid, PropPeerUid and PropPeerGid are the credentials of
+	// the peer process of an ipc or shm connection, as of connect time.  The
+	// values are int.  They only exist on Linux.
+	PropPeerPid = "PEER-PID"
+	PropPeerUid = "PEER-UID"
+	PropPeerGid = "PEER-GID"
+
 	// PropHttpRequest conveys an *http.Request.  This property only exists
 	// for websocket connections.
 	PropHttpRequest = "HTTP-REQUEST"
+
+	// PropRejectReason conveys why an inbound connection was refused by
+	// the listener admission control.  The value is an error.  It is only
+	// available on ports reported with PortActionReject.
+	PropRejectReason = "REJECT-REASON"
 )
 
 // The following are Options used by SetOption, GetOption.
@@ -87,7 +105,8 @@ const (
 	// application will not receive messages that do not match any current
 	// subscriptions.  (If there are no subscriptions for a SUB/XSUB
 	// socket, then the application will not receive any messages.  An
-	// empty prefix can be used to subscribe to all messages.)
+	// empty prefix can be used to subscribe to all messages.)  XSUB takes
+	// a whole topic instead, or an xpubsub.Subscription.
 	OptionSubscribe = "SUBSCRIBE"
 
 	// OptionUnsubscribe is used by SUB/XSUB.  The argument is a []byte,
@@ -95,6 +114,24 @@ const (
 	// removed from the socket.
 	OptionUnsubscribe = "UNSUBSCRIBE"
 
+	// OptionSubscribeUpstream makes SUB send its subscriptions to its PUB
+	// peers, again after reconnects, so that they only send it matching
+	// messages.  PUB peers that do not know about it send everything, as
+	// before.  Value is a bool, default false.
+	OptionSubscribeUpstream = "SUBSCRIBE-UPSTREAM"
+
+	// OptionSubscribePattern is used by SUB to subscribe to a topic
+	// pattern, as a []byte or string.  The topic of a message is its body
+	// up to the first space or zero byte.  Topics are segments separated
+	// by dots, and in patterns * stands for exactly one segment, # for
+	// zero or more: "orders.*.eu", "metrics.#".  Messages matching either
+	// a pattern or a prefix subscription are received.
+	OptionSubscribePattern = "SUBSCRIBE-PATTERN"
+
+	// OptionUnsubscribePattern removes a pattern added with
+	// OptionSubscribePattern.
+	OptionUnsubscribePattern = "UNSUBSCRIBE-PATTERN"
+
 	// OptionSurveyTime is used to indicate the deadline for survey
 	// responses, when used with a SURVEYOR socket.  Messages arriving
 	// after this will be discarded.  Additionally, this will set the
@@ -105,7 +142,9 @@ const (
 	OptionSurveyTime = "SURVEY-TIME"
 
 	// OptionTlsConfig is used to supply TLS configuration details.
-	// The parameter is a tls.Config pointer.
+	// The parameter is a tls.Config pointer.  TLS versions prior to 1.2
+	// are refused.  Certificates can be rotated without a restart by
+	// setting GetCertificate, see tlstcp.CertReloader.
 	OptionTlsConfig = "TLS-CONFIG"
 
 	// OptionWriteQLen is used to set the size, in messages, of the write
@@ -124,6 +163,48 @@ const (
 	// Value is a boolean.  Default is true.
 	OptionNoDelay = "NO-DELAY"
 
+	// OptionReusePort is used by tcp based listeners to set SO_REUSEPORT,
+	// so that several processes, or several Listen calls, can listen on
+	// the same port and have the kernel balance connections among them.
+	// Every listener sharing the port must set it.  Linux only.
+	// Value is a boolean.  Default is false.
+	OptionReusePort = "REUSE-PORT"
+
+	// OptionKeepAlive is used to enable TCP keepalive probes.
+	// Value is a boolean.  Default is false.
+	OptionKeepAlive = "KEEPALIVE"
+
+	// OptionKeepAliveInterval is the idle time before the first TCP
+	// keepalive probe, and the interval between probes.  Setting it
+	// implies OptionKeepAlive.
+	// Value is a time.Duration.
+	OptionKeepAliveInterval = "KEEPALIVE-INTERVAL"
+
+	// OptionKeepAliveCount is the number of unanswered TCP keepalive
+	// probes before the connection is dropped.  Linux only.
+	// Value is an int.
+	OptionKeepAliveCount = "KEEPALIVE-COUNT"
+
+	// OptionSendBuffer sets SO_SNDBUF of TCP connections.
+	// Value is an int in bytes.  Default is the OS default.
+	OptionSendBuffer = "SNDBUF"
+
+	// OptionRecvBuffer sets SO_RCVBUF of TCP connections.
+	// Value is an int in bytes.  Default is the OS default.
+	OptionRecvBuffer = "RCVBUF"
+
+	// OptionUserTimeout sets TCP_USER_TIMEOUT, the maximum time sent data
+	// may remain unacknowledged before the connection is dropped.
+	// Linux only.
+	// Value is a time.Duration.
+	OptionUserTimeout = "USER-TIMEOUT"
+
+	// OptionProxy makes tcp and tls+tcp dialers connect through a proxy,
+	// before the SP handshake.  Value is a string URL, socks5:// for a
+	// SOCKS5 proxy or http:// for an HTTP CONNECT one, with optional
+	// user:password@ credentials.  Default is to connect directly.
+	OptionProxy = "PROXY"
+
 	// OptionLinger is used to set the linger property.  This is the amount
 	// of time to wait for send queues to drain when Close() is called.
 	// Close() may block for up to this long if there is unsent data, but
@@ -152,6 +233,165 @@ const (
 	// with deflate.
 	// Value is int, deflate level, default is 0.
 	OptionDeflate = "FLATE"
+
+	// OptionMaxRecvSize is the largest message a connection accepts,
+	// larger ones make it close.  Like the compression options, it can be
+	// set on a transport, dialer or listener.
+	// Value is int bytes, default is 1MB.
+	OptionMaxRecvSize = "MAX-RECV-SIZE"
+
+	// OptionMaxConnections is used by Listener to limit the number of
+	// concurrently accepted connections.  Connections beyond the limit
+	// are closed before the SP handshake.  Like the other admission
+	// options, it applies as well when set while listening.
+	// Value is int, default is 0 which means unlimited.
+	OptionMaxConnections = "MAX-CONNS"
+
+	// OptionMaxConnsPerIP is used by Listener to limit the number of
+	// concurrently accepted connections from a single source IP.  It only
+	// applies to IP based transports, e.g. not to shm.
+	// Value is int, default is 0 which means unlimited.
+	OptionMaxConnsPerIP = "MAX-CONNS-PER-IP"
+
+	// OptionAcceptRate is used by Listener to limit how many connections
+	// are accepted per second.  Short bursts up to the same number are
+	// tolerated.
+	// Value is int, default is 0 which means unlimited.
+	OptionAcceptRate = "ACCEPT-RATE"
+
+	// OptionAllowCIDR is used by Listener to only accept connections whose
+	// source IP falls in one of the given networks, e.g. "10.0.0.0/8".
+	// Value is []string or string, default is empty which allows all.
+	OptionAllowCIDR = "ALLOW-CIDR"
+
+	// OptionDenyCIDR is used by Listener to refuse connections whose source
+	// IP falls in one of the given networks.  Deny takes precedence over
+	// allow.
+	// Value is []string or string, default is empty.
+	OptionDenyCIDR = "DENY-CIDR"
+
+	// OptionSendRate limits the aggregate outbound throughput.  When set on
+	// a Socket it is shared by all its connections, when set on a Dialer
+	// or Listener it is shared by the connections of that Dialer or
+	// Listener.  Senders block until the message fits in the rate.
+	// Only connections established after the option is set are affected.
+	// Value is nano.Rate, default is unlimited.
+	OptionSendRate = "SEND-RATE"
+
+	// OptionRecvRate is like OptionSendRate for inbound messages.  Reading
+	// from the connection is paused while over the rate, so the peer is
+	// pushed back by the transport flow control.
+	// Value is nano.Rate, default is unlimited.
+	OptionRecvRate = "RECV-RATE"
+
+	// OptionConnSendRate limits the outbound throughput of every single
+	// connection separately.  It can be set on a Socket, and overridden on
+	// a Dialer or Listener.
+	// Value is nano.Rate, default is unlimited.
+	OptionConnSendRate = "CONN-SEND-RATE"
+
+	// OptionConnRecvRate is like OptionConnSendRate for inbound messages.
+	// Value is nano.Rate, default is unlimited.
+	OptionConnRecvRate = "CONN-RECV-RATE"
+
+	// OptionWebSocketMux is used by websocket listeners to mount the
+	// endpoint on an existing http.ServeMux at the path of the address,
+	// so that several sockets can share one HTTP port.  The application
+	// is then responsible for serving the mux, and for TLS if wss is used.
+	// Value is *http.ServeMux.
+	OptionWebSocketMux = "WEBSOCKET-MUX"
+
+	// OptionWebSocketCheckOrigin is used by websocket listeners to refuse
+	// cross origin requests from browsers.
+	// Value is bool, default is true.
+	OptionWebSocketCheckOrigin = "WEBSOCKET-CHECK-ORIGIN"
+
+	// OptionIpcMode is used by ipc listeners to set the permissions of
+	// the socket file, e.g. 0660 to restrict access to a group.
+	// Value is os.FileMode, default is to leave it to the umask.
+	OptionIpcMode = "IPC-MODE"
+
+	// OptionIpcOwner is used by ipc listeners to set the owner of the
+	// socket file.  Value is a string "user[:group]", names or numeric
+	// ids; changing the user usually requires root.
+	OptionIpcOwner = "IPC-OWNER"
+
+	// OptionShmSize is the size of each of the two rings, one per
+	// direction, that a shm dialer sets up for a connection.  Messages
+	// larger than a ring still go through, in pieces.
+	// Value is int bytes, a power of two of at least 4KB, default 1MB.
+	OptionShmSize = "SHM-SIZE"
+
+	// OptionListenFd makes a listener adopt an already open listening
+	// socket instead of binding its address.  Value is an int descriptor,
+	// which the listener takes over, or an *os.File, which stays the
+	// caller's.  Without it, listeners adopt a matching socket inherited
+	// from systemd or a parent process, see ListenFds.
+	OptionListenFd = "LISTEN-FD"
+
+	// OptionReplaySpeed is how fast the replay transport plays a capture
+	// back.  Value is a float64, 1 for the original speed, 10 for ten
+	// times faster, 0 for no delays at all.  Default 1.
+	OptionReplaySpeed = "REPLAY-SPEED"
+
+	// OptionGossipPeers is the most peers a gossip socket pushes messages
+	// to, sampled at random from its connections.  Value is int, default 10.
+	OptionGossipPeers = "GOSSIP-PEERS"
+
+	// OptionGossipFanout is how many of its active peers a gossip socket
+	// pushes each new message to.  Value is int, default 3.
+	OptionGossipFanout = "GOSSIP-FANOUT"
+
+	// OptionGossipInterval is the period of the gossip anti-entropy, in
+	// which a socket compares the messages it has with a random peer.
+	// Value is a time.Duration, default one second.
+	OptionGossipInterval = "GOSSIP-INTERVAL"
+
+	// OptionGossipRetention is how long a gossip socket remembers
+	// messages, to drop duplicates and to repair peers that miss them.
+	// Older messages are dropped.  Value is a time.Duration, default one
+	// minute.
+	OptionGossipRetention = "GOSSIP-RETENTION"
+
+	// OptionLogDir is the directory an XPUB socket keeps its message log
+	// in, one subdirectory per topic.  Value is a string.  It must be set,
+	// once, before the first Send.
+	OptionLogDir = "LOG-DIR"
+
+	// OptionLogSegmentSize is the size at which XPUB starts a new segment
+	// file of a topic log.  Value is int bytes, default 64MB.
+	OptionLogSegmentSize = "LOG-SEGMENT-SIZE"
+
+	// OptionLogRetentionSize is the size beyond which XPUB deletes the
+	// oldest segments of a topic log.  Value is int bytes, 0 for no limit.
+	// Default 1GB.
+	OptionLogRetentionSize = "LOG-RETENTION-SIZE"
+
+	// OptionLogRetentionTime is the age beyond which XPUB deletes the
+	// segments of a topic log.  Value is a time.Duration, 0 for no limit.
+	// Default one week.
+	OptionLogRetentionTime = "LOG-RETENTION-TIME"
+
+	// OptionLogSync makes XPUB sync the log to disk before Send returns,
+	// so that messages also survive a crash of the machine.  Value is a
+	// bool, default false.
+	OptionLogSync = "LOG-SYNC"
+
+	// OptionAntiReplay makes BUS carry the origin, sequence number and
+	// hops left of each message, and drop the duplicates and those out
+	// of hops (see OptionTtl), so that devices can join buses into
+	// meshes with cycles.  STAR always does.  It changes what is sent,
+	// so all the members must have it.  Value is a bool, default false.
+	OptionAntiReplay = "ANTI-REPLAY"
+
+	// OptionAntiReplayWindow is how many of the last sequence numbers of
+	// each origin are remembered to tell duplicates apart.  Older
+	// messages are dropped.  Value is an int, default 1024.
+	OptionAntiReplayWindow = "ANTI-REPLAY-WINDOW"
+
+	// OptionStormStats returns the messages dropped as duplicates or out
+	// of hops, see OptionAntiReplay.  Value is a StormStats, read only.
+	OptionStormStats = "STORM-STATS"
 )
 
 // Useful constants for protocol numbers.  Note that the major protocol number
@@ -183,10 +423,15 @@ const (
 	ProtoXSub    = uint16(protoXPubSub*16) + 1
 
 	// Experimental Protocols - Use at Risk
-	ProtoStar = uint16(100*16) + 0
+	//
+	// Family 100 is reserved for the first version of STAR, without the
+	// storm headers its second version carries: the two must not connect.
+	ProtoStar   = uint16(102*16) + 0
+	ProtoGossip = uint16(101*16) + 0
 )
 
 const (
 	PortActionAdd = iota
 	PortActionRemove
+	PortActionReject
 )
@@ -2,6 +2,7 @@ package nano
 
 import (
 	"bytes"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -39,9 +40,14 @@ type socket struct {
 	// we store eps so that socket close will gracefully close all endpoints.
 	eps []*pipeEndpoint
 
-	sendHook ProtocolSendHook // hook on sendMsg
-	recvHook ProtocolRecvHook // hook on recvMsg
-	portHook PortHook         // hook on port add/remove
+	listeners []*listener // listening, for ListenerFiles
+
+	limits rateLimits // bandwidth throttling
+
+	sendHook ProtocolSendHook   // hook on sendMsg
+	syncSend ProtocolSyncSender // takes sendMsg over
+	recvHook ProtocolRecvHook   // hook on recvMsg
+	portHook PortHook           // hook on port add/remove
 }
 
 // MakeSocket is intended for use by Protocol implementations.  The intention
@@ -70,6 +76,9 @@ func MakeSocket(proto Protocol) Socket {
 	if hook, ok := proto.(ProtocolSendHook); ok {
 		sock.sendHook = hook
 	}
+	if sender, ok := proto.(ProtocolSyncSender); ok {
+		sock.syncSend = sender
+	}
 
 	proto.Init(sock)
 
@@ -92,25 +101,29 @@ func (sock *socket) Dial(addr string) error {
 }
 
 func (sock *socket) NewDialer(addr string, options map[string]interface{}) (Dialer, error) {
-	t, e := sock.getTransport(addr)
-	if e != nil {
-		return nil, e
+	t, err := sock.getTransport(addr)
+	if err != nil {
+		return nil, err
+	}
+	taddr, qopts, err := splitAddrOptions(addr, keepsQuery(t))
+	if err != nil {
+		return nil, err
 	}
 
-	var (
-		err error
-		d   = &dialer{
-			sock: sock,
-			addr: addr,
-		}
-	)
-	if d.d, err = t.NewDialer(addr, sock.proto); err != nil {
+	d := &dialer{
+		sock: sock,
+		addr: addr,
+	}
+	if d.d, err = t.NewDialer(taddr, sock.proto); err != nil {
 		return nil, err
 	}
 
-	for n, v := range options {
-		if err = d.d.SetOption(n, v); err != nil {
-			return nil, err
+	// explicit options override those of the address
+	for _, opts := range []map[string]interface{}{qopts, options} {
+		for n, v := range opts {
+			if err = d.SetOption(n, v); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -138,30 +151,38 @@ func (sock *socket) NewListener(addr string, options map[string]interface{}) (Li
 	// connections.  The Listener just needs to listen continuously,
 	// as we assume that we want to continue to receive inbound
 	// connections without limit.
-	t, e := sock.getTransport(addr)
-	if e != nil {
-		return nil, e
+	t, err := sock.getTransport(addr)
+	if err != nil {
+		return nil, err
+	}
+	taddr, qopts, err := splitAddrOptions(addr, keepsQuery(t))
+	if err != nil {
+		return nil, err
 	}
 
 	l := &listener{
 		sock: sock,
 		addr: addr,
 	}
-	var err error
-	l.l, err = t.NewListener(addr, sock.proto)
+	l.admit = newAdmission(l)
+	l.l, err = t.NewListener(taddr, sock.proto)
 	if err != nil {
 		return nil, err
 	}
 
-	for n, v := range options {
-		if err = l.l.SetOption(n, v); err != nil {
-			l.l.Close()
-			return nil, err
+	// explicit options override those of the address
+	for _, opts := range []map[string]interface{}{qopts, options} {
+		for n, v := range opts {
+			if err = l.SetOption(n, v); err != nil {
+				l.l.Close()
+				return nil, err
+			}
 		}
 	}
 
 	// avoid problem of listener constantly grows eps when many
-	// concurrent conns dial in
+	// concurrent conns dial in.  This is only a capacity hint, use
+	// OptionMaxConnections to actually limit inbound connections.
 	sock.eps = make([]*pipeEndpoint, 0, defaultServerEpsCap)
 
 	return l, nil
@@ -181,9 +202,18 @@ func (sock *socket) getTransport(addr string) (Transport, error) {
 		return t, nil
 	}
 
+	if t, present = registeredTransport(scheme); present {
+		return t, nil
+	}
 	return nil, ErrBadTran
 }
 
+// keepsQuery tells whether t reads the query of its addresses itself.
+func keepsQuery(t Transport) bool {
+	qt, ok := t.(QueryTransport)
+	return ok && qt.KeepsQuery()
+}
+
 func (sock *socket) AddTransport(t Transport) {
 	sock.Lock()
 	sock.transports[t.Scheme()] = t
@@ -228,8 +258,15 @@ func (sock *socket) Close() error {
 	close(sock.closeChan) // broadcast
 
 	eps := append([]*pipeEndpoint{}, sock.eps...)
+	listeners := sock.listeners
+	sock.listeners = nil
 	sock.Unlock()
 
+	// no new connections while draining
+	for _, l := range listeners {
+		l.l.Close()
+	}
+
 	// A second drain, just to be sure.  (We could have had device or
 	// forwarded messages arrive since the last one.)
 	DrainChannel(sock.sendChan, expire)
@@ -267,6 +304,10 @@ func (sock *socket) SendMsg(msg *Message) error {
 		Debugf("after sendHook: %+v", *msg)
 	}
 
+	if sock.syncSend != nil {
+		return sock.syncSend.SyncSend(msg)
+	}
+
 	select {
 	case <-mkTimer(sock.writeDeadline):
 		return ErrSendTimeout
@@ -368,6 +409,9 @@ func (sock *socket) SetOption(name string, value interface{}) error {
 	// set socket option
 	sock.Lock()
 	defer sock.Unlock()
+	if rateOpts[name] {
+		return sock.limits.set(name, value)
+	}
 	switch name {
 	case OptionRecvDeadline:
 		sock.readDeadline = value.(time.Duration)
@@ -427,6 +471,9 @@ func (sock *socket) GetOption(name string) (interface{}, error) {
 
 	sock.Lock()
 	defer sock.Unlock()
+	if rateOpts[name] {
+		return sock.limits.get(name)
+	}
 	switch name {
 	case OptionRecvDeadline:
 		return sock.readDeadline, nil
@@ -450,6 +497,42 @@ func (sock *socket) GetProtocol() Protocol {
 	return sock.proto
 }
 
+func (sock *socket) OpenContext() (Context, error) {
+	sock.RLock()
+	closing := sock.closing
+	sock.RUnlock()
+	if closing {
+		return nil, ErrClosed
+	}
+
+	if c, ok := sock.proto.(ProtocolContexter); ok {
+		return c.OpenContext()
+	}
+	return nil, ErrProtoOp
+}
+
+func (sock *socket) ListenerFiles() ([]*os.File, error) {
+	sock.RLock()
+	listeners := append([]*listener(nil), sock.listeners...)
+	sock.RUnlock()
+
+	var files []*os.File
+	for _, l := range listeners {
+		fs, err := l.Files()
+		if err == ErrNoListenFd {
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, fs...)
+	}
+	return files, nil
+}
+
 func (sock *socket) SetPortHook(newhook PortHook) PortHook {
 	sock.Lock()
 	oldhook := sock.portHook
@@ -470,6 +553,7 @@ func (sock *socket) addPipe(connPipe Pipe, d *dialer, l *listener) *pipeEndpoint
 		sock.Lock()
 	}
 	p.sock = sock
+	p.setThrottles(&sock.limits)
 	p.index = len(sock.eps)
 	sock.eps = append(sock.eps, p)
 	sock.Unlock()
@@ -0,0 +1,314 @@
+package nano
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// admission implements the ConnGate interface on behalf of a listener.
+// It enforces connection limits, accept rate and CIDR filtering before
+// the SP handshake takes place.
+type admission struct {
+	l *listener
+
+	maxConns  int
+	maxPerIP  int
+	rate      int
+	bucket    *tokenBucket
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	allowOpts []string
+	denyOpts  []string
+
+	conns int            // currently admitted connections
+	perIP map[string]int // currently admitted connections by source IP
+
+	sync.Mutex
+}
+
+func newAdmission(l *listener) *admission {
+	return &admission{
+		l:     l,
+		perIP: make(map[string]int),
+	}
+}
+
+var admissionOpts = map[string]bool{
+	OptionMaxConnections: true,
+	OptionMaxConnsPerIP:  true,
+	OptionAcceptRate:     true,
+	OptionAllowCIDR:      true,
+	OptionDenyCIDR:       true,
+}
+
+// enabled returns true if any admission option is in effect.
+func (this *admission) enabled() bool {
+	return this.maxConns > 0 || this.maxPerIP > 0 || this.rate > 0 ||
+		len(this.allow) > 0 || len(this.deny) > 0
+}
+
+func (this *admission) set(name string, val interface{}) error {
+	this.Lock()
+	defer this.Unlock()
+
+	switch name {
+	case OptionMaxConnections, OptionMaxConnsPerIP, OptionAcceptRate:
+		v, ok := val.(int)
+		if !ok || v < 0 {
+			return ErrBadValue
+		}
+		switch name {
+		case OptionMaxConnections:
+			this.maxConns = v
+		case OptionMaxConnsPerIP:
+			this.maxPerIP = v
+		case OptionAcceptRate:
+			this.rate = v
+			this.bucket = nil
+			if v > 0 {
+				this.bucket = newTokenBucket(v, v)
+			}
+		}
+		return nil
+
+	case OptionAllowCIDR, OptionDenyCIDR:
+		var cidrs []string
+		switch v := val.(type) {
+		case string:
+			cidrs = []string{v}
+		case []string:
+			cidrs = v
+		default:
+			return ErrBadValue
+		}
+
+		nets := make([]*net.IPNet, 0, len(cidrs))
+		for _, cidr := range cidrs {
+			_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				return ErrBadValue
+			}
+			nets = append(nets, n)
+		}
+		if name == OptionAllowCIDR {
+			this.allow, this.allowOpts = nets, cidrs
+		} else {
+			this.deny, this.denyOpts = nets, cidrs
+		}
+		return nil
+	}
+
+	return ErrBadOption
+}
+
+func (this *admission) get(name string) (interface{}, error) {
+	this.Lock()
+	defer this.Unlock()
+
+	switch name {
+	case OptionMaxConnections:
+		return this.maxConns, nil
+	case OptionMaxConnsPerIP:
+		return this.maxPerIP, nil
+	case OptionAcceptRate:
+		return this.rate, nil
+	case OptionAllowCIDR:
+		return this.allowOpts, nil
+	case OptionDenyCIDR:
+		return this.denyOpts, nil
+	}
+	return nil, ErrBadOption
+}
+
+// Admit implements the ConnGate Admit method.
+func (this *admission) Admit(conn net.Conn) (net.Conn, error) {
+	key, ip := admissionKey(conn.RemoteAddr())
+
+	this.Lock()
+	err := this.check(key, ip)
+	if err == nil {
+		this.conns++
+		if key != "" {
+			this.perIP[key]++
+		}
+	}
+	this.Unlock()
+
+	if err != nil {
+		Debugf("reject %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		this.reject(conn, err)
+		return nil, err
+	}
+
+	return &admittedConn{Conn: conn, a: this, key: key}, nil
+}
+
+// check must be called with the lock held.
+func (this *admission) check(key string, ip net.IP) error {
+	// CIDR filtering only applies to IP based transports.
+	if ip != nil {
+		for _, n := range this.deny {
+			if n.Contains(ip) {
+				return ErrAddrDenied
+			}
+		}
+		if len(this.allow) > 0 {
+			allowed := false
+			for _, n := range this.allow {
+				if n.Contains(ip) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return ErrAddrDenied
+			}
+		}
+	}
+
+	if this.maxConns > 0 && this.conns >= this.maxConns {
+		return ErrConnLimit
+	}
+	if this.maxPerIP > 0 && key != "" && this.perIP[key] >= this.maxPerIP {
+		return ErrConnLimit
+	}
+
+	// Consume a token last, so that refused connections don't eat
+	// into the rate of legitimate ones.
+	if this.bucket != nil && !this.bucket.take(1) {
+		return ErrAcceptRate
+	}
+
+	return nil
+}
+
+func (this *admission) release(key string) {
+	this.Lock()
+	this.conns--
+	if key != "" {
+		if n := this.perIP[key] - 1; n > 0 {
+			this.perIP[key] = n
+		} else {
+			delete(this.perIP, key)
+		}
+	}
+	this.Unlock()
+}
+
+// reject reports the refused connection through the socket port hook.
+func (this *admission) reject(conn net.Conn, reason error) {
+	sock := this.l.sock
+	sock.RLock()
+	hook := sock.portHook
+	sock.RUnlock()
+	if hook == nil {
+		return
+	}
+
+	hook(PortActionReject, &rejectedPort{
+		l:      this.l,
+		local:  conn.LocalAddr(),
+		remote: conn.RemoteAddr(),
+		reason: reason,
+	})
+}
+
+// admissionKey returns the per source accounting key, and the source IP,
+// if the address is IP based.  Other addresses, e.g. those of unix
+// sockets, which are empty for unbound clients, have no key.
+func admissionKey(addr net.Addr) (string, net.IP) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String(), a.IP
+	case *net.UDPAddr:
+		return a.IP.String(), a.IP
+	case *net.IPAddr:
+		return a.IP.String(), a.IP
+	}
+
+	if addr == nil {
+		return "", nil
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			return ip.String(), ip
+		}
+	}
+	return "", nil
+}
+
+// admittedConn releases its admission slot when closed.
+type admittedConn struct {
+	net.Conn
+
+	a    *admission
+	key  string
+	once sync.Once
+}
+
+func (this *admittedConn) Close() error {
+	this.once.Do(func() {
+		this.a.release(this.key)
+	})
+	return this.Conn.Close()
+}
+
+// rejectedPort implements the Port interface for a connection refused by
+// admission control.  It is only ever handed to a PortHook.
+type rejectedPort struct {
+	l      *listener
+	local  net.Addr
+	remote net.Addr
+	reason error
+}
+
+func (this *rejectedPort) Address() string {
+	return this.l.Address()
+}
+
+func (this *rejectedPort) GetProp(name string) (interface{}, error) {
+	switch name {
+	case PropLocalAddr:
+		return this.local, nil
+	case PropRemoteAddr:
+		return this.remote, nil
+	case PropRejectReason:
+		return this.reason, nil
+	}
+	return nil, ErrBadProperty
+}
+
+func (*rejectedPort) IsOpen() bool {
+	return false
+}
+
+func (*rejectedPort) Close() error {
+	return nil
+}
+
+func (*rejectedPort) IsServer() bool {
+	return true
+}
+
+func (*rejectedPort) IsClient() bool {
+	return false
+}
+
+func (this *rejectedPort) LocalProtocol() uint16 {
+	return this.l.sock.proto.Number()
+}
+
+func (*rejectedPort) RemoteProtocol() uint16 {
+	// the handshake never happened
+	return 0
+}
+
+func (*rejectedPort) Dialer() Dialer {
+	return nil
+}
+
+func (this *rejectedPort) Listener() Listener {
+	return this.l
+}
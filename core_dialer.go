@@ -13,6 +13,7 @@ type dialer struct {
 	closed    bool
 	active    bool
 	closeChan chan struct{}
+	limits    rateLimits
 }
 
 func (this *dialer) Dial() error {
@@ -27,6 +28,15 @@ func (this *dialer) Dial() error {
 	this.sock.Unlock()
 
 	this.closeChan = make(chan struct{})
+	if cd, ok := this.d.(ClosablePipeDialer); ok {
+		go func() {
+			select {
+			case <-this.closeChan:
+			case <-this.sock.closeChan:
+			}
+			cd.Close()
+		}()
+	}
 
 	Debugf("sock is active, go dialing...")
 
@@ -103,10 +113,21 @@ func (this *dialer) Close() error {
 }
 
 func (this *dialer) GetOption(name string) (interface{}, error) {
+	if rateOpts[name] {
+		this.sock.Lock()
+		defer this.sock.Unlock()
+		return this.limits.get(name)
+	}
 	return this.d.GetOption(name)
 }
 
 func (this *dialer) SetOption(name string, val interface{}) error {
+	if rateOpts[name] {
+		// endpoints read the limits under the socket lock
+		this.sock.Lock()
+		defer this.sock.Unlock()
+		return this.limits.set(name, val)
+	}
 	return this.d.SetOption(name, val)
 }
 
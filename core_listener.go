@@ -1,29 +1,48 @@
 package nano
 
+import (
+	"os"
+)
+
 // listener implements the Listener interface.
 type listener struct {
 	l PipeListener // created by Transport
 
-	sock *socket // local bind addr
-	addr string
+	sock   *socket // local bind addr
+	addr   string
+	admit  *admission
+	limits rateLimits
+	active bool
 }
 
 func (this *listener) Listen() error {
 	this.sock.Lock()
-	if this.sock.active {
+	if this.active {
 		this.sock.Unlock()
 		return ErrAddrInUse
 	}
 
+	// a socket may listen on several addresses, or on the same one
+	// several times with OptionReusePort
+	this.active = true
 	this.sock.active = true
 	this.sock.Unlock()
 
 	Debugf("sock is active")
 
+	if gl, ok := this.l.(GatedPipeListener); ok {
+		// always, for the admission options set while listening
+		gl.SetGate(this.admit)
+	}
+
 	if err := this.l.Listen(); err != nil {
 		return err
 	}
 
+	this.sock.Lock()
+	this.sock.listeners = append(this.sock.listeners, this)
+	this.sock.Unlock()
+
 	// keep serving connections
 	go this.serve()
 
@@ -61,10 +80,31 @@ func (l *listener) serve() {
 }
 
 func (this *listener) GetOption(name string) (interface{}, error) {
+	if admissionOpts[name] {
+		return this.admit.get(name)
+	}
+	if rateOpts[name] {
+		this.sock.Lock()
+		defer this.sock.Unlock()
+		return this.limits.get(name)
+	}
 	return this.l.GetOption(name)
 }
 
 func (this *listener) SetOption(name string, val interface{}) error {
+	if admissionOpts[name] {
+		if _, ok := this.l.(GatedPipeListener); !ok {
+			// transport cannot vet connections before handshake
+			return ErrBadOption
+		}
+		return this.admit.set(name, val)
+	}
+	if rateOpts[name] {
+		// endpoints read the limits under the socket lock
+		this.sock.Lock()
+		defer this.sock.Unlock()
+		return this.limits.set(name, val)
+	}
 	return this.l.SetOption(name, val)
 }
 
@@ -73,5 +113,22 @@ func (this *listener) Address() string {
 }
 
 func (this *listener) Close() error {
+	this.sock.Lock()
+	for i, l := range this.sock.listeners {
+		if l == this {
+			this.sock.listeners = append(this.sock.listeners[:i],
+				this.sock.listeners[i+1:]...)
+			break
+		}
+	}
+	this.sock.Unlock()
+
 	return this.l.Close()
 }
+
+func (this *listener) Files() ([]*os.File, error) {
+	if fl, ok := this.l.(FilePipeListener); ok {
+		return fl.Files()
+	}
+	return nil, ErrNoListenFd
+}
@@ -44,6 +44,9 @@ type pipeEndpoint struct {
 	id        EndpointId
 	index     int
 
+	sendThrottles []*throttle // conn, dialer/listener, socket
+	recvThrottles []*throttle
+
 	sync.Mutex
 }
 
@@ -63,6 +66,9 @@ func newPipeEndpoint(connPipe Pipe, d *dialer, l *listener) *pipeEndpoint {
 		if _, present := endpointPool.byid[this.id]; !present {
 			endpointPool.byid[this.id] = this
 			endpointPool.Unlock()
+			if ip, ok := connPipe.(IdentifiedPipe); ok {
+				ip.SetEndpointId(this.id)
+			}
 			return this
 		}
 		endpointPool.Unlock()
@@ -71,6 +77,53 @@ func newPipeEndpoint(connPipe Pipe, d *dialer, l *listener) *pipeEndpoint {
 	return this
 }
 
+// setThrottles resolves the rate limits that apply to this endpoint.
+// Per connection rates of the dialer or listener override those of the
+// socket.  It must be called with the socket lock held.
+func (this *pipeEndpoint) setThrottles(sockLimits *rateLimits) {
+	var limits *rateLimits
+	switch {
+	case this.dialer != nil:
+		limits = &this.dialer.limits
+	case this.listener != nil:
+		limits = &this.listener.limits
+	default:
+		limits = &rateLimits{}
+	}
+
+	connSend, connRecv := sockLimits.connSend, sockLimits.connRecv
+	if limits.connSend != (Rate{}) {
+		connSend = limits.connSend
+	}
+	if limits.connRecv != (Rate{}) {
+		connRecv = limits.connRecv
+	}
+
+	this.sendThrottles = appendThrottles(nil,
+		newThrottle(connSend), limits.send, sockLimits.send)
+	this.recvThrottles = appendThrottles(nil,
+		newThrottle(connRecv), limits.recv, sockLimits.recv)
+}
+
+func appendThrottles(ts []*throttle, more ...*throttle) []*throttle {
+	for _, t := range more {
+		if t != nil {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// waitThrottles waits till all the throttles let a message of sz bytes pass.
+func (this *pipeEndpoint) waitThrottles(ts []*throttle, sz int) bool {
+	for _, t := range ts {
+		if !t.wait(sz, this.closeChan) {
+			return false
+		}
+	}
+	return true
+}
+
 func (this *pipeEndpoint) Id() EndpointId {
 	return this.id
 }
@@ -134,6 +187,12 @@ func (this *pipeEndpoint) Flush() error {
 
 func (this *pipeEndpoint) SendMsg(msg *Message) error {
 	Debugf("msg: %+v, calling %T.SendMsg", *msg, this.pipe)
+	if len(this.sendThrottles) > 0 &&
+		!this.waitThrottles(this.sendThrottles, len(msg.Header)+len(msg.Body)) {
+		msg.Free()
+		return ErrClosed
+	}
+
 	if err := this.pipe.SendMsg(msg); err != nil {
 		// FIXME error will lead to close?
 		this.Close()
@@ -155,6 +214,13 @@ func (this *pipeEndpoint) RecvMsg() *Message {
 		return nil
 	}
 
+	if len(this.recvThrottles) > 0 &&
+		!this.waitThrottles(this.recvThrottles, len(msg.Header)+len(msg.Body)) {
+		// endpoint closed while throttled
+		msg.Free()
+		return nil
+	}
+
 	Debugf("RecvMsg: %+v", *msg)
 	return msg
 }
@@ -26,4 +26,13 @@ var (
 	ErrBadProperty = errors.New("invalid property name")
 	ErrTlsNoConfig = errors.New("missing TLS configuration")
 	ErrTlsNoCert   = errors.New("missing TLS certificates")
+	ErrConnLimit   = errors.New("connection limit exceeded")
+	ErrAcceptRate  = errors.New("accept rate exceeded")
+	ErrAddrDenied  = errors.New("address denied")
+	ErrFdPassing   = errors.New("file descriptors cannot be passed")
+	ErrNoListenFd  = errors.New("listener has no file descriptor")
+	ErrProxyAuth   = errors.New("proxy authentication failed")
+	ErrNoLogDir    = errors.New("no log directory")
+	ErrNoTopic     = errors.New("message has no topic")
+	ErrNoQuorum    = errors.New("quorum not reached")
 )
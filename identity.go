@@ -0,0 +1,47 @@
+package nano
+
+import (
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+)
+
+// PeerIdentity is the verified identity of a TLS peer, taken from the
+// leaf certificate it presented.  It is the value of PropPeerIdentity,
+// for a PortHook to authorize on.
+type PeerIdentity struct {
+	Subject  pkix.Name
+	DNSNames []string
+	IPs      []net.IP
+	Emails   []string
+	URIs     []*url.URL
+
+	// SPIFFEID is the spiffe:// URI SAN, empty if there is none.
+	SPIFFEID string
+}
+
+// PeerIdentityOf returns the identity of the peer of a completed TLS
+// handshake, or nil if the peer presented no certificate or its
+// certificate chain was not verified.
+func PeerIdentityOf(state *tls.ConnectionState) *PeerIdentity {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	id := &PeerIdentity{
+		Subject:  cert.Subject,
+		DNSNames: cert.DNSNames,
+		IPs:      cert.IPAddresses,
+		Emails:   cert.EmailAddresses,
+		URIs:     cert.URIs,
+	}
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			id.SPIFFEID = u.String()
+			break
+		}
+	}
+	return id
+}
@@ -0,0 +1,138 @@
+package nano
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFdsStart is the first inherited descriptor, after stdio.
+const listenFdsStart = 3
+
+// inherited holds the listening sockets passed to the process, till
+// listeners adopt them.
+var inherited struct {
+	files []*os.File
+	once  sync.Once
+	sync.Mutex
+}
+
+// ListenFds returns the sockets inherited through the systemd socket
+// activation protocol: LISTEN_FDS descriptors starting at 3.  A parent
+// handing over with HandoffEnv cannot know the pid of its child, so a
+// missing LISTEN_PID is accepted; a LISTEN_PID of another process is not.
+// The environment is parsed, then cleared, on first use.  Sockets already
+// adopted by a listener are not returned.
+func ListenFds() []*os.File {
+	inherited.once.Do(loadListenFds)
+
+	inherited.Lock()
+	defer inherited.Unlock()
+	var r []*os.File
+	for _, f := range inherited.files {
+		if f != nil {
+			r = append(r, f)
+		}
+	}
+	return r
+}
+
+func loadListenFds() {
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	// not for our own children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		inherited.files = append(inherited.files,
+			os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd)))
+	}
+	Debugf("inherited %d listening sockets", n)
+}
+
+// TakeListenFd adopts the inherited listening socket for which match
+// returns true, so that no other listener can.  It returns nil if there
+// is none.  Transports call it before binding their address.
+func TakeListenFd(match func(net.Addr) bool) net.Listener {
+	inherited.once.Do(loadListenFds)
+
+	inherited.Lock()
+	defer inherited.Unlock()
+	for i, f := range inherited.files {
+		if f == nil {
+			continue
+		}
+		l, err := net.FileListener(f)
+		if err != nil {
+			// not a stream listener
+			continue
+		}
+		if match(l.Addr()) {
+			f.Close() // l has its own descriptor
+			inherited.files[i] = nil
+			return l
+		}
+		l.Close()
+	}
+	return nil
+}
+
+// FileListener makes a net.Listener of the value of OptionListenFd.
+func FileListener(v interface{}) (net.Listener, error) {
+	switch f := v.(type) {
+	case int:
+		file := os.NewFile(uintptr(f), "LISTEN_FD_"+strconv.Itoa(f))
+		defer file.Close()
+		return net.FileListener(file)
+	case *os.File:
+		return net.FileListener(f)
+	}
+	return nil, ErrBadValue
+}
+
+// HandoffEnv returns the environment for a child process that is given
+// files, e.g. from ListenerFiles, as its exec.Cmd ExtraFiles:
+//
+//	cmd.ExtraFiles = files
+//	cmd.Env = nano.HandoffEnv(files)
+//
+// The child adopts them with ListenFds, as it would with systemd.
+func HandoffEnv(files []*os.File) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "LISTEN_") {
+			env = append(env, kv)
+		}
+	}
+	return append(env, "LISTEN_FDS="+strconv.Itoa(len(files)))
+}
+
+// SameListenAddr tells if a listener bound to have can serve the address
+// want, for matching inherited sockets.  Unspecified IPs of either family
+// are equivalent.
+func SameListenAddr(have, want net.Addr) bool {
+	switch h := have.(type) {
+	case *net.TCPAddr:
+		w, ok := want.(*net.TCPAddr)
+		if !ok || h.Port != w.Port {
+			return false
+		}
+		hany := h.IP == nil || h.IP.IsUnspecified()
+		wany := w.IP == nil || w.IP.IsUnspecified()
+		return (hany && wany) || h.IP.Equal(w.IP)
+	case *net.UnixAddr:
+		w, ok := want.(*net.UnixAddr)
+		return ok && h.Name == w.Name
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+// +build windows plan9
+
+package nano
+
+import (
+	"os"
+	"syscall"
+)
+
+// ListenerFile is not supported on this platform.
+func ListenerFile(l syscall.Conn, name string) (*os.File, error) {
+	return nil, ErrNoListenFd
+}
@@ -0,0 +1,36 @@
+// +build !windows,!plan9
+
+package nano
+
+import (
+	"os"
+	"syscall"
+)
+
+// ListenerFile duplicates the descriptor of a listener for handing over.
+// Unlike the File method of net listeners, the file's Fd method leaves
+// the socket non-blocking: the descriptors share that flag, and a blocking
+// socket would tie up the Accept of the listener we still serve with.
+func ListenerFile(l syscall.Conn, name string) (*os.File, error) {
+	rc, err := l.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var nfd int
+	var derr error
+	err = rc.Control(func(fd uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if nfd, derr = syscall.Dup(int(fd)); derr == nil {
+			syscall.CloseOnExec(nfd)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if derr != nil {
+		return nil, os.NewSyscallError("dup", derr)
+	}
+	return os.NewFile(uintptr(nfd), name), nil
+}
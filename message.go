@@ -14,6 +14,11 @@ type Message struct {
 	Header []byte
 	Body   []byte
 
+	// Fds are file descriptors passed along with the message, which only
+	// the ipc transport supports.  The sender keeps its descriptors, the
+	// receiver gets new ones and is responsible for closing them.
+	Fds []int
+
 	headerBuf []byte
 	bodyBuf   []byte
 
@@ -103,6 +108,7 @@ func NewMessage(sz int) *Message {
 	msg.refCount = 1
 	msg.Body = msg.bodyBuf
 	msg.Header = msg.headerBuf
+	msg.Fds = nil
 	return msg
 }
 
@@ -120,3 +126,40 @@ func messagePoolWatchdog() {
 		}
 	}
 }
+
+// Handover prepares the message to be passed to a peer in the same
+// process without framing or copying, as the inproc transport does.
+// The receiving protocol expects the wire format, so Header is folded
+// into Body, in place if Body has spare capacity.  A message that is
+// shared (see Dup) is copied instead, since the receiver will modify it.
+// The caller gives up its reference in exchange for the returned one.
+// Applications should *NOT* make use of this function.
+func (this *Message) Handover() *Message {
+	if atomic.LoadInt32(&this.refCount) > 1 {
+		m := NewMessage(len(this.Header) + len(this.Body))
+		m.Body = append(m.Body, this.Header...)
+		m.Body = append(m.Body, this.Body...)
+		m.Fds = this.Fds
+		this.Free()
+		return m
+	}
+
+	if len(this.Header) == 0 {
+		// the common case: just a pointer changes hands
+		return this
+	}
+
+	hlen, blen := len(this.Header), len(this.Body)
+	if cap(this.Body) >= hlen+blen {
+		body := this.Body[:hlen+blen]
+		copy(body[hlen:], this.Body) // memmove
+		copy(body, this.Header)
+		this.Body = body
+	} else {
+		body := make([]byte, 0, hlen+blen)
+		body = append(body, this.Header...)
+		this.Body = append(body, this.Body...)
+	}
+	this.Header = this.Header[:0]
+	return this
+}
@@ -48,5 +48,7 @@ type PortAction int
 
 // PortHook is a function that is called when a port is added or removed to or
 // from a Socket.  In the case of PortActionAdd, the function may return false
-// to indicate that the port should not be added.
+// to indicate that the port should not be added.  PortActionReject reports
+// an inbound connection refused by listener admission control; the port is
+// already closed and the return value is ignored.
 type PortHook func(PortAction, Port) bool
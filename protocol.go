@@ -99,6 +99,25 @@ type ProtocolSendHook interface {
 	SendHook(*Message) bool
 }
 
+// ProtocolSyncSender is intended to be an additional extension to the
+// Protocol interface, for protocols that must take messages over before
+// Send returns, e.g. to persist them.
+type ProtocolSyncSender interface {
+	// SyncSend is called when the application calls Send, after the
+	// SendHook, instead of queueing the message on the send channel.  The
+	// protocol owns the message, and its error is returned to the
+	// application.
+	SyncSend(*Message) error
+}
+
+// ProtocolContexter is intended to be an additional extension to the
+// Protocol interface, for protocols that support Contexts.
+type ProtocolContexter interface {
+	// OpenContext is called when the application calls OpenContext on
+	// a Socket that is not closed.
+	OpenContext() (Context, error)
+}
+
 // ProtocolSocket is the "handle" given to protocols to interface with the
 // socket.  The Protocol implementation should not access any sockets or pipes
 // except by using functions made available on the ProtocolSocket.  Note
@@ -1,5 +1,9 @@
 // Package bus implements the BUS protocol.  In this protocol, participants
-// send a message to each of their peers.
+// send a message to each of their peers.  Devices join buses together;
+// with nano.OptionAntiReplay, messages carry their origin, a message ID
+// and the hops they have left, so that the duplicates coming back around
+// cycles, and those out of hops, are dropped instead of storming the
+// mesh.
 package bus
 
 import (
@@ -43,6 +47,19 @@ func (pe *busEp) receiver() {
 		m.Header = append(m.Header,
 			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
 
+		if guard := pe.x.stormGuard(); guard != nil {
+			if len(m.Body) < nano.StormHeaderLen {
+				m.Free() // ErrGarbled
+				continue
+			}
+			m.Header = append(m.Header, m.Body[:nano.StormHeaderLen]...)
+			m.Body = m.Body[nano.StormHeaderLen:]
+			if !guard.Admit(m.Header[4:]) {
+				m.Free()
+				continue
+			}
+		}
+
 		select {
 		case recvChan <- m:
 		case <-closeChan:
@@ -61,6 +78,10 @@ type bus struct {
 	raw   bool
 	w     nano.Waiter
 
+	antiReplay bool
+	ttl        int
+	guard      *nano.StormGuard
+
 	sync.Mutex
 }
 
@@ -68,6 +89,8 @@ type bus struct {
 func (x *bus) Init(sock nano.ProtocolSocket) {
 	x.sock = sock
 	x.peers = make(map[nano.EndpointId]*busEp)
+	x.ttl = 8
+	x.guard = nano.NewStormGuard()
 
 	x.w.Init()
 	x.w.Add()
@@ -121,6 +144,10 @@ func (x *bus) sender() {
 				id = nano.EndpointId(binary.BigEndian.Uint32(m.Header))
 				m.Header = m.Header[4:]
 			}
+			if !x.stamp(m) {
+				m.Free()
+				continue
+			}
 			x.broadcast(m, id)
 			m.Free()
 		}
@@ -151,6 +178,33 @@ func (x *bus) broadcast(m *nano.Message, sender nano.EndpointId) {
 	x.Unlock()
 }
 
+// stormGuard returns the guard if anti-replay is on, else nil.
+func (x *bus) stormGuard() *nano.StormGuard {
+	x.Lock()
+	defer x.Unlock()
+	if !x.antiReplay {
+		return nil
+	}
+	return x.guard
+}
+
+// stamp puts the anti-replay header on a message to send, if it is on.
+// A message passed on by a device has it already, with a hop less to go,
+// and false is returned if there are none left.
+func (x *bus) stamp(m *nano.Message) bool {
+	x.Lock()
+	on, raw, ttl := x.antiReplay, x.raw, x.ttl
+	x.Unlock()
+	if !on {
+		return true
+	}
+	if raw && len(m.Header) >= nano.StormHeaderLen {
+		return x.guard.Forward(m.Header)
+	}
+	m.Header = append(m.Header[:0], x.guard.Stamp(ttl)...)
+	return true
+}
+
 func (x *bus) Shutdown(expire time.Time) {
 	x.w.WaitAbsTimeout(expire)
 
@@ -175,13 +229,21 @@ func (*bus) PeerNumber() uint16 {
 }
 
 func (x *bus) RecvHook(m *nano.Message) bool {
-	if !x.raw && len(m.Header) >= 4 {
+	x.Lock()
+	raw, on := x.raw, x.antiReplay
+	x.Unlock()
+	if !raw && len(m.Header) >= 4 {
 		m.Header = m.Header[4:]
+		if on && len(m.Header) >= nano.StormHeaderLen {
+			m.Header = m.Header[nano.StormHeaderLen:]
+		}
 	}
 	return true
 }
 
 func (x *bus) SetOption(name string, v interface{}) error {
+	x.Lock()
+	defer x.Unlock()
 	var ok bool
 	switch name {
 	case nano.OptionRaw:
@@ -189,15 +251,43 @@ func (x *bus) SetOption(name string, v interface{}) error {
 			return nano.ErrBadValue
 		}
 		return nil
+	case nano.OptionAntiReplay:
+		if x.antiReplay, ok = v.(bool); !ok {
+			return nano.ErrBadValue
+		}
+		return nil
+	case nano.OptionTtl:
+		if ttl, ok := v.(int); !ok || ttl < 1 || ttl > 255 {
+			return nano.ErrBadValue
+		} else {
+			x.ttl = ttl
+		}
+		return nil
+	case nano.OptionAntiReplayWindow:
+		if n, ok := v.(int); !ok {
+			return nano.ErrBadValue
+		} else {
+			return x.guard.SetWindow(n)
+		}
 	default:
 		return nano.ErrBadOption
 	}
 }
 
 func (x *bus) GetOption(name string) (interface{}, error) {
+	x.Lock()
+	defer x.Unlock()
 	switch name {
 	case nano.OptionRaw:
 		return x.raw, nil
+	case nano.OptionAntiReplay:
+		return x.antiReplay, nil
+	case nano.OptionTtl:
+		return x.ttl, nil
+	case nano.OptionAntiReplayWindow:
+		return x.guard.Window(), nil
+	case nano.OptionStormStats:
+		return x.guard.Stats(), nil
 	default:
 		return nil, nano.ErrBadOption
 	}
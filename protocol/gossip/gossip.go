@@ -1,58 +1,563 @@
-// Package bus implements the GOSSIP protocol.  In this protocol, participants
-// send a message to each of their peers.
+// Package gossip implements the GOSSIP protocol.  In this protocol, every
+// message that a participant sends eventually reaches all participants,
+// though each one only connects to a few others, and the topology may
+// have cycles.
+//
+// A participant pushes a new message to a few random peers, the fan-out,
+// and every participant forwards the messages it had not seen before the
+// same way, for up to OptionTtl hops.  Messages are pushed to active peers
+// only, a random sample of at most OptionGossipPeers connected peers that
+// is reshuffled once per round, so that large meshes are not flooded.
+// Every message carries a random 64 bit ID, by which duplicates are
+// dropped.
+//
+// Participants remember the IDs of the messages of the last
+// OptionGossipRetention, and the last 4096 of these messages.  Once every
+// OptionGossipInterval, a participant sends the IDs it has to a random
+// active peer, which answers with the messages missing from the list and
+// asks for those it misses itself.  This anti-entropy repairs what pushing
+// lost to full queues or broken connections, and brings participants that
+// join late up to date.  Messages older than the retention are dropped,
+// they could be duplicates forgotten already, so the clocks of the
+// participants must roughly agree.
+//
+// Messages are delivered once, in no particular order, and not to the
+// participant that sent them.
 package gossip
 
 import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/funkygao/nano"
 )
 
-type peerEp struct{}
+// Frame types, in the first byte of every message between peers.
+const (
+	frameData    = 0 // type, hops, 2 reserved, id, origin time, payload
+	frameDigest  = 1 // type, 3 reserved, the ids the sender has
+	frameRequest = 2 // type, 3 reserved, the ids the sender wants
+
+	dataHeaderLen = 20
+)
+
+// maxStored bounds the number of messages remembered for anti-entropy,
+// which keeps a digest within 32KB.  Their IDs are remembered regardless.
+const maxStored = 4096
+
+// entry is a remembered message.
+type entry struct {
+	id   uint64
+	born int64 // unix nanoseconds, on the clock of the sender
+	body []byte
+}
+
+// frame makes a data frame of the message, to be forwarded hops times.
+func (this *entry) frame(hops int) *nano.Message {
+	m := nano.NewMessage(dataHeaderLen + len(this.body))
+	m.Body = append(m.Body, frameData, byte(hops), 0, 0)
+	m.Body = appendUint64(m.Body, this.id)
+	m.Body = appendUint64(m.Body, uint64(this.born))
+	m.Body = append(m.Body, this.body...)
+	return m
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+type peerEp struct {
+	ep     nano.Endpoint
+	q      chan *nano.Message
+	x      *gossip
+	active bool
+	closed bool
+}
+
+func (this *peerEp) sender() {
+	for {
+		m := <-this.q
+		if m == nil {
+			return
+		}
+
+		if this.ep.SendMsg(m) != nil {
+			m.Free()
+			return
+		}
+	}
+}
+
+func (this *peerEp) receiver() {
+	for {
+		m := this.ep.RecvMsg()
+		if m == nil {
+			return
+		}
+		if len(m.Body) < 4 {
+			m.Free() // ErrGarbled
+			continue
+		}
+
+		switch m.Body[0] {
+		case frameData:
+			if !this.x.recvData(this, m) {
+				return
+			}
+		case frameDigest:
+			this.x.recvDigest(this, m.Body[4:])
+			m.Free()
+		case frameRequest:
+			this.x.recvRequest(this, m.Body[4:])
+			m.Free()
+		default:
+			m.Free() // ErrGarbled
+		}
+	}
+}
 
 type gossip struct {
 	sock nano.ProtocolSocket
 
-	maxPeers     int           // maximum number of connected gossip peers
-	testInterval time.Duration //
+	maxPeers  int           // maximum number of active gossip peers
+	fanout    int           // active peers a new message is pushed to
+	ttl       int           // hops a message is pushed along
+	interval  time.Duration // anti-entropy period
+	retention time.Duration // how long messages are remembered
+
+	peers  map[nano.EndpointId]*peerEp
+	active []*peerEp
+	seen   map[uint64]int64  // the ids within the retention, to their born
+	stored map[uint64]*entry // the last maxStored messages of these
+	order  []*entry          // the stored entries, oldest first
+	rnd    *rand.Rand
+	w      nano.Waiter
 
-	peers map[nano.EndpointId]peerEp
+	sync.Mutex
 }
 
+// Init implements the Protocol Init method.
 func (this *gossip) Init(sock nano.ProtocolSocket) {
 	this.sock = sock
 	this.maxPeers = 10
-	this.testInterval = time.Millisecond * 10
+	this.fanout = 3
+	this.ttl = 8
+	this.interval = time.Second
+	this.retention = time.Minute
+	this.peers = make(map[nano.EndpointId]*peerEp)
+	this.seen = make(map[uint64]int64)
+	this.stored = make(map[uint64]*entry)
+
+	// message ids must not collide with those of other participants
+	var seed [8]byte
+	if _, err := crand.Read(seed[:]); err != nil {
+		binary.BigEndian.PutUint64(seed[:], uint64(time.Now().UnixNano()))
+	}
+	this.rnd = rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(seed[:]))))
+
+	this.w.Init()
+	this.w.Add()
+	go this.sender()
 }
 
 func (this *gossip) AddEndpoint(ep nano.Endpoint) {
+	qlen := 16
+	if i, err := this.sock.GetOption(nano.OptionWriteQLen); err == nil {
+		qlen = i.(int)
+	}
+	pe := &peerEp{
+		ep: ep,
+		x:  this,
+		q:  make(chan *nano.Message, qlen),
+	}
+	this.Lock()
+	this.peers[ep.Id()] = pe
+	this.sample()
+	this.Unlock()
 
+	go pe.sender()
+	go pe.receiver()
 }
 
 func (this *gossip) RemoveEndpoint(ep nano.Endpoint) {
+	this.Lock()
+	if pe := this.peers[ep.Id()]; pe != nil {
+		delete(this.peers, ep.Id())
+		if pe.active {
+			this.deactivate(pe)
+			this.sample()
+		}
+		pe.closed = true
+		close(pe.q)
+	}
+	this.Unlock()
+}
+
+// sender publishes what the application sends, and runs the anti-entropy
+// rounds.
+func (this *gossip) sender() {
+	defer this.w.Done()
+	sendChan := this.sock.SendChannel()
+	closeChan := this.sock.CloseChannel()
+	timer := time.NewTimer(this.getInterval())
+	defer timer.Stop()
+	for {
+		select {
+		case <-closeChan:
+			return
+
+		case m := <-sendChan:
+			this.publish(m)
+
+		case <-timer.C:
+			this.antiEntropy()
+			timer.Reset(this.getInterval())
+		}
+	}
+}
+
+func (this *gossip) getInterval() time.Duration {
+	this.Lock()
+	defer this.Unlock()
+	return this.interval
+}
+
+func (this *gossip) publish(m *nano.Message) {
+	this.Lock()
+	e := this.remember(this.rnd.Uint64(), time.Now().UnixNano(), m.Body)
+	if e != nil {
+		this.push(e, this.ttl, nil)
+	}
+	this.Unlock()
+	m.Free()
+}
+
+// recvData handles a data frame from a peer, and returns false if the
+// socket is closing.
+func (this *gossip) recvData(from *peerEp, m *nano.Message) bool {
+	if len(m.Body) < dataHeaderLen {
+		m.Free() // ErrGarbled
+		return true
+	}
+	hops := int(m.Body[1])
+	id := binary.BigEndian.Uint64(m.Body[4:])
+	born := int64(binary.BigEndian.Uint64(m.Body[12:]))
+	m.Body = m.Body[dataHeaderLen:]
+
+	this.Lock()
+	e := this.remember(id, born, m.Body)
+	if e != nil && hops > 1 {
+		this.push(e, hops-1, from)
+	}
+	this.Unlock()
+	if e == nil {
+		m.Free() // duplicate, or too old to tell
+		return true
+	}
 
+	select {
+	case this.sock.RecvChannel() <- m:
+		return true
+	case <-this.sock.CloseChannel():
+		m.Free()
+		return false
+	}
+}
+
+// recvDigest answers a digest with the messages missing from it, and asks
+// for the messages in it that are missing here.
+func (this *gossip) recvDigest(from *peerEp, ids []byte) {
+	this.Lock()
+	defer this.Unlock()
+
+	theirs := make(map[uint64]bool, len(ids)/8)
+	req := nano.NewMessage(4 + len(ids))
+	req.Body = append(req.Body, frameRequest, 0, 0, 0)
+	for ; len(ids) >= 8; ids = ids[8:] {
+		id := binary.BigEndian.Uint64(ids)
+		theirs[id] = true
+		if _, ok := this.seen[id]; !ok {
+			req.Body = append(req.Body, ids[:8]...)
+		}
+	}
+
+	for _, e := range this.order {
+		if !theirs[e.id] {
+			this.enqueue(from, e.frame(1))
+		}
+	}
+	if len(req.Body) > 4 {
+		this.enqueue(from, req)
+	} else {
+		req.Free()
+	}
+}
+
+// recvRequest sends the requested messages that are still remembered.
+func (this *gossip) recvRequest(from *peerEp, ids []byte) {
+	this.Lock()
+	defer this.Unlock()
+	for ; len(ids) >= 8; ids = ids[8:] {
+		if e := this.stored[binary.BigEndian.Uint64(ids)]; e != nil {
+			this.enqueue(from, e.frame(1))
+		}
+	}
+}
+
+// antiEntropy forgets expired messages, reshuffles the active peers, and
+// sends a digest to one of them.
+func (this *gossip) antiEntropy() {
+	this.Lock()
+	defer this.Unlock()
+
+	this.expire(time.Now().UnixNano())
+	this.shuffle()
+	if len(this.active) == 0 {
+		return
+	}
+
+	// an empty digest is still sent, to have a new participant catch up
+	pe := this.active[this.rnd.Intn(len(this.active))]
+	m := nano.NewMessage(4 + 8*len(this.order))
+	m.Body = append(m.Body, frameDigest, 0, 0, 0)
+	for _, e := range this.order {
+		m.Body = appendUint64(m.Body, e.id)
+	}
+	this.enqueue(pe, m)
+}
+
+// remember records a message, and returns nil if it was seen already or
+// is older than the retention.  The caller holds the lock.
+func (this *gossip) remember(id uint64, born int64, body []byte) *entry {
+	if _, ok := this.seen[id]; ok ||
+		time.Now().UnixNano()-born > int64(this.retention) {
+		return nil
+	}
+	if len(this.order) >= maxStored {
+		// the id stays, till it expires
+		delete(this.stored, this.order[0].id)
+		this.order[0] = nil
+		this.order = this.order[1:]
+	}
+
+	e := &entry{id: id, born: born, body: append([]byte(nil), body...)}
+	this.seen[id] = born
+	this.stored[id] = e
+	this.order = append(this.order, e)
+	return e
+}
+
+// expire forgets the messages older than the retention.
+func (this *gossip) expire(now int64) {
+	for id, born := range this.seen {
+		if now-born > int64(this.retention) {
+			delete(this.seen, id)
+		}
+	}
+
+	kept := this.order[:0]
+	for _, e := range this.order {
+		if now-e.born > int64(this.retention) {
+			delete(this.stored, e.id)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	for i := len(kept); i < len(this.order); i++ {
+		this.order[i] = nil
+	}
+	this.order = kept
+}
+
+// push sends a message to fan-out random active peers, except to the peer
+// it came from.  The caller holds the lock.
+func (this *gossip) push(e *entry, hops int, from *peerEp) {
+	n := 0
+	var m *nano.Message
+	for _, i := range this.rnd.Perm(len(this.active)) {
+		if n >= this.fanout {
+			break
+		}
+		pe := this.active[i]
+		if pe == from {
+			continue
+		}
+		if m == nil {
+			m = e.frame(hops)
+		}
+		this.enqueue(pe, m.Dup())
+		n++
+	}
+	if m != nil {
+		m.Free()
+	}
+}
+
+// enqueue queues a message for a peer, or drops it if the queue is full:
+// the anti-entropy makes up for it.  The caller holds the lock.
+func (this *gossip) enqueue(pe *peerEp, m *nano.Message) {
+	if pe.closed {
+		m.Free()
+		return
+	}
+	select {
+	case pe.q <- m:
+	default:
+		m.Free()
+	}
+}
+
+// sample trims the active peers down to maxPeers, or fills them up from
+// the passive ones.  The caller holds the lock.
+func (this *gossip) sample() {
+	for len(this.active) > this.maxPeers {
+		this.deactivate(this.active[this.rnd.Intn(len(this.active))])
+	}
+	if len(this.active) == this.maxPeers || len(this.active) == len(this.peers) {
+		return
+	}
+
+	passive := this.passive()
+	for _, i := range this.rnd.Perm(len(passive)) {
+		if len(this.active) == this.maxPeers {
+			break
+		}
+		passive[i].active = true
+		this.active = append(this.active, passive[i])
+	}
+}
+
+// shuffle replaces a random active peer with a random passive one, so
+// that over time messages take all the paths there are.  The caller holds
+// the lock.
+func (this *gossip) shuffle() {
+	this.sample()
+	passive := this.passive()
+	if len(passive) == 0 || len(this.active) == 0 {
+		return
+	}
+	this.deactivate(this.active[this.rnd.Intn(len(this.active))])
+	pe := passive[this.rnd.Intn(len(passive))]
+	pe.active = true
+	this.active = append(this.active, pe)
+}
+
+func (this *gossip) passive() []*peerEp {
+	var passive []*peerEp
+	for _, pe := range this.peers {
+		if !pe.active {
+			passive = append(passive, pe)
+		}
+	}
+	return passive
+}
+
+func (this *gossip) deactivate(pe *peerEp) {
+	pe.active = false
+	for i, a := range this.active {
+		if a == pe {
+			last := len(this.active) - 1
+			this.active[i] = this.active[last]
+			this.active[last] = nil
+			this.active = this.active[:last]
+			return
+		}
+	}
 }
 
 func (this *gossip) Shutdown(expire time.Time) {
+	this.w.WaitAbsTimeout(expire)
+
+	this.Lock()
+	peers := this.peers
+	this.peers = make(map[nano.EndpointId]*peerEp)
+	this.active = nil
+	this.Unlock()
 
+	for id, pe := range peers {
+		nano.DrainChannel(pe.q, expire)
+		this.Lock()
+		pe.closed = true
+		close(pe.q)
+		this.Unlock()
+		delete(peers, id)
+	}
 }
 
 func (this *gossip) SetOption(name string, val interface{}) error {
+	this.Lock()
+	defer this.Unlock()
+	switch name {
+	case nano.OptionTtl:
+		if v, ok := val.(int); !ok || v < 1 || v > 255 {
+			return nano.ErrBadValue
+		} else {
+			this.ttl = v
+		}
+	case nano.OptionGossipPeers:
+		if v, ok := val.(int); !ok || v < 1 {
+			return nano.ErrBadValue
+		} else {
+			this.maxPeers = v
+			this.sample()
+		}
+	case nano.OptionGossipFanout:
+		if v, ok := val.(int); !ok || v < 1 {
+			return nano.ErrBadValue
+		} else {
+			this.fanout = v
+		}
+	case nano.OptionGossipInterval:
+		if v, ok := val.(time.Duration); !ok || v <= 0 {
+			return nano.ErrBadValue
+		} else {
+			this.interval = v
+		}
+	case nano.OptionGossipRetention:
+		if v, ok := val.(time.Duration); !ok || v <= 0 {
+			return nano.ErrBadValue
+		} else {
+			this.retention = v
+		}
+	default:
+		return nano.ErrBadOption
+	}
 	return nil
 }
 
 func (this *gossip) GetOption(name string) (interface{}, error) {
-	return nil, nil
+	this.Lock()
+	defer this.Unlock()
+	switch name {
+	case nano.OptionTtl:
+		return this.ttl, nil
+	case nano.OptionGossipPeers:
+		return this.maxPeers, nil
+	case nano.OptionGossipFanout:
+		return this.fanout, nil
+	case nano.OptionGossipInterval:
+		return this.interval, nil
+	case nano.OptionGossipRetention:
+		return this.retention, nil
+	default:
+		return nil, nano.ErrBadOption
+	}
 }
 
 func (*gossip) Number() uint16 {
-	return 0
+	return nano.ProtoGossip
 }
 
 func (*gossip) PeerNumber() uint16 {
-	return 0
+	return nano.ProtoGossip
 }
 
+// NewSocket allocates a new Socket using the GOSSIP protocol.
 func NewSocket() nano.Socket {
 	return nano.MakeSocket(&gossip{})
 }
@@ -0,0 +1,26 @@
+package gossip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestRememberBeyondStored(t *testing.T) {
+	g := &gossip{}
+	g.Init(nil)
+	now := time.Now().UnixNano()
+	for id := uint64(1); id <= maxStored+1; id++ {
+		assert.Equal(t, true, g.remember(id, now, nil) != nil)
+	}
+	assert.Equal(t, maxStored, len(g.order))
+	assert.Equal(t, (*entry)(nil), g.stored[1])
+
+	// no longer stored, still a duplicate
+	assert.Equal(t, (*entry)(nil), g.remember(1, now, nil))
+
+	g.expire(now + int64(2*g.retention))
+	assert.Equal(t, 0, len(g.seen))
+	assert.Equal(t, 0, len(g.order))
+}
@@ -2,4 +2,10 @@
 // PUB listens for subscriptions and publishes messages to subscribers (SUB peers).
 // SUB will filter incoming messages from the publisher based on their
 // subscription(see nano.OptionSubscribe).
+// With nano.OptionSubscribeUpstream, SUB also sends its subscriptions to
+// PUB, which then filters at the source and saves the bandwidth of the
+// messages nobody there wants.
+// With nano.OptionSubscribePattern, SUB matches the topic of a message,
+// dot separated segments, against a pattern such as "orders.*.eu" or
+// "metrics.#".
 package pubsub
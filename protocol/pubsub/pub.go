@@ -12,6 +12,16 @@ type pubEp struct {
 
 	q chan *nano.Message
 	w nano.Waiter
+
+	// Set once the SUB peer forwards its subscriptions, see
+	// nano.OptionSubscribeUpstream.  Guarded by the pub lock.
+	filtered bool
+	subs     prefixTrie
+}
+
+// wants reports whether the peer is subscribed to a message.
+func (pe *pubEp) wants(m *nano.Message) bool {
+	return !pe.filtered || pe.subs.match(m.Body)
 }
 
 func (pe *pubEp) peerSender() {
@@ -70,7 +80,40 @@ func (p *pub) AddEndpoint(ep nano.Endpoint) {
 	subscriber.w.Init()
 	subscriber.w.Add()
 	go subscriber.peerSender()
-	go nano.NullRecv(ep) // avoid goroutine leakage
+	go p.receiver(subscriber)
+}
+
+// receiver keeps track of the subscriptions the SUB peer forwards, if it
+// does.
+func (p *pub) receiver(pe *pubEp) {
+	for {
+		m := pe.ep.RecvMsg()
+		if m == nil {
+			// dropped connection
+			return
+		}
+		if len(m.Body) == 0 {
+			m.Free()
+			continue
+		}
+
+		prefix := m.Body[1:]
+		p.Lock()
+		switch m.Body[0] {
+		case frameSubscribe:
+			pe.subs.add(prefix)
+		case frameUnsubscribe:
+			pe.subs.remove(prefix)
+		case frameFilter:
+			// the subscriptions came first
+			pe.filtered = true
+		case frameNoFilter:
+			pe.filtered = false
+			pe.subs = prefixTrie{}
+		}
+		p.Unlock()
+		m.Free()
+	}
 }
 
 func (p *pub) RemoveEndpoint(ep nano.Endpoint) {
@@ -95,6 +138,9 @@ func (p *pub) sender() {
 			// if no subscribers, drop the msg
 			p.Lock()
 			for _, peer := range p.eps {
+				if !peer.wants(msg) {
+					continue
+				}
 				m := msg.Dup()
 				select {
 				case peer.q <- m:
@@ -1,31 +1,153 @@
 package pubsub
 
 import (
-	"bytes"
 	"sync"
 	"time"
 
 	"github.com/funkygao/nano"
 )
 
+// Frames a SUB sends upstream to its PUB peers, with
+// nano.OptionSubscribeUpstream.
+const (
+	frameUnsubscribe = 0 // type, prefix
+	frameSubscribe   = 1 // type, prefix
+	frameFilter      = 2 // type; only send what is subscribed, after the subscriptions
+	frameNoFilter    = 3 // type; send everything again
+)
+
+// subEp tracks what the PUB peer of an endpoint knows of our
+// subscriptions.
+type subEp struct {
+	ep       nano.Endpoint
+	upstream bool            // the peer filters for us
+	sent     map[string]bool // subscriptions the peer has
+	wake     chan struct{}
+	gone     chan struct{}
+}
+
+func (pe *subEp) notify() {
+	select {
+	case pe.wake <- struct{}{}:
+	default:
+	}
+}
+
 type sub struct {
-	sock nano.ProtocolSocket
-	subs [][]byte
-	raw  bool
-	sync.Mutex
+	sock     nano.ProtocolSocket
+	subs     prefixTrie
+	patterns patternTrie
+	raw      bool
+	upstream bool
+	eps      map[nano.EndpointId]*subEp
+	sync.RWMutex
 }
 
 func (s *sub) Init(sock nano.ProtocolSocket) {
 	s.sock = sock
-	s.subs = [][]byte{}
+	s.eps = make(map[nano.EndpointId]*subEp)
 	s.sock.SetSendError(nano.ErrProtoOp)
 }
 
 func (s *sub) AddEndpoint(ep nano.Endpoint) {
+	pe := &subEp{
+		ep:   ep,
+		sent: make(map[string]bool),
+		wake: make(chan struct{}, 1),
+		gone: make(chan struct{}),
+	}
+	s.Lock()
+	s.eps[ep.Id()] = pe
+	s.Unlock()
+
 	go s.receiver(ep)
+	go s.sender(pe)
 }
 
-func (*sub) RemoveEndpoint(nano.Endpoint) {}
+func (s *sub) RemoveEndpoint(ep nano.Endpoint) {
+	s.Lock()
+	if pe := s.eps[ep.Id()]; pe != nil {
+		delete(s.eps, ep.Id())
+		close(pe.gone)
+	}
+	s.Unlock()
+}
+
+// sender forwards the subscription changes to the PUB peer, if enabled.
+func (s *sub) sender(pe *subEp) {
+	closeChan := s.sock.CloseChannel()
+	for {
+		for _, m := range s.changes(pe) {
+			if pe.ep.SendMsg(m) != nil {
+				return
+			}
+		}
+
+		select {
+		case <-pe.wake:
+		case <-pe.gone:
+			return
+		case <-closeChan:
+			return
+		}
+	}
+}
+
+// changes returns the frames that bring the PUB peer up to date.
+func (s *sub) changes(pe *subEp) []*nano.Message {
+	s.Lock()
+	defer s.Unlock()
+
+	var frames []*nano.Message
+	frame := func(typ byte, prefix []byte) {
+		m := nano.NewMessage(1 + len(prefix))
+		m.Body = append(append(m.Body, typ), prefix...)
+		frames = append(frames, m)
+	}
+
+	if !s.upstream {
+		if pe.upstream {
+			frame(frameNoFilter, nil)
+			pe.upstream = false
+			pe.sent = make(map[string]bool)
+		}
+		return frames
+	}
+
+	// patterns go as the prefix of what they may match
+	current := make(map[string]bool, s.subs.n+len(s.patterns.patterns))
+	s.subs.each(func(prefix []byte) {
+		current[string(prefix)] = true
+	})
+	for pattern := range s.patterns.patterns {
+		current[string(upstreamPrefix(pattern))] = true
+	}
+	for sub := range current {
+		if !pe.sent[sub] {
+			frame(frameSubscribe, []byte(sub))
+			pe.sent[sub] = true
+		}
+	}
+	for sub := range pe.sent {
+		if !current[sub] {
+			frame(frameUnsubscribe, []byte(sub))
+			delete(pe.sent, sub)
+		}
+	}
+	// the peer only filters once it has all of them, lest it drop what
+	// is published meanwhile
+	if !pe.upstream {
+		frame(frameFilter, nil)
+		pe.upstream = true
+	}
+	return frames
+}
+
+func (s *sub) notifyAll() {
+	for _, pe := range s.eps {
+		pe.notify()
+	}
+}
 
 func (s *sub) receiver(ep nano.Endpoint) {
 	recvChan := s.sock.RecvChannel()
@@ -38,16 +160,10 @@ func (s *sub) receiver(ep nano.Endpoint) {
 			return
 		}
 
-		var matched = false
-		s.Lock()
-		for _, sub := range s.subs {
-			if bytes.HasPrefix(msg.Body, sub) {
-				// Matched, send it up.  Best effort.
-				matched = true
-				break
-			}
-		}
-		s.Unlock()
+		s.RLock()
+		matched := s.subs.match(msg.Body) ||
+			s.patterns.match(topicOf(msg.Body))
+		s.RUnlock()
 
 		if !matched {
 			msg.Free()
@@ -92,8 +208,16 @@ func (s *sub) SetOption(name string, value interface{}) error {
 			return nano.ErrBadValue
 		}
 		return nil
+	case nano.OptionSubscribeUpstream:
+		if s.upstream, ok = value.(bool); !ok {
+			return nano.ErrBadValue
+		}
+		s.notifyAll()
+		return nil
 	case nano.OptionSubscribe:
 	case nano.OptionUnsubscribe:
+	case nano.OptionSubscribePattern:
+	case nano.OptionUnsubscribePattern:
 	default:
 		return nano.ErrBadOption
 	}
@@ -108,25 +232,34 @@ func (s *sub) SetOption(name string, value interface{}) error {
 	}
 	switch name {
 	case nano.OptionSubscribe:
-		for _, sub := range s.subs {
-			if bytes.Equal(sub, vb) {
-				// Already present
-				return nil
-			}
+		if s.subs.add(vb) {
+			s.notifyAll()
 		}
-		s.subs = append(s.subs, vb)
 		return nil
 
 	case nano.OptionUnsubscribe:
-		for i, sub := range s.subs {
-			if bytes.Equal(sub, vb) {
-				s.subs[i] = s.subs[len(s.subs)-1]
-				s.subs = s.subs[:len(s.subs)-1]
-				return nil
-			}
+		if !s.subs.remove(vb) {
+			// Subscription not present
+			return nano.ErrBadValue
 		}
-		// Subscription not present
-		return nano.ErrBadValue
+		s.notifyAll()
+		return nil
+
+	case nano.OptionSubscribePattern:
+		if !validPattern(string(vb)) {
+			return nano.ErrBadValue
+		}
+		if s.patterns.add(string(vb)) {
+			s.notifyAll()
+		}
+		return nil
+
+	case nano.OptionUnsubscribePattern:
+		if !s.patterns.remove(string(vb)) {
+			return nano.ErrBadValue
+		}
+		s.notifyAll()
+		return nil
 
 	default:
 		return nano.ErrBadOption
@@ -137,6 +270,10 @@ func (s *sub) GetOption(name string) (interface{}, error) {
 	switch name {
 	case nano.OptionRaw:
 		return s.raw, nil
+	case nano.OptionSubscribeUpstream:
+		s.RLock()
+		defer s.RUnlock()
+		return s.upstream, nil
 	default:
 		return nil, nano.ErrBadOption
 	}
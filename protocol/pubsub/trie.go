@@ -0,0 +1,247 @@
+package pubsub
+
+import (
+	"bytes"
+	"strings"
+)
+
+// prefixTrie is a set of byte prefixes.  Matching a message takes the
+// length of its longest matching prefix, however many prefixes there are.
+type prefixTrie struct {
+	root trieNode
+	n    int
+}
+
+type trieNode struct {
+	children map[byte]*trieNode
+	end      bool // a prefix ends here
+}
+
+// add adds a prefix, and reports whether it was not there yet.
+func (t *prefixTrie) add(prefix []byte) bool {
+	n := &t.root
+	for _, c := range prefix {
+		child := n.children[c]
+		if child == nil {
+			if n.children == nil {
+				n.children = make(map[byte]*trieNode)
+			}
+			child = &trieNode{}
+			n.children[c] = child
+		}
+		n = child
+	}
+	if n.end {
+		return false
+	}
+	n.end = true
+	t.n++
+	return true
+}
+
+// remove removes a prefix, and reports whether it was there.
+func (t *prefixTrie) remove(prefix []byte) bool {
+	path := make([]*trieNode, 0, len(prefix)+1)
+	n := &t.root
+	for _, c := range prefix {
+		path = append(path, n)
+		if n = n.children[c]; n == nil {
+			return false
+		}
+	}
+	if !n.end {
+		return false
+	}
+	n.end = false
+	t.n--
+
+	// prune the nodes that lead nowhere anymore
+	for i := len(prefix) - 1; i >= 0 && !n.end && len(n.children) == 0; i-- {
+		delete(path[i].children, prefix[i])
+		n = path[i]
+	}
+	return true
+}
+
+// match reports whether b starts with any of the prefixes.
+func (t *prefixTrie) match(b []byte) bool {
+	n := &t.root
+	if n.end {
+		return true
+	}
+	for _, c := range b {
+		if n = n.children[c]; n == nil {
+			return false
+		}
+		if n.end {
+			return true
+		}
+	}
+	return false
+}
+
+// each calls fn with every prefix.
+func (t *prefixTrie) each(fn func(prefix []byte)) {
+	var walk func(n *trieNode, prefix []byte)
+	walk = func(n *trieNode, prefix []byte) {
+		if n.end {
+			fn(prefix)
+		}
+		for c, child := range n.children {
+			walk(child, append(prefix[:len(prefix):len(prefix)], c))
+		}
+	}
+	walk(&t.root, nil)
+}
+
+// Pattern wildcards, as whole segments.
+const (
+	anySegment  = "*" // exactly one segment
+	anySegments = "#" // zero or more segments
+)
+
+// topicOf returns the topic of a message body that patterns match: up to
+// the first space or zero byte, or all of it.
+func topicOf(body []byte) []byte {
+	if i := bytes.IndexAny(body, " \x00"); i >= 0 {
+		return body[:i]
+	}
+	return body
+}
+
+// nextSegment splits the first segment off a topic.  more tells whether
+// there is another segment after it.
+func nextSegment(topic []byte) (seg, rest []byte, more bool) {
+	if i := bytes.IndexByte(topic, '.'); i >= 0 {
+		return topic[:i], topic[i+1:], true
+	}
+	return topic, nil, false
+}
+
+// validPattern reports whether wildcards are whole segments.
+func validPattern(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+	for _, seg := range strings.Split(pattern, ".") {
+		if seg != anySegment && seg != anySegments && strings.ContainsAny(seg, "*#") {
+			return false
+		}
+	}
+	return true
+}
+
+// upstreamPrefix returns a byte prefix of all the topics a pattern
+// matches, for a PUB that only knows prefixes: its leading literal
+// segments.
+func upstreamPrefix(pattern string) []byte {
+	segs := strings.Split(pattern, ".")
+	for i, seg := range segs {
+		if seg == anySegment || seg == anySegments {
+			return []byte(strings.Join(segs[:i], "."))
+		}
+	}
+	return []byte(pattern)
+}
+
+// patternTrie is a set of topic patterns: segments separated by dots,
+// where * stands for exactly one segment and # for zero or more, as in
+// "orders.*.eu" or "metrics.#".
+type patternTrie struct {
+	root     patternNode
+	patterns map[string]bool
+}
+
+type patternNode struct {
+	children map[string]*patternNode // by segment, wildcards included
+	end      bool                    // a pattern ends here
+}
+
+// add adds a valid pattern, and reports whether it was not there yet.
+func (t *patternTrie) add(pattern string) bool {
+	if t.patterns[pattern] {
+		return false
+	}
+	if t.patterns == nil {
+		t.patterns = make(map[string]bool)
+	}
+	t.patterns[pattern] = true
+
+	n := &t.root
+	for _, seg := range strings.Split(pattern, ".") {
+		child := n.children[seg]
+		if child == nil {
+			if n.children == nil {
+				n.children = make(map[string]*patternNode)
+			}
+			child = &patternNode{}
+			n.children[seg] = child
+		}
+		n = child
+	}
+	n.end = true
+	return true
+}
+
+// remove removes a pattern, and reports whether it was there.
+func (t *patternTrie) remove(pattern string) bool {
+	if !t.patterns[pattern] {
+		return false
+	}
+	delete(t.patterns, pattern)
+
+	segs := strings.Split(pattern, ".")
+	path := make([]*patternNode, 0, len(segs))
+	n := &t.root
+	for _, seg := range segs {
+		path = append(path, n)
+		n = n.children[seg]
+	}
+	n.end = false
+	for i := len(segs) - 1; i >= 0 && !n.end && len(n.children) == 0; i-- {
+		delete(path[i].children, segs[i])
+		n = path[i]
+	}
+	return true
+}
+
+// match reports whether any pattern matches a topic.
+func (t *patternTrie) match(topic []byte) bool {
+	if len(t.patterns) == 0 {
+		return false
+	}
+	return t.root.match(topic, true)
+}
+
+// match reports whether the patterns below n match the rest of a topic;
+// more tells whether there is a segment left.
+func (n *patternNode) match(rest []byte, more bool) bool {
+	if !more {
+		if n.end {
+			return true
+		}
+		h := n.children[anySegments]
+		return h != nil && h.match(nil, false)
+	}
+
+	seg, next, nextMore := nextSegment(rest)
+	if c := n.children[string(seg)]; c != nil && c.match(next, nextMore) {
+		return true
+	}
+	if c := n.children[anySegment]; c != nil && c.match(next, nextMore) {
+		return true
+	}
+	if h := n.children[anySegments]; h != nil {
+		// # takes no segment, then one, two...
+		for {
+			if h.match(rest, more) {
+				return true
+			}
+			if !more {
+				break
+			}
+			_, rest, more = nextSegment(rest)
+		}
+	}
+	return false
+}
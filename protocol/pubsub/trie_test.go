@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestPrefixTrie(t *testing.T) {
+	var trie prefixTrie
+	assert.Equal(t, false, trie.match([]byte("anything")))
+	assert.Equal(t, true, trie.add([]byte("ab")))
+	assert.Equal(t, false, trie.add([]byte("ab")))
+	assert.Equal(t, true, trie.add([]byte("abcd")))
+	assert.Equal(t, true, trie.match([]byte("abc")))
+	assert.Equal(t, false, trie.match([]byte("a")))
+	assert.Equal(t, false, trie.match([]byte("b")))
+
+	assert.Equal(t, true, trie.remove([]byte("ab")))
+	assert.Equal(t, false, trie.remove([]byte("ab")))
+	assert.Equal(t, false, trie.match([]byte("abc")))
+	assert.Equal(t, true, trie.match([]byte("abcde")))
+	assert.Equal(t, true, trie.remove([]byte("abcd")))
+	assert.Equal(t, 0, len(trie.root.children))
+
+	// the empty prefix matches everything
+	assert.Equal(t, true, trie.add(nil))
+	assert.Equal(t, true, trie.match(nil))
+	assert.Equal(t, true, trie.add([]byte("x")))
+	var all []string
+	trie.each(func(prefix []byte) {
+		all = append(all, string(prefix))
+	})
+	sort.Strings(all)
+	assert.Equal(t, []string{"", "x"}, all)
+	assert.Equal(t, 2, trie.n)
+}
+
+func TestPatternTrie(t *testing.T) {
+	var trie patternTrie
+	for _, p := range []string{"orders.*.eu", "metrics.#", "a.#.z", "exact"} {
+		assert.Equal(t, true, validPattern(p))
+		assert.Equal(t, true, trie.add(p))
+	}
+	assert.Equal(t, false, trie.add("exact"))
+
+	for topic, want := range map[string]bool{
+		"orders.1.eu":      true,
+		"orders.1.us":      false,
+		"orders.eu":        false,
+		"orders.1.2.eu":    false,
+		"metrics":          true,
+		"metrics.cpu":      true,
+		"metrics.cpu.load": true,
+		"metricsx":         false,
+		"a.z":              true,
+		"a.b.c.z":          true,
+		"a.b.c":            false,
+		"exact":            true,
+		"exact.not":        false,
+		"":                 false,
+	} {
+		if trie.match([]byte(topic)) != want {
+			t.Errorf("%q: want %v", topic, want)
+		}
+	}
+
+	assert.Equal(t, "metrics.cpu", string(topicOf([]byte("metrics.cpu 0.95"))))
+	assert.Equal(t, "orders", string(upstreamPrefix("orders.*.eu")))
+	assert.Equal(t, "", string(upstreamPrefix("#")))
+
+	assert.Equal(t, true, trie.remove("metrics.#"))
+	assert.Equal(t, false, trie.remove("metrics.#"))
+	assert.Equal(t, false, trie.match([]byte("metrics.cpu")))
+	assert.Equal(t, true, trie.match([]byte("a.z")))
+
+	for _, p := range []string{"", "a.b*", "#x", "a.*b.c"} {
+		assert.Equal(t, false, validPattern(p))
+	}
+}
+
+func BenchmarkPrefixTrie(b *testing.B) {
+	var trie prefixTrie
+	for i := 0; i < 10000; i++ {
+		trie.add([]byte(fmt.Sprintf("topic.%d.", i)))
+	}
+	body := []byte("topic.9999.some payload")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.match(body)
+	}
+}
@@ -1,6 +1,8 @@
 // Package reqrep implements the REQ/REP protocol.  The REQ-REP socket pair
 // is in lockstep. Doing any other sequence (e.g., sending two messages in
 // a row) will result in error.
-// REQ is the request side of the request/response pattern.
+// REQ is the request side of the request/response pattern.  Each Context
+// of a REQ socket (see nano.Socket OpenContext) is in lockstep on its own,
+// so that many requests can be outstanding at once.
 // REP is the response side of the request/response pattern.
 package reqrep
@@ -33,8 +33,13 @@ type rep struct {
 	sock nano.ProtocolSocket
 	eps  map[nano.EndpointId]*repEp
 
+	replies chan *nano.Message // replies to Requests
+	recvLk  sync.Mutex         // serializes RecvRequest
+
 	backtracebuf []byte
 	backtrace    []byte
+	received     *nano.Message // last received, for RecvRequest
+	receivedBt   []byte        // and its backtrace
 	backtraceLk  sync.Mutex
 
 	raw bool
@@ -48,6 +53,7 @@ type rep struct {
 func (r *rep) Init(sock nano.ProtocolSocket) {
 	r.sock = sock
 	r.eps = make(map[nano.EndpointId]*repEp)
+	r.replies = make(chan *nano.Message)
 	r.backtracebuf = make([]byte, 64)
 	r.ttl = 8 // default specified in the RFC
 	r.sock.SetSendError(nano.ErrProtoState)
@@ -64,9 +70,15 @@ func (r *rep) AddEndpoint(ep nano.Endpoint) {
 		go r.sender()
 	})
 
+	// replies to Requests come back as fast as the application makes
+	// them, several at a time for the same peer
+	qlen := 2
+	if v, err := r.sock.GetOption(nano.OptionWriteQLen); err == nil && v.(int) > qlen {
+		qlen = v.(int)
+	}
 	pe := &repEp{
 		ep: ep,
-		q:  make(chan *nano.Message, 2), // TODO
+		q:  make(chan *nano.Message, qlen),
 	}
 	pe.w.Init()
 	r.Lock()
@@ -150,6 +162,7 @@ func (r *rep) sender() {
 	for {
 		select {
 		case m = <-sendChan:
+		case m = <-r.replies:
 		case <-closeChan:
 			return
 		}
@@ -221,11 +234,13 @@ func (r *rep) RecvHook(m *nano.Message) bool {
 
 	r.backtraceLk.Lock()
 	r.backtrace = append(r.backtracebuf[0:0], m.Header...)
+	r.received = m
+	r.receivedBt = append(r.receivedBt[:0], m.Header...)
 	r.backtraceLk.Unlock()
+	m.Header = nil // drop the header
 
 	nano.Debugf("%+v", *r)
 
-	m.Header = nil // drop the header
 	return true
 }
 
@@ -0,0 +1,87 @@
+package reqrep
+
+import (
+	"github.com/funkygao/nano"
+)
+
+// Request is a request received on a REP socket, with the backtrace to
+// reply to it.  Unlike Recv and Send in turn, requests can be replied to
+// from any goroutine and in any order, so that workers process many of
+// them in parallel over the same socket.
+type Request struct {
+	// Msg is the request message, without the backtrace.  It belongs to
+	// the application, as with RecvMsg.
+	Msg *nano.Message
+
+	r         *rep
+	backtrace []byte
+}
+
+// RecvRequest receives a request on a REP socket.  It leaves the socket
+// state alone, apart from the request Send would reply to, which is the
+// last one received however it was.  Concurrent calls are served one at
+// a time.
+func RecvRequest(sock nano.Socket) (*Request, error) {
+	r, ok := sock.GetProtocol().(*rep)
+	if !ok {
+		return nil, nano.ErrProtoOp
+	}
+	m, backtrace, err := r.recvRequest(sock)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{Msg: m, r: r, backtrace: backtrace}, nil
+}
+
+// recvRequest receives a message along with the backtrace the RecvHook
+// takes off it.
+func (r *rep) recvRequest(sock nano.Socket) (*nano.Message, []byte, error) {
+	r.recvLk.Lock()
+	defer r.recvLk.Unlock()
+	m, err := sock.RecvMsg()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.backtraceLk.Lock()
+	defer r.backtraceLk.Unlock()
+	if r.raw {
+		backtrace := append([]byte(nil), m.Header...)
+		m.Header = m.Header[:0]
+		return m, backtrace, nil
+	}
+	if r.received != m {
+		// taken over by a RecvMsg at the same time
+		m.Free()
+		return nil, nil, nano.ErrProtoState
+	}
+	r.received = nil
+	return m, append([]byte(nil), r.receivedBt...), nil
+}
+
+// Reply sends the reply to the request.
+func (req *Request) Reply(body []byte) error {
+	m := nano.NewMessage(len(body))
+	m.Body = append(m.Body, body...)
+	return req.ReplyMsg(m)
+}
+
+// ReplyMsg sends the reply to the request.  Like with SendMsg, the socket
+// assumes ownership of the message.  A request is replied to only once,
+// ErrProtoState is returned after that.
+func (req *Request) ReplyMsg(m *nano.Message) error {
+	if req.backtrace == nil {
+		m.Free()
+		return nano.ErrProtoState
+	}
+	m.Header = append(m.Header[:0], req.backtrace...)
+	req.backtrace = nil
+
+	select {
+	case req.r.replies <- m:
+		return nil
+	case <-req.r.sock.CloseChannel():
+		m.Free()
+		return nano.ErrClosed
+	}
+}
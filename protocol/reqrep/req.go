@@ -20,6 +20,7 @@ type req struct {
 	waiter        nano.Waiter
 
 	outstandingReq *nano.Message
+	ctxs           map[uint32]*reqContext // by outstanding request id
 
 	once sync.Once
 	sync.Mutex
@@ -28,6 +29,7 @@ type req struct {
 func (r *req) Init(socket nano.ProtocolSocket) {
 	r.sock = socket
 	r.resendMsgChan = make(chan *nano.Message) // TODO buffer
+	r.ctxs = make(map[uint32]*reqContext)
 
 	r.nextid = uint32(time.Now().UnixNano()) // quasi-random
 	r.retry = time.Minute * 1                // retry after a minute
@@ -114,6 +116,9 @@ func (r *req) receiver(ep nano.Endpoint) {
 		}
 		m.Header = append(m.Header, m.Body[:4]...)
 		m.Body = m.Body[4:]
+		if r.route(m) {
+			continue
+		}
 
 		select {
 		case recvChan <- m:
@@ -154,6 +159,14 @@ func (r *req) sender(ep nano.Endpoint) {
 func (r *req) Shutdown(expire time.Time) {
 	nano.Debugf("expire:%v", expire)
 	r.waiter.WaitAbsTimeout(expire)
+
+	// contexts stop resending
+	r.Lock()
+	for id, c := range r.ctxs {
+		delete(r.ctxs, id)
+		c.done()
+	}
+	r.Unlock()
 }
 
 func (*req) Number() uint16 {
@@ -207,9 +220,11 @@ func (r *req) RecvHook(m *nano.Message) bool {
 
 	r.Lock()
 	if len(m.Header) < 4 {
+		r.Unlock()
 		return false
 	}
 	if r.outstandingReq == nil {
+		r.Unlock()
 		return false
 	}
 	if binary.BigEndian.Uint32(m.Header) != r.reqid {
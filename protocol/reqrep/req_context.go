@@ -0,0 +1,271 @@
+package reqrep
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+// reqContext is a REQ context: it has its own outstanding request, retry
+// timer and reply slot, and shares the connections of the socket.  Its
+// state is guarded by the req lock.
+type reqContext struct {
+	r       *req
+	reqid   uint32
+	pending *nano.Message      // outstanding request, to resend
+	reply   chan *nano.Message // holds at most the reply to reqid
+	timer   *time.Timer
+	closeq  chan struct{}
+	closed  bool
+
+	retry        time.Duration
+	recvDeadline time.Duration
+	sendDeadline time.Duration
+}
+
+func mkTimer(deadline time.Duration) <-chan time.Time {
+	if deadline == 0 {
+		return nil
+	}
+	return time.After(deadline)
+}
+
+// OpenContext implements the ProtocolContexter OpenContext method.
+func (r *req) OpenContext() (nano.Context, error) {
+	c := &reqContext{
+		r:      r,
+		reply:  make(chan *nano.Message, 1),
+		closeq: make(chan struct{}),
+	}
+	if v, err := r.sock.GetOption(nano.OptionRecvDeadline); err == nil {
+		c.recvDeadline, _ = v.(time.Duration)
+	}
+	if v, err := r.sock.GetOption(nano.OptionSendDeadline); err == nil {
+		c.sendDeadline, _ = v.(time.Duration)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if r.raw {
+		// raw mode leaves request ids to the application
+		return nil, nano.ErrProtoOp
+	}
+	c.retry = r.retry
+	return c, nil
+}
+
+// route puts a reply in the slot of the context that sent the request.
+// It returns false, leaving the reply to the receiver, in raw mode or if
+// it answers the request of the socket itself; late replies are freed.
+func (r *req) route(m *nano.Message) bool {
+	r.Lock()
+	defer r.Unlock()
+	if r.raw {
+		return false
+	}
+
+	id := binary.BigEndian.Uint32(m.Header)
+	if c := r.ctxs[id]; c != nil {
+		delete(r.ctxs, id)
+		c.done()
+		c.reply <- m // never blocks, the slot is emptied on each request
+		return true
+	}
+	if r.outstandingReq != nil && id == r.reqid {
+		return false
+	}
+	// a late reply, e.g. to a request sent again
+	m.Free()
+	return true
+}
+
+// done stops resending the outstanding request.
+func (c *reqContext) done() {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.pending.Free()
+	c.pending = nil
+}
+
+// cancel abandons the outstanding request, and the reply not received
+// yet.
+func (c *reqContext) cancel() {
+	if c.pending != nil {
+		delete(c.r.ctxs, c.reqid)
+		c.done()
+	}
+	select {
+	case m := <-c.reply:
+		m.Free()
+	default:
+	}
+}
+
+// schedule sends the outstanding request again after the retry time.
+func (c *reqContext) schedule() {
+	if c.retry <= 0 {
+		return
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.retry, c.resend)
+	} else {
+		c.timer.Reset(c.retry)
+	}
+}
+
+func (c *reqContext) resend() {
+	r := c.r
+	r.Lock()
+	if c.pending == nil {
+		r.Unlock()
+		return
+	}
+	m := c.pending.Dup()
+	c.schedule()
+	r.Unlock()
+
+	select {
+	case r.resendMsgChan <- m:
+	case <-r.sock.CloseChannel():
+		m.Free()
+	case <-c.closeq:
+		m.Free()
+	}
+}
+
+// SendMsg sends a request, abandoning the previous one if it has not been
+// replied to.  It blocks until a connection takes the request over.
+func (c *reqContext) SendMsg(m *nano.Message) error {
+	r := c.r
+	r.Lock()
+	if c.closed {
+		r.Unlock()
+		m.Free()
+		return nano.ErrClosed
+	}
+	c.cancel()
+	id := r.nextID()
+	c.reqid = id
+	m.Header = append(m.Header, byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	c.pending = m.Dup()
+	r.ctxs[id] = c
+	c.schedule()
+	deadline := c.sendDeadline
+	r.Unlock()
+
+	var err error
+	select {
+	case r.resendMsgChan <- m:
+		return nil
+	case <-mkTimer(deadline):
+		err = nano.ErrSendTimeout
+	case <-r.sock.CloseChannel():
+		err = nano.ErrClosed
+	case <-c.closeq:
+		err = nano.ErrClosed
+	}
+
+	m.Free()
+	r.Lock()
+	if c.reqid == id {
+		c.cancel()
+	}
+	r.Unlock()
+	return err
+}
+
+func (c *reqContext) Send(b []byte) error {
+	m := nano.NewMessage(len(b))
+	m.Body = append(m.Body, b...)
+	return c.SendMsg(m)
+}
+
+// RecvMsg receives the reply to the outstanding request.  The request
+// stays outstanding when the receive deadline expires.
+func (c *reqContext) RecvMsg() (*nano.Message, error) {
+	r := c.r
+	r.Lock()
+	if c.closed {
+		r.Unlock()
+		return nil, nano.ErrClosed
+	}
+	if c.pending == nil && len(c.reply) == 0 {
+		r.Unlock()
+		return nil, nano.ErrProtoState
+	}
+	deadline := c.recvDeadline
+	r.Unlock()
+
+	select {
+	case m := <-c.reply:
+		return m, nil
+	case <-mkTimer(deadline):
+		return nil, nano.ErrRecvTimeout
+	case <-r.sock.CloseChannel():
+		return nil, nano.ErrClosed
+	case <-c.closeq:
+		return nil, nano.ErrClosed
+	}
+}
+
+func (c *reqContext) Recv() ([]byte, error) {
+	m, err := c.RecvMsg()
+	if err != nil {
+		return nil, err
+	}
+	return m.Body, nil
+}
+
+func (c *reqContext) Close() error {
+	r := c.r
+	r.Lock()
+	defer r.Unlock()
+	if c.closed {
+		return nano.ErrClosed
+	}
+	c.closed = true
+	c.cancel()
+	close(c.closeq)
+	return nil
+}
+
+func (c *reqContext) SetOption(name string, value interface{}) error {
+	v, ok := value.(time.Duration)
+	switch name {
+	case nano.OptionRetryTime, nano.OptionRecvDeadline, nano.OptionSendDeadline:
+		if !ok {
+			return nano.ErrBadValue
+		}
+	default:
+		return nano.ErrBadOption
+	}
+
+	c.r.Lock()
+	defer c.r.Unlock()
+	switch name {
+	case nano.OptionRetryTime:
+		c.retry = v
+	case nano.OptionRecvDeadline:
+		c.recvDeadline = v
+	case nano.OptionSendDeadline:
+		c.sendDeadline = v
+	}
+	return nil
+}
+
+func (c *reqContext) GetOption(name string) (interface{}, error) {
+	c.r.Lock()
+	defer c.r.Unlock()
+	switch name {
+	case nano.OptionRetryTime:
+		return c.retry, nil
+	case nano.OptionRecvDeadline:
+		return c.recvDeadline, nil
+	case nano.OptionSendDeadline:
+		return c.sendDeadline, nil
+	default:
+		return nil, nano.ErrBadOption
+	}
+}
@@ -2,10 +2,12 @@
 // This is like the BUS protocol, except that each member of the network
 // automatically forwards any message it receives to any other peers.
 // In a star network, this means that all members should receive all messages,
-// assuming that there is a central server.  Its important to ensure that
-// the topology is free from cycles, as there is no protection against
-// that, and cycles can lead to infinite message storms.  (TODO: Add a TTL,
-// and basic message ID / anti-replay protection.)
+// assuming that there is a central server.  Cycles in the topology are
+// fine: messages carry their origin and a message ID, and the hops they
+// have left (see nano.OptionTtl), and a member drops those it has seen
+// already (see nano.OptionAntiReplayWindow) or that are out of hops, so
+// that redundant links do not lead to message storms.  The drops are
+// counted in nano.OptionStormStats.
 package star
 
 import (
@@ -22,11 +24,13 @@ type starEp struct {
 }
 
 type star struct {
-	sock nano.ProtocolSocket
-	eps  map[nano.EndpointId]*starEp
-	raw  bool
-	w    nano.Waiter
-	init sync.Once
+	sock  nano.ProtocolSocket
+	eps   map[nano.EndpointId]*starEp
+	raw   bool
+	ttl   int
+	guard *nano.StormGuard
+	w     nano.Waiter
+	init  sync.Once
 
 	sync.Mutex
 }
@@ -34,6 +38,8 @@ type star struct {
 func (x *star) Init(sock nano.ProtocolSocket) {
 	x.sock = sock
 	x.eps = make(map[nano.EndpointId]*starEp)
+	x.ttl = 8
+	x.guard = nano.NewStormGuard()
 	x.w.Init()
 }
 
@@ -68,42 +74,23 @@ func (pe *starEp) sender() {
 	}
 }
 
+// broadcast queues a message to every peer but the one it came from.
 func (x *star) broadcast(m *nano.Message, sender *starEp) {
-
 	x.Lock()
-	if sender == nil || !x.raw {
-		for _, pe := range x.eps {
-			if sender == pe {
-				continue
-			}
-			m = m.Dup()
-			select {
-			case pe.q <- m:
-			default:
-				// No room on outbound queue, drop it.
-				if m != nil {
-					m.Free()
-				}
-			}
+	for _, pe := range x.eps {
+		if sender == pe {
+			continue
 		}
-	}
-	x.Unlock()
-
-	// Grab a local copy and send it up if we aren't originator
-	if sender != nil {
+		m := m.Dup()
 		select {
-		case x.sock.RecvChannel() <- m:
-		case <-x.sock.CloseChannel():
-			m.Free()
-			return
+		case pe.q <- m:
 		default:
-			// No room, so we just drop it.
+			// No room on outbound queue, drop it.
 			m.Free()
 		}
-	} else {
-		// Not sending it up, so we need to release it.
-		m.Free()
 	}
+	x.Unlock()
+	m.Free()
 }
 
 func (x *star) sender() {
@@ -116,21 +103,70 @@ func (x *star) sender() {
 		case <-cq:
 			return
 		case m := <-sq:
+			x.Lock()
+			raw, ttl := x.raw, x.ttl
+			x.Unlock()
+
+			if raw && len(m.Header) >= nano.StormHeaderLen {
+				// passed on by a device
+				if !x.guard.Forward(m.Header) {
+					m.Free()
+					continue
+				}
+			} else {
+				m.Header = append(m.Header[:0], x.guard.Stamp(ttl)...)
+			}
 			x.broadcast(m, nil)
 		}
 	}
 }
 
 func (pe *starEp) receiver() {
+	x := pe.x
+	rq := x.sock.RecvChannel()
+	cq := x.sock.CloseChannel()
 	for {
-		msg := pe.ep.RecvMsg()
-		if msg == nil {
+		m := pe.ep.RecvMsg()
+		if m == nil {
 			return
 		}
+		if len(m.Body) < nano.StormHeaderLen {
+			m.Free() // ErrGarbled
+			continue
+		}
+		m.Header = append(m.Header, m.Body[:nano.StormHeaderLen]...)
+		m.Body = m.Body[nano.StormHeaderLen:]
+		if !x.guard.Admit(m.Header) {
+			m.Free()
+			continue
+		}
 
 		// if we're in raw mode, this does only a sendup, otherwise
 		// it does both a retransmit + sendup
-		pe.x.broadcast(msg, pe)
+		x.Lock()
+		raw := x.raw
+		x.Unlock()
+		if !raw {
+			fw := nano.NewMessage(len(m.Body))
+			fw.Header = append(fw.Header, m.Header...)
+			fw.Body = append(fw.Body, m.Body...)
+			if x.guard.Forward(fw.Header) {
+				x.broadcast(fw, pe)
+			} else {
+				fw.Free()
+			}
+			m.Header = m.Header[:0]
+		}
+
+		select {
+		case rq <- m:
+		case <-cq:
+			m.Free()
+			return
+		default:
+			// No room, so we just drop it.
+			m.Free()
+		}
 	}
 }
 
@@ -169,22 +205,46 @@ func (*star) PeerNumber() uint16 {
 }
 
 func (x *star) SetOption(name string, v interface{}) error {
-	var ok bool
+	x.Lock()
+	defer x.Unlock()
 	switch name {
 	case nano.OptionRaw:
-		if x.raw = v.(bool); !ok {
+		raw, ok := v.(bool)
+		if !ok {
 			return nano.ErrBadValue
 		}
+		x.raw = raw
 		return nil
+	case nano.OptionTtl:
+		if ttl, ok := v.(int); !ok || ttl < 1 || ttl > 255 {
+			return nano.ErrBadValue
+		} else {
+			x.ttl = ttl
+		}
+		return nil
+	case nano.OptionAntiReplayWindow:
+		if n, ok := v.(int); !ok {
+			return nano.ErrBadValue
+		} else {
+			return x.guard.SetWindow(n)
+		}
 	default:
 		return nano.ErrBadOption
 	}
 }
 
 func (x *star) GetOption(name string) (interface{}, error) {
+	x.Lock()
+	defer x.Unlock()
 	switch name {
 	case nano.OptionRaw:
 		return x.raw, nil
+	case nano.OptionTtl:
+		return x.ttl, nil
+	case nano.OptionAntiReplayWindow:
+		return x.guard.Window(), nil
+	case nano.OptionStormStats:
+		return x.guard.Stats(), nil
 	default:
 		return nil, nano.ErrBadOption
 	}
@@ -1,4 +1,6 @@
 // Package survey implements the SURVEYOR/RESPONDENT protocol.
 // SURVEYOR sends messages out to RESPONDENT partners, and receives their responses.
 // RESPONDENT receives SURVEYOR requests, and responds with an answer.
+// Survey runs surveys alongside Send and Recv, several at a time, each
+// with a channel of its own responses.
 package survey
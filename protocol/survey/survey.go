@@ -0,0 +1,230 @@
+package survey
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+// Response is a response to a Survey.
+type Response struct {
+	// Msg is the response message.  It belongs to the application, as
+	// with RecvMsg.
+	Msg *nano.Message
+
+	// Port is the connection the response came in on.
+	Port nano.Port
+}
+
+// pending is a survey started with Survey, until it ends.
+type pending struct {
+	id        uint32
+	peers     int // the survey was queued to
+	responses chan Response
+	done      chan struct{}
+	finished  bool
+	once      sync.Once
+	sync.RWMutex
+}
+
+// deliver hands a response over, unless the survey ends first.
+func (s *pending) deliver(r Response, cq <-chan struct{}) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.finished {
+		r.Msg.Free()
+		return
+	}
+	select {
+	case s.responses <- r:
+	case <-s.done:
+		r.Msg.Free()
+	case <-cq:
+		r.Msg.Free()
+	}
+}
+
+func (s *pending) finish() {
+	s.once.Do(func() {
+		close(s.done) // wakes deliver up
+		s.Lock()
+		s.finished = true
+		close(s.responses)
+		s.Unlock()
+	})
+}
+
+// route streams a response to the Survey call it answers.  It returns
+// false for responses to the survey sent with Send, and for all of them
+// in raw mode, which the receiver delivers as usual.  Responses to ended
+// surveys are freed.
+func (x *surveyor) route(peer *surveyorP, m *nano.Message) bool {
+	id := binary.BigEndian.Uint32(m.Header)
+	x.Lock()
+	s := x.surveys[id]
+	raw, current := x.raw, x.surveyID
+	x.Unlock()
+	if raw {
+		return false
+	}
+	if s == nil {
+		if current != 0 && id == current {
+			return false
+		}
+		m.Free()
+		return true
+	}
+
+	m.Header = m.Header[4:]
+	port, _ := peer.ep.(nano.Port)
+	s.deliver(Response{Msg: m, Port: port}, x.sock.CloseChannel())
+	return true
+}
+
+// survey sends a survey of its own ID, and ends it when ctx is done or
+// after the survey time.
+func (x *surveyor) survey(ctx context.Context, m *nano.Message) (*pending, error) {
+	x.Lock()
+	if x.raw {
+		x.Unlock()
+		m.Free()
+		return nil, nano.ErrProtoOp
+	}
+	duration := x.duration
+	if duration <= 0 && ctx.Done() == nil {
+		// it would never end
+		x.Unlock()
+		m.Free()
+		return nil, nano.ErrBadValue
+	}
+	id := x.nextID | 0x80000000
+	x.nextID++
+	m.Header = append(m.Header, byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	// the peers too far behind to be sent the survey do not count
+	peers := x.broadcast(m)
+	s := &pending{
+		id:        id,
+		peers:     peers,
+		responses: make(chan Response, peers),
+		done:      make(chan struct{}),
+	}
+	x.surveys[id] = s
+	x.Unlock()
+	m.Free()
+
+	go func() {
+		var timeout <-chan time.Time
+		if duration > 0 {
+			t := time.NewTimer(duration)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-timeout:
+		case <-x.sock.CloseChannel():
+		}
+
+		x.Lock()
+		delete(x.surveys, id)
+		x.Unlock()
+		s.finish()
+	}()
+	return s, nil
+}
+
+func start(ctx context.Context, sock nano.Socket, body []byte) (*pending, error) {
+	x, ok := sock.GetProtocol().(*surveyor)
+	if !ok {
+		return nil, nano.ErrProtoOp
+	}
+	select {
+	case <-x.sock.CloseChannel():
+		return nil, nano.ErrClosed
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m := nano.NewMessage(len(body))
+	m.Body = append(m.Body, body...)
+	return x.survey(ctx, m)
+}
+
+// Survey sends a survey, and returns the channel of its responses, which
+// is closed when the survey ends: when ctx is done, or after the survey
+// time (see nano.OptionSurveyTime) unless it is zero.  A survey without
+// a survey time needs a ctx that is eventually done, and one that never
+// is, e.g. context.Background(), is refused with ErrBadValue.  Surveys
+// overlap, each with its own deadline and responses, and leave the
+// socket alone: Send and Recv still run a survey of their own.
+func Survey(ctx context.Context, sock nano.Socket, body []byte) (<-chan Response, error) {
+	s, err := start(ctx, sock, body)
+	if err != nil {
+		return nil, err
+	}
+	return s.responses, nil
+}
+
+// collect runs a survey until want(peers) responses are received, peers
+// being the number of respondents it was sent to, or until it ends.
+func collect(ctx context.Context, sock nano.Socket, body []byte,
+	want func(peers int) int) ([]Response, int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s, err := start(ctx, sock, body)
+	if err != nil {
+		cancel()
+		return nil, 0, err
+	}
+
+	n := want(s.peers)
+	var rs []Response
+	for len(rs) < n {
+		r, ok := <-s.responses
+		if !ok {
+			break
+		}
+		rs = append(rs, r)
+	}
+
+	// no more
+	cancel()
+	for r := range s.responses {
+		r.Msg.Free()
+	}
+	return rs, n, nil
+}
+
+// Collect runs a survey until n responses are received, or until it ends
+// as with Survey.
+func Collect(ctx context.Context, sock nano.Socket, body []byte, n int) ([]Response, error) {
+	if n <= 0 {
+		return nil, nano.ErrBadValue
+	}
+	rs, _, err := collect(ctx, sock, body, func(int) int {
+		return n
+	})
+	return rs, err
+}
+
+// Quorum runs a survey until a fraction of the respondents it is sent to
+// have responded, e.g. 0.9 for the first 90% of them.  If the survey ends
+// before, or there is no respondent, the responses received are returned
+// with ErrNoQuorum.
+func Quorum(ctx context.Context, sock nano.Socket, body []byte, fraction float64) ([]Response, error) {
+	if fraction <= 0 || fraction > 1 {
+		return nil, nano.ErrBadValue
+	}
+	rs, n, err := collect(ctx, sock, body, func(peers int) int {
+		return int(math.Ceil(fraction * float64(peers)))
+	})
+	if err == nil && (n == 0 || len(rs) < n) {
+		err = nano.ErrNoQuorum
+	}
+	return rs, err
+}
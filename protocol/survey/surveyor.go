@@ -13,6 +13,7 @@ const defaultSurveyTime = time.Second
 type surveyor struct {
 	sock     nano.ProtocolSocket
 	peers    map[nano.EndpointId]*surveyorP
+	surveys  map[uint32]*pending // started with Survey, by ID
 	raw      bool
 	nextID   uint32
 	surveyID uint32
@@ -34,13 +35,22 @@ type surveyorP struct {
 func (x *surveyor) Init(sock nano.ProtocolSocket) {
 	x.sock = sock
 	x.peers = make(map[nano.EndpointId]*surveyorP)
+	x.surveys = make(map[uint32]*pending)
 	x.sock.SetRecvError(nano.ErrProtoState)
-	x.timer = time.AfterFunc(x.duration,
-		func() { x.sock.SetRecvError(nano.ErrProtoState) })
+	x.timer = time.AfterFunc(x.duration, x.expire)
 	x.timer.Stop()
 	x.w.Init()
 }
 
+// expire ends the survey: late responses no longer match its ID, also
+// for a Recv that is already waiting.
+func (x *surveyor) expire() {
+	x.Lock()
+	x.surveyID = 0
+	x.Unlock()
+	x.sock.SetRecvError(nano.ErrProtoState)
+}
+
 func (x *surveyor) Shutdown(expire time.Time) {
 	x.w.WaitAbsTimeout(expire)
 
@@ -69,18 +79,28 @@ func (x *surveyor) sender() {
 		}
 
 		x.Lock()
-		for _, pe := range x.peers {
-			m := m.Dup()
-			select {
-			case pe.q <- m:
-			default:
-				m.Free()
-			}
-		}
+		x.broadcast(m)
 		x.Unlock()
 	}
 }
 
+// broadcast queues a message to every peer, or drops it for the peers
+// that are too far behind, and returns how many peers it was queued to.
+// The caller holds the lock.
+func (x *surveyor) broadcast(m *nano.Message) int {
+	queued := 0
+	for _, pe := range x.peers {
+		m := m.Dup()
+		select {
+		case pe.q <- m:
+			queued++
+		default:
+			m.Free()
+		}
+	}
+	return queued
+}
+
 // When sending, we should have the survey ID in the header.
 func (peer *surveyorP) sender() {
 	for {
@@ -114,6 +134,9 @@ func (peer *surveyorP) receiver() {
 		// to the application.  It should include that in the response.
 		m.Header = append(m.Header, m.Body[:4]...)
 		m.Body = m.Body[4:]
+		if peer.x.route(peer, m) {
+			continue
+		}
 
 		select {
 		case rq <- m:
@@ -124,7 +147,12 @@ func (peer *surveyorP) receiver() {
 }
 
 func (x *surveyor) AddEndpoint(ep nano.Endpoint) {
-	peer := &surveyorP{ep: ep, x: x, q: make(chan *nano.Message, 1)}
+	// overlapping surveys are queued behind each other
+	qlen := 1
+	if v, err := x.sock.GetOption(nano.OptionWriteQLen); err == nil && v.(int) > qlen {
+		qlen = v.(int)
+	}
+	peer := &surveyorP{ep: ep, x: x, q: make(chan *nano.Message, qlen)}
 	x.init.Do(func() {
 		x.w.Add()
 		go x.sender()
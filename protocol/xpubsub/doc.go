@@ -1,2 +1,21 @@
-// Package xpubsub implement a clustered durable PUB/SUB protocol.
+// Package xpubsub implements a durable PUB/SUB protocol, for subscribers
+// that must not lose messages across disconnects and restarts.
+//
+// XPUB appends every message to an on-disk log of its topic, see
+// nano.OptionLogDir, before Send returns.  Each topic log numbers its
+// messages from 1 on, and is split in segment files, of which the oldest
+// are deleted by size and age.  A message body is its topic, a zero byte,
+// then the payload, see Encode.
+//
+// XSUB subscribes to whole topics.  For each topic it tells XPUB the
+// sequence number to start from, and XPUB replays the log from there
+// before it streams new messages, in order.  The application acknowledges
+// what it has processed with Ack; after a reconnect, XSUB resumes after
+// the last acknowledged message, dropping those it delivered already.
+// To resume across restarts as well, the application saves Offset and
+// subscribes with a Subscription from it.  Messages are thus delivered
+// at least once, unless retention deleted them in the meantime.
+//
+// An XSUB follows sequence numbers of a single XPUB, so it should only be
+// connected to one.
 package xpubsub
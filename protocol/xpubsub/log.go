@@ -0,0 +1,420 @@
+package xpubsub
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+// A record on disk is its sequence number, time in unix nanoseconds,
+// payload length and CRC-32C, then the payload.
+const recordHeaderLen = 24
+
+// indexEvery is how many records apart the in-memory index of a segment
+// points into it.
+const indexEvery = 64
+
+const segmentExt = ".log"
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func checksum(hdr, payload []byte) uint32 {
+	return crc32.Update(crc32.Checksum(hdr[:20], crcTable), crcTable, payload)
+}
+
+// segment is a file of consecutive records of a topic.
+type segment struct {
+	f     *os.File
+	first uint64  // sequence number of the first record
+	next  uint64  // sequence number after the last record
+	size  int64   // bytes
+	born  int64   // time of the last record
+	index []int64 // offsets of records first, first+indexEvery, ...
+}
+
+// openSegment opens or creates a segment file, and truncates what is
+// left of a torn last record.
+func openSegment(path string, first uint64) (*segment, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &segment{f: f, first: first, next: first}
+
+	r := bufio.NewReader(f)
+	var hdr [recordHeaderLen]byte
+	var payload []byte
+	for {
+		if _, err = io.ReadFull(r, hdr[:]); err != nil {
+			break
+		}
+		seq := binary.BigEndian.Uint64(hdr[0:])
+		n := binary.BigEndian.Uint32(hdr[16:])
+		if seq != s.next || n > 1<<30 {
+			break
+		}
+		if cap(payload) < int(n) {
+			payload = make([]byte, n)
+		}
+		payload = payload[:n]
+		if _, err = io.ReadFull(r, payload); err != nil ||
+			checksum(hdr[:], payload) != binary.BigEndian.Uint32(hdr[20:]) {
+			break
+		}
+		s.added(int64(binary.BigEndian.Uint64(hdr[8:])), int64(n))
+	}
+
+	if fi, err := f.Stat(); err != nil {
+		f.Close()
+		return nil, err
+	} else if fi.Size() > s.size {
+		nano.Debugf("%s: truncating %d bytes", path, fi.Size()-s.size)
+		if err = f.Truncate(s.size); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// added accounts for a record of n payload bytes appended at the end.
+func (s *segment) added(born, n int64) {
+	if (s.next-s.first)%indexEvery == 0 {
+		s.index = append(s.index, s.size)
+	}
+	s.size += recordHeaderLen + n
+	s.next++
+	s.born = born
+}
+
+func (s *segment) append(born int64, payload []byte, sync bool) (uint64, error) {
+	buf := make([]byte, recordHeaderLen+len(payload))
+	binary.BigEndian.PutUint64(buf[0:], s.next)
+	binary.BigEndian.PutUint64(buf[8:], uint64(born))
+	binary.BigEndian.PutUint32(buf[16:], uint32(len(payload)))
+	copy(buf[recordHeaderLen:], payload)
+	binary.BigEndian.PutUint32(buf[20:], checksum(buf, payload))
+
+	_, err := s.f.WriteAt(buf, s.size)
+	if err == nil && sync {
+		err = s.f.Sync()
+	}
+	if err != nil {
+		s.f.Truncate(s.size)
+		return 0, err
+	}
+	seq := s.next
+	s.added(born, int64(len(payload)))
+	return seq, nil
+}
+
+// read calls fn with the records from sequence number from on, until fn
+// returns false, and returns the sequence number after the last one.
+func (s *segment) read(from uint64, fn func(seq uint64, payload []byte) bool) (uint64, error) {
+	i := (from - s.first) / indexEvery
+	off := s.index[i]
+	r := bufio.NewReader(io.NewSectionReader(s.f, off, s.size-off))
+	var hdr [recordHeaderLen]byte
+	var payload []byte
+	for seq := s.first + i*indexEvery; seq < s.next; seq++ {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return from, err
+		}
+		n := int(binary.BigEndian.Uint32(hdr[16:]))
+		if seq < from {
+			if _, err := r.Discard(n); err != nil {
+				return from, err
+			}
+			continue
+		}
+		if cap(payload) < n {
+			payload = make([]byte, n)
+		}
+		payload = payload[:n]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return from, err
+		}
+		from = seq + 1
+		if !fn(seq, payload) {
+			break
+		}
+	}
+	return from, nil
+}
+
+// topicLog is the log of a topic, a directory of segments named after the
+// sequence number they start with.
+type topicLog struct {
+	dir    string
+	segs   []*segment // oldest first, the last one is appended to
+	closed bool
+	sync.RWMutex
+}
+
+func segmentPath(dir string, first uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", first, segmentExt))
+}
+
+func openTopic(dir string) (*topicLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	t := &topicLog{dir: dir}
+	for _, name := range names {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+		if err != nil || first == 0 {
+			continue
+		}
+		s, err := openSegment(name, first)
+		if err != nil {
+			t.close()
+			return nil, err
+		}
+		t.segs = append(t.segs, s)
+	}
+	if len(t.segs) == 0 {
+		s, err := openSegment(segmentPath(dir, 1), 1)
+		if err != nil {
+			return nil, err
+		}
+		t.segs = append(t.segs, s)
+	}
+	return t, nil
+}
+
+// next returns the sequence number the next message will get.
+func (t *topicLog) next() uint64 {
+	t.RLock()
+	defer t.RUnlock()
+	return t.segs[len(t.segs)-1].next
+}
+
+func (t *topicLog) append(born int64, payload []byte, segmentSize int, sync bool) (uint64, error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.closed {
+		return 0, nano.ErrClosed
+	}
+
+	s := t.segs[len(t.segs)-1]
+	if s.size > 0 && s.size+int64(len(payload)) > int64(segmentSize) {
+		roll, err := openSegment(segmentPath(t.dir, s.next), s.next)
+		if err != nil {
+			return 0, err
+		}
+		t.segs = append(t.segs, roll)
+		s = roll
+	}
+	return s.append(born, payload, sync)
+}
+
+// read calls fn with the records from sequence number from on, until fn
+// returns false or there are no more, and returns the sequence number to
+// read from next time.  Reading from 0 starts after the last record,
+// reading from before the first record starts with it.
+func (t *topicLog) read(from uint64, fn func(seq uint64, payload []byte) bool) (uint64, error) {
+	t.RLock()
+	defer t.RUnlock()
+	if t.closed {
+		return from, nano.ErrClosed
+	}
+
+	last := t.segs[len(t.segs)-1]
+	if from == 0 || from > last.next {
+		return last.next, nil
+	}
+	if first := t.segs[0].first; from < first {
+		from = first
+	}
+
+	more := true
+	for _, s := range t.segs {
+		if from >= s.next || !more {
+			continue
+		}
+		if from < s.first {
+			from = s.first // a gap, from a damaged segment
+		}
+		next, err := s.read(from, func(seq uint64, payload []byte) bool {
+			more = fn(seq, payload)
+			return more
+		})
+		if err != nil {
+			return from, err
+		}
+		from = next
+	}
+	return from, nil
+}
+
+// trim deletes the oldest segments while the log is larger than maxSize,
+// or they are older than maxAge.  The last segment stays.
+func (t *topicLog) trim(maxSize int, maxAge time.Duration, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	var size int64
+	for _, s := range t.segs {
+		size += s.size
+	}
+	for len(t.segs) > 1 && !t.closed {
+		s := t.segs[0]
+		if (maxSize <= 0 || size <= int64(maxSize)) &&
+			(maxAge <= 0 || now.Sub(time.Unix(0, s.born)) <= maxAge) {
+			return
+		}
+		s.f.Close()
+		if err := os.Remove(s.f.Name()); err != nil {
+			nano.Debugf("%s: %v", s.f.Name(), err)
+		}
+		size -= s.size
+		t.segs[0] = nil
+		t.segs = t.segs[1:]
+	}
+}
+
+func (t *topicLog) close() {
+	t.Lock()
+	defer t.Unlock()
+	t.closed = true
+	for _, s := range t.segs {
+		s.f.Close()
+	}
+}
+
+// msgLog is the message log of an XPUB socket, a directory of topics.
+type msgLog struct {
+	dir    string
+	topics map[string]*topicLog
+	closed bool
+	sync.Mutex
+}
+
+func openLog(dir string) (*msgLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &msgLog{dir: dir, topics: make(map[string]*topicLog)}
+	for _, fi := range fis {
+		topic, ok := topicName(fi.Name())
+		if !fi.IsDir() || !ok {
+			continue
+		}
+		t, err := openTopic(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		l.topics[topic] = t
+	}
+	return l, nil
+}
+
+// topic returns the log of a topic, or nil if it has none and create is
+// false.
+func (l *msgLog) topic(name string, create bool) (*topicLog, error) {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return nil, nano.ErrClosed
+	}
+	t := l.topics[name]
+	if t == nil && create {
+		var err error
+		if t, err = openTopic(filepath.Join(l.dir, topicDir(name))); err != nil {
+			return nil, err
+		}
+		l.topics[name] = t
+	}
+	return t, nil
+}
+
+func (l *msgLog) trim(maxSize int, maxAge time.Duration) {
+	l.Lock()
+	topics := make([]*topicLog, 0, len(l.topics))
+	for _, t := range l.topics {
+		topics = append(topics, t)
+	}
+	l.Unlock()
+
+	now := time.Now()
+	for _, t := range topics {
+		t.trim(maxSize, maxAge, now)
+	}
+}
+
+func (l *msgLog) close() {
+	l.Lock()
+	defer l.Unlock()
+	l.closed = true
+	for _, t := range l.topics {
+		t.close()
+	}
+}
+
+// topicDir returns the directory name of a topic: letters, digits, '-'
+// and '_' stay, other bytes are escaped as %XX, and the empty topic is a
+// lone %.
+func topicDir(topic string) string {
+	if topic == "" {
+		return "%"
+	}
+	var b strings.Builder
+	for i := 0; i < len(topic); i++ {
+		c := topic[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || c == '-' || c == '_' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// topicName reverses topicDir.
+func topicName(dir string) (string, bool) {
+	if dir == "%" {
+		return "", true
+	}
+	var b strings.Builder
+	for i := 0; i < len(dir); i++ {
+		if dir[i] != '%' {
+			b.WriteByte(dir[i])
+			continue
+		}
+		if i+2 >= len(dir) {
+			return "", false
+		}
+		c, err := strconv.ParseUint(dir[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", false
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), true
+}
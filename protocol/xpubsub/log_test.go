@@ -0,0 +1,103 @@
+package xpubsub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func readAll(t *testing.T, tl *topicLog, from uint64) (seqs []uint64, payloads []string) {
+	_, err := tl.read(from, func(seq uint64, payload []byte) bool {
+		seqs = append(seqs, seq)
+		payloads = append(payloads, string(payload))
+		return true
+	})
+	assert.Equal(t, nil, err)
+	return
+}
+
+func TestLogSegments(t *testing.T) {
+	dir := t.TempDir()
+	tl, err := openTopic(dir)
+	assert.Equal(t, nil, err)
+	for i := 1; i <= 200; i++ {
+		seq, err := tl.append(time.Now().UnixNano(), []byte(fmt.Sprint(i)), 1000, false)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, uint64(i), seq)
+	}
+	if len(tl.segs) < 5 {
+		t.Fatalf("%d segments", len(tl.segs))
+	}
+
+	seqs, payloads := readAll(t, tl, 130)
+	assert.Equal(t, 71, len(seqs))
+	assert.Equal(t, uint64(130), seqs[0])
+	assert.Equal(t, "200", payloads[70])
+	seqs, _ = readAll(t, tl, 0)
+	assert.Equal(t, 0, len(seqs))
+
+	// stopping early
+	n := 0
+	next, err := tl.read(1, func(uint64, []byte) bool {
+		n++
+		return n < 10
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(11), next)
+
+	// the oldest segments go first, the last one stays
+	tl.trim(3000, 0, time.Now())
+	first := tl.segs[0].first
+	seqs, _ = readAll(t, tl, 1)
+	assert.Equal(t, first, seqs[0])
+	tl.trim(0, time.Millisecond, time.Now().Add(time.Second))
+	assert.Equal(t, 1, len(tl.segs))
+	tl.close()
+
+	tl, err = openTopic(dir)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(201), tl.next())
+	tl.close()
+}
+
+func TestLogTornTail(t *testing.T) {
+	dir := t.TempDir()
+	tl, err := openTopic(dir)
+	assert.Equal(t, nil, err)
+	for _, s := range []string{"a", "b", "c"} {
+		_, err = tl.append(time.Now().UnixNano(), []byte(s), 1<<20, true)
+		assert.Equal(t, nil, err)
+	}
+	tl.close()
+
+	// a crash in the middle of the last record
+	path := segmentPath(dir, 1)
+	fi, err := os.Stat(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, os.Truncate(path, fi.Size()-1))
+
+	tl, err = openTopic(dir)
+	assert.Equal(t, nil, err)
+	_, payloads := readAll(t, tl, 1)
+	assert.Equal(t, []string{"a", "b"}, payloads)
+	seq, err := tl.append(time.Now().UnixNano(), []byte("d"), 1<<20, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(3), seq)
+	tl.close()
+}
+
+func TestLogTopicDir(t *testing.T) {
+	for _, topic := range []string{"", "orders", "orders.eu", "a/b", "..", "%", "_x-1"} {
+		dir := topicDir(topic)
+		assert.Equal(t, dir, filepath.Base(dir))
+		name, ok := topicName(dir)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, topic, name)
+	}
+	_, ok := topicName("%4")
+	assert.Equal(t, false, ok)
+}
@@ -0,0 +1,49 @@
+package xpubsub
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/funkygao/nano"
+)
+
+// Subscription is an nano.OptionSubscribe value for XSUB sockets.
+type Subscription struct {
+	Topic string
+
+	// From is the sequence number of the first message wanted, 1 for
+	// the whole log.  Zero means only messages published from now on.
+	From uint64
+}
+
+// Encode makes a message body out of a topic and a payload.  The topic
+// must not contain zero bytes.
+func Encode(topic string, payload []byte) []byte {
+	b := make([]byte, 0, len(topic)+1+len(payload))
+	b = append(append(b, topic...), 0)
+	return append(b, payload...)
+}
+
+// Decode splits a message body into its topic and payload.
+func Decode(body []byte) (topic string, payload []byte, err error) {
+	i := bytes.IndexByte(body, 0)
+	if i < 0 {
+		return "", nil, nano.ErrNoTopic
+	}
+	return string(body[:i]), body[i+1:], nil
+}
+
+// Seq returns the sequence number of a message received by XSUB with
+// RecvMsg, in its topic log.
+func Seq(m *nano.Message) uint64 {
+	if len(m.Header) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(m.Header)
+}
+
+// Frames from XSUB to XPUB.
+const (
+	frameSubscribe   = 1 // type, sequence number to start from, topic
+	frameUnsubscribe = 2 // type, topic
+)
@@ -1,37 +1,302 @@
 package xpubsub
 
 import (
+	"encoding/binary"
+	"sync"
 	"time"
 
 	"github.com/funkygao/nano"
 )
 
+// maxBatch is how many messages of a topic a subscriber is sent in a row,
+// before the next topic gets its turn.
+const maxBatch = 64
+
+// trimInterval is how often retention is enforced.
+const trimInterval = time.Second
+
+type xpubEp struct {
+	ep    nano.Endpoint
+	x     *xpub
+	subs  map[string]uint64 // topic, sequence number to send next
+	marks map[string]uint64 // where subscriptions to new messages start
+	wake  chan struct{}
+	gone  chan struct{}
+}
+
+func (pe *xpubEp) notify() {
+	select {
+	case pe.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (pe *xpubEp) receiver() {
+	for {
+		m := pe.ep.RecvMsg()
+		if m == nil {
+			return
+		}
+
+		switch {
+		case len(m.Body) >= 9 && m.Body[0] == frameSubscribe:
+			pe.x.subscribe(pe, string(m.Body[9:]), binary.BigEndian.Uint64(m.Body[1:]))
+		case len(m.Body) >= 1 && m.Body[0] == frameUnsubscribe:
+			pe.x.Lock()
+			delete(pe.subs, string(m.Body[1:]))
+			pe.x.Unlock()
+		}
+		m.Free()
+	}
+}
+
+// sender streams the logs of the subscribed topics, for as long as there
+// is something to send.
+func (pe *xpubEp) sender() {
+	closeChan := pe.x.sock.CloseChannel()
+	for {
+		select {
+		case <-pe.wake:
+		case <-pe.gone:
+			return
+		case <-closeChan:
+			return
+		}
+
+		for {
+			sent, err := pe.x.flush(pe)
+			if err != nil {
+				return
+			}
+			if !sent {
+				break
+			}
+		}
+	}
+}
+
+// xpub appends messages to the log, and replays it to subscribers.
 type xpub struct {
 	sock nano.ProtocolSocket
+	log  *msgLog
+	eps  map[nano.EndpointId]*xpubEp
+	w    nano.Waiter
+
+	dir           string
+	segmentSize   int
+	retentionSize int
+	retentionTime time.Duration
+	sync          bool
+
+	sync.Mutex
+}
+
+func (x *xpub) Init(sock nano.ProtocolSocket) {
+	x.sock = sock
+	x.eps = make(map[nano.EndpointId]*xpubEp)
+	x.segmentSize = 64 << 20
+	x.retentionSize = 1 << 30
+	x.retentionTime = 7 * 24 * time.Hour
+
+	// send only
+	x.sock.SetRecvError(nano.ErrProtoOp)
+
+	x.w.Init()
+	x.w.Add()
+	go x.trimmer()
 }
 
-func (this *xpub) Init(sock nano.ProtocolSocket) {
-	this.sock = sock
+func (x *xpub) AddEndpoint(ep nano.Endpoint) {
+	pe := &xpubEp{
+		ep:    ep,
+		x:     x,
+		subs:  make(map[string]uint64),
+		marks: make(map[string]uint64),
+		wake:  make(chan struct{}, 1),
+		gone:  make(chan struct{}),
+	}
+	x.Lock()
+	x.eps[ep.Id()] = pe
+	x.Unlock()
+
+	go pe.sender()
+	go pe.receiver()
 }
 
-func (this *xpub) AddEndpoint(ep nano.Endpoint) {
+func (x *xpub) RemoveEndpoint(ep nano.Endpoint) {
+	x.Lock()
+	if pe := x.eps[ep.Id()]; pe != nil {
+		delete(x.eps, ep.Id())
+		close(pe.gone)
+	}
+	x.Unlock()
+}
+
+// SyncSend implements the ProtocolSyncSender SyncSend method: the message
+// is in the log when Send returns.
+func (x *xpub) SyncSend(m *nano.Message) error {
+	defer m.Free()
+	topic, payload, err := Decode(m.Body)
+	if err != nil {
+		return err
+	}
+
+	x.Lock()
+	log, segmentSize, sync := x.log, x.segmentSize, x.sync
+	x.Unlock()
+	if log == nil {
+		return nano.ErrNoLogDir
+	}
+	t, err := log.topic(topic, true)
+	if err != nil {
+		return err
+	}
+	if _, err = t.append(time.Now().UnixNano(), payload, segmentSize, sync); err != nil {
+		return err
+	}
 
+	x.Lock()
+	for _, pe := range x.eps {
+		if _, ok := pe.subs[topic]; ok {
+			pe.notify()
+		}
+	}
+	x.Unlock()
+	return nil
 }
 
-func (this *xpub) RemoveEndpoint(ep nano.Endpoint) {
+// subscribe has a subscriber start a topic from sequence number from, or
+// after the last message if from is 0.  In that case, the subscriber is
+// told where that is, to resume from there even if it reconnects before
+// the next message.
+func (x *xpub) subscribe(pe *xpubEp, topic string, from uint64) {
+	x.Lock()
+	log := x.log
+	x.Unlock()
+	mark := from == 0 && log != nil
+	if mark {
+		from = 1
+		if t, _ := log.topic(topic, false); t != nil {
+			from = t.next()
+		}
+	}
 
+	x.Lock()
+	pe.subs[topic] = from
+	if mark {
+		pe.marks[topic] = from
+	}
+	x.Unlock()
+	pe.notify()
 }
 
-func (this *xpub) Shutdown(expire time.Time) {
+// flush sends a batch of each subscribed topic, and reports whether there
+// was anything to send.
+func (x *xpub) flush(pe *xpubEp) (bool, error) {
+	x.Lock()
+	log := x.log
+	subs := make(map[string]uint64, len(pe.subs))
+	for topic, from := range pe.subs {
+		subs[topic] = from
+	}
+	marks := pe.marks
+	pe.marks = make(map[string]uint64)
+	x.Unlock()
+	if log == nil {
+		return false, nil
+	}
+
+	// a mark is a message without payload, nor the zero byte
+	for topic, seq := range marks {
+		m := nano.NewMessage(8 + len(topic))
+		m.Body = append(m.Body, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(m.Body, seq)
+		m.Body = append(m.Body, topic...)
+		if err := pe.ep.SendMsg(m); err != nil {
+			return false, err
+		}
+	}
+
+	sent := false
+	for topic, from := range subs {
+		t, err := log.topic(topic, false)
+		if err != nil {
+			return false, err
+		}
+		if t == nil {
+			continue
+		}
+
+		var batch []*nano.Message
+		next, err := t.read(from, func(seq uint64, payload []byte) bool {
+			m := nano.NewMessage(8 + len(topic) + 1 + len(payload))
+			m.Body = append(m.Body, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.BigEndian.PutUint64(m.Body, seq)
+			m.Body = append(append(m.Body, topic...), 0)
+			m.Body = append(m.Body, payload...)
+			batch = append(batch, m)
+			return len(batch) < maxBatch
+		})
+		if err == nil {
+			for len(batch) > 0 {
+				m := batch[0]
+				batch = batch[1:]
+				if err = pe.ep.SendMsg(m); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			for _, m := range batch {
+				m.Free()
+			}
+			return false, err
+		}
 
+		x.Lock()
+		// unless it was subscribed again meanwhile
+		if cur, ok := pe.subs[topic]; ok && cur == from {
+			pe.subs[topic] = next
+			sent = sent || next != from
+		}
+		x.Unlock()
+	}
+	return sent, nil
 }
 
-func (this *xpub) SetOption(name string, val interface{}) error {
-	return nil
+// trimmer enforces the retention of the log.
+func (x *xpub) trimmer() {
+	defer x.w.Done()
+	closeChan := x.sock.CloseChannel()
+	ticker := time.NewTicker(trimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closeChan:
+			return
+
+		case <-ticker.C:
+			x.Lock()
+			log, size, age := x.log, x.retentionSize, x.retentionTime
+			x.Unlock()
+			if log != nil {
+				log.trim(size, age)
+			}
+		}
+	}
 }
 
-func (this *xpub) GetOption(name string) (interface{}, error) {
-	return nil, nil
+// Shutdown closes the log.  There is nothing to drain, subscribers catch
+// up from the log when they reconnect.
+func (x *xpub) Shutdown(expire time.Time) {
+	x.w.WaitAbsTimeout(expire)
+
+	x.Lock()
+	log := x.log
+	x.Unlock()
+	if log != nil {
+		log.close()
+	}
 }
 
 func (*xpub) Number() uint16 {
@@ -42,6 +307,73 @@ func (*xpub) PeerNumber() uint16 {
 	return nano.ProtoXSub
 }
 
+func (x *xpub) SetOption(name string, val interface{}) error {
+	x.Lock()
+	defer x.Unlock()
+	switch name {
+	case nano.OptionLogDir:
+		dir, ok := val.(string)
+		if !ok || dir == "" {
+			return nano.ErrBadValue
+		}
+		if x.log != nil {
+			return nano.ErrProtoState
+		}
+		log, err := openLog(dir)
+		if err != nil {
+			return err
+		}
+		x.log, x.dir = log, dir
+	case nano.OptionLogSegmentSize:
+		if v, ok := val.(int); !ok || v <= 0 {
+			return nano.ErrBadValue
+		} else {
+			x.segmentSize = v
+		}
+	case nano.OptionLogRetentionSize:
+		if v, ok := val.(int); !ok || v < 0 {
+			return nano.ErrBadValue
+		} else {
+			x.retentionSize = v
+		}
+	case nano.OptionLogRetentionTime:
+		if v, ok := val.(time.Duration); !ok || v < 0 {
+			return nano.ErrBadValue
+		} else {
+			x.retentionTime = v
+		}
+	case nano.OptionLogSync:
+		if v, ok := val.(bool); !ok {
+			return nano.ErrBadValue
+		} else {
+			x.sync = v
+		}
+	default:
+		return nano.ErrBadOption
+	}
+	return nil
+}
+
+func (x *xpub) GetOption(name string) (interface{}, error) {
+	x.Lock()
+	defer x.Unlock()
+	switch name {
+	case nano.OptionLogDir:
+		return x.dir, nil
+	case nano.OptionLogSegmentSize:
+		return x.segmentSize, nil
+	case nano.OptionLogRetentionSize:
+		return x.retentionSize, nil
+	case nano.OptionLogRetentionTime:
+		return x.retentionTime, nil
+	case nano.OptionLogSync:
+		return x.sync, nil
+	default:
+		return nil, nano.ErrBadOption
+	}
+}
+
+// MakeXPubSocket allocates a new Socket using the XPUB protocol.
 func MakeXPubSocket() nano.Socket {
 	return nano.MakeSocket(&xpub{})
 }
@@ -1,39 +1,199 @@
 package xpubsub
 
 import (
+	"encoding/binary"
+	"sync"
 	"time"
 
 	"github.com/funkygao/nano"
 )
 
+// topicSub is the state of a subscription.
+type topicSub struct {
+	next    uint64 // sequence number to resume from, 0 for new messages
+	recvd   uint64 // last sequence number delivered
+	version int    // changes when the subscription must be sent again
+}
+
+type xsubEp struct {
+	ep   nano.Endpoint
+	x    *xsub
+	sent map[string]int // subscription versions sent, owned by sender
+	wake chan struct{}
+	gone chan struct{}
+}
+
+func (pe *xsubEp) notify() {
+	select {
+	case pe.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sender sends the subscriptions to XPUB, first all, then the changes.
+func (pe *xsubEp) sender() {
+	closeChan := pe.x.sock.CloseChannel()
+	for {
+		for _, m := range pe.x.changes(pe) {
+			if pe.ep.SendMsg(m) != nil {
+				return
+			}
+		}
+
+		select {
+		case <-pe.wake:
+		case <-pe.gone:
+			return
+		case <-closeChan:
+			return
+		}
+	}
+}
+
+func (pe *xsubEp) receiver() {
+	recvChan := pe.x.sock.RecvChannel()
+	closeChan := pe.x.sock.CloseChannel()
+	for {
+		m := pe.ep.RecvMsg()
+		if m == nil {
+			return
+		}
+		if len(m.Body) < 8 {
+			m.Free() // ErrGarbled
+			continue
+		}
+		seq := binary.BigEndian.Uint64(m.Body)
+		topic, _, err := Decode(m.Body[8:])
+		if err != nil {
+			pe.x.marked(string(m.Body[8:]), seq)
+			m.Free()
+			continue
+		}
+		if !pe.x.received(topic, seq) {
+			m.Free()
+			continue
+		}
+
+		m.Header = append(m.Header, m.Body[:8]...)
+		m.Body = m.Body[8:]
+		select {
+		case recvChan <- m:
+		case <-closeChan:
+			m.Free()
+			return
+		}
+	}
+}
+
+// xsub subscribes to topics, and keeps track of where to resume them.
 type xsub struct {
 	sock nano.ProtocolSocket
-}
+	subs map[string]*topicSub
+	eps  map[nano.EndpointId]*xsubEp
 
-func (this *xsub) Init(sock nano.ProtocolSocket) {
-	this.sock = sock
+	sync.Mutex
 }
 
-func (this *xsub) AddEndpoint(ep nano.Endpoint) {
+func (x *xsub) Init(sock nano.ProtocolSocket) {
+	x.sock = sock
+	x.subs = make(map[string]*topicSub)
+	x.eps = make(map[nano.EndpointId]*xsubEp)
 
+	// receive only
+	x.sock.SetSendError(nano.ErrProtoOp)
 }
 
-func (this *xsub) RemoveEndpoint(ep nano.Endpoint) {
+func (x *xsub) AddEndpoint(ep nano.Endpoint) {
+	pe := &xsubEp{
+		ep:   ep,
+		x:    x,
+		sent: make(map[string]int),
+		wake: make(chan struct{}, 1),
+		gone: make(chan struct{}),
+	}
+	x.Lock()
+	x.eps[ep.Id()] = pe
+	x.Unlock()
 
+	go pe.sender()
+	go pe.receiver()
 }
 
-func (this *xsub) Shutdown(expire time.Time) {
+func (x *xsub) RemoveEndpoint(ep nano.Endpoint) {
+	x.Lock()
+	if pe := x.eps[ep.Id()]; pe != nil {
+		delete(x.eps, ep.Id())
+		close(pe.gone)
+	}
+	x.Unlock()
+}
+
+// changes returns the frames that bring the subscriptions of the peer up
+// to date.
+func (x *xsub) changes(pe *xsubEp) []*nano.Message {
+	x.Lock()
+	defer x.Unlock()
 
+	var frames []*nano.Message
+	for topic, s := range x.subs {
+		if v, ok := pe.sent[topic]; ok && v == s.version {
+			continue
+		}
+		m := nano.NewMessage(9 + len(topic))
+		m.Body = append(m.Body, frameSubscribe, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(m.Body[1:], s.next)
+		m.Body = append(m.Body, topic...)
+		frames = append(frames, m)
+		pe.sent[topic] = s.version
+	}
+	for topic := range pe.sent {
+		if x.subs[topic] == nil {
+			m := nano.NewMessage(1 + len(topic))
+			m.Body = append(append(m.Body, frameUnsubscribe), topic...)
+			frames = append(frames, m)
+			delete(pe.sent, topic)
+		}
+	}
+	return frames
 }
 
-func (this *xsub) SetOption(name string, val interface{}) error {
-	return nil
+// received reports whether a message is to be delivered: it is
+// subscribed to, and not delivered already.
+func (x *xsub) received(topic string, seq uint64) bool {
+	x.Lock()
+	defer x.Unlock()
+	s := x.subs[topic]
+	if s == nil || seq <= s.recvd {
+		return false
+	}
+	if s.recvd != 0 && seq != s.recvd+1 {
+		nano.Debugf("xsub %s: %d messages lost to retention", topic, seq-s.recvd-1)
+	}
+	s.recvd = seq
+	if s.next == 0 {
+		s.next = seq
+	}
+	return true
 }
 
-func (this *xsub) GetOption(name string) (interface{}, error) {
-	return nil, nil
+// marked notes where a subscription to new messages starts.
+func (x *xsub) marked(topic string, seq uint64) {
+	x.Lock()
+	defer x.Unlock()
+	if s := x.subs[topic]; s != nil && s.next == 0 {
+		s.next = seq
+	}
 }
 
+func (x *xsub) notifyAll() {
+	for _, pe := range x.eps {
+		pe.notify()
+	}
+}
+
+// Shutdown has nothing to drain, subscriptions are sent again on connect.
+func (*xsub) Shutdown(time.Time) {}
+
 func (*xsub) Number() uint16 {
 	return nano.ProtoXSub
 }
@@ -42,6 +202,110 @@ func (*xsub) PeerNumber() uint16 {
 	return nano.ProtoXPub
 }
 
+func (x *xsub) SetOption(name string, val interface{}) error {
+	var sub Subscription
+	switch v := val.(type) {
+	case string:
+		sub.Topic = v
+	case []byte:
+		sub.Topic = string(v)
+	case Subscription:
+		sub = v
+	default:
+		if name == nano.OptionSubscribe || name == nano.OptionUnsubscribe {
+			return nano.ErrBadValue
+		}
+		return nano.ErrBadOption
+	}
+
+	x.Lock()
+	defer x.Unlock()
+	switch name {
+	case nano.OptionSubscribe:
+		s := x.subs[sub.Topic]
+		if s == nil {
+			s = &topicSub{}
+			x.subs[sub.Topic] = s
+		} else if sub.From == 0 {
+			return nil // already present
+		}
+		// start over from there
+		s.next = sub.From
+		s.recvd = 0
+		if sub.From > 0 {
+			s.recvd = sub.From - 1
+		}
+		s.version++
+		x.notifyAll()
+		return nil
+
+	case nano.OptionUnsubscribe:
+		if _, ok := val.(Subscription); ok {
+			return nano.ErrBadValue
+		}
+		if x.subs[sub.Topic] == nil {
+			return nano.ErrBadValue
+		}
+		delete(x.subs, sub.Topic)
+		x.notifyAll()
+		return nil
+
+	default:
+		return nano.ErrBadOption
+	}
+}
+
+func (x *xsub) GetOption(name string) (interface{}, error) {
+	return nil, nano.ErrBadOption
+}
+
+// NewXSubSocket allocates a new Socket using the XSUB protocol.
 func NewXSubSocket() nano.Socket {
 	return nano.MakeSocket(&xsub{})
 }
+
+func xsubOf(sock nano.Socket) (*xsub, error) {
+	if x, ok := sock.GetProtocol().(*xsub); ok {
+		return x, nil
+	}
+	return nil, nano.ErrProtoOp
+}
+
+// Ack acknowledges that the application has processed the messages of a
+// topic up to sequence number seq, so that XSUB resumes after it when it
+// reconnects.
+func Ack(sock nano.Socket, topic string, seq uint64) error {
+	x, err := xsubOf(sock)
+	if err != nil {
+		return err
+	}
+	x.Lock()
+	defer x.Unlock()
+	s := x.subs[topic]
+	if s == nil {
+		return nano.ErrBadValue
+	}
+	if seq >= s.next {
+		s.next = seq + 1
+	}
+	return nil
+}
+
+// Offset returns the sequence number a topic resumes from, that is after
+// the last acknowledged message, or where the subscription started if
+// none was.  An application saves it to subscribe From there after a
+// restart.  For subscriptions to new messages, it is zero until XPUB has
+// answered.
+func Offset(sock nano.Socket, topic string) (uint64, error) {
+	x, err := xsubOf(sock)
+	if err != nil {
+		return 0, err
+	}
+	x.Lock()
+	defer x.Unlock()
+	s := x.subs[topic]
+	if s == nil {
+		return 0, nano.ErrBadValue
+	}
+	return s.next, nil
+}
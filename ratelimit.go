@@ -0,0 +1,199 @@
+package nano
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket is a classic token bucket rate limiter.  Tokens refill
+// continuously at rate per second, up to burst.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	sync.Mutex
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	if burst < rate {
+		burst = rate
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refill must be called with the lock held.
+func (this *tokenBucket) refill(now time.Time) {
+	this.tokens += now.Sub(this.last).Seconds() * this.rate
+	if this.tokens > this.burst {
+		this.tokens = this.burst
+	}
+	this.last = now
+}
+
+// take consumes n tokens if they are available without waiting.
+func (this *tokenBucket) take(n int) bool {
+	this.Lock()
+	this.refill(time.Now())
+	if this.tokens < float64(n) {
+		this.Unlock()
+		return false
+	}
+	this.tokens -= float64(n)
+	this.Unlock()
+	return true
+}
+
+// reserve consumes n tokens unconditionally, going into debt if needed,
+// and returns how long the caller should wait before proceeding.  This
+// lets items larger than burst pass at the configured rate.
+func (this *tokenBucket) reserve(n int) time.Duration {
+	this.Lock()
+	this.refill(time.Now())
+	this.tokens -= float64(n)
+	tokens := this.tokens
+	this.Unlock()
+
+	if tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tokens / this.rate * float64(time.Second))
+}
+
+// refund gives back n tokens of a reservation that was not used.
+func (this *tokenBucket) refund(n int) {
+	this.Lock()
+	this.refill(time.Now())
+	this.tokens += float64(n)
+	if this.tokens > this.burst {
+		this.tokens = this.burst
+	}
+	this.Unlock()
+}
+
+// Rate expresses a throughput limit used by OptionSendRate, OptionRecvRate
+// and their per connection variants.  Zero fields mean unlimited.
+type Rate struct {
+	BytesPerSec int
+	MsgsPerSec  int
+}
+
+// throttle enforces a Rate on a stream of messages.
+type throttle struct {
+	rate  Rate
+	bytes *tokenBucket
+	msgs  *tokenBucket
+}
+
+// newThrottle returns nil if the rate is unlimited.
+func newThrottle(rate Rate) *throttle {
+	if rate.BytesPerSec <= 0 && rate.MsgsPerSec <= 0 {
+		return nil
+	}
+
+	t := &throttle{rate: rate}
+	if rate.BytesPerSec > 0 {
+		t.bytes = newTokenBucket(rate.BytesPerSec, rate.BytesPerSec)
+	}
+	if rate.MsgsPerSec > 0 {
+		t.msgs = newTokenBucket(rate.MsgsPerSec, rate.MsgsPerSec)
+	}
+	return t
+}
+
+// wait blocks until a message of sz bytes may pass.  It returns false if
+// cancel was closed while waiting, and the reserved tokens are given back
+// so that the other connections sharing the throttle do not pay for them.
+func (this *throttle) wait(sz int, cancel <-chan struct{}) bool {
+	var delay time.Duration
+	if this.msgs != nil {
+		delay = this.msgs.reserve(1)
+	}
+	if this.bytes != nil {
+		if d := this.bytes.reserve(sz); d > delay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return true
+	}
+
+	t := time.NewTimer(delay)
+	select {
+	case <-t.C:
+		return true
+	case <-cancel:
+		t.Stop()
+		if this.msgs != nil {
+			this.msgs.refund(1)
+		}
+		if this.bytes != nil {
+			this.bytes.refund(sz)
+		}
+		return false
+	}
+}
+
+// rateLimits holds the rate related options of a socket, dialer or
+// listener.  send and recv are shared by all connections beneath, while
+// connSend and connRecv are applied to each connection separately.
+type rateLimits struct {
+	send     *throttle
+	recv     *throttle
+	connSend Rate
+	connRecv Rate
+}
+
+var rateOpts = map[string]bool{
+	OptionSendRate:     true,
+	OptionRecvRate:     true,
+	OptionConnSendRate: true,
+	OptionConnRecvRate: true,
+}
+
+func (this *rateLimits) set(name string, val interface{}) error {
+	rate, ok := val.(Rate)
+	if !ok || rate.BytesPerSec < 0 || rate.MsgsPerSec < 0 {
+		return ErrBadValue
+	}
+
+	switch name {
+	case OptionSendRate:
+		this.send = newThrottle(rate)
+	case OptionRecvRate:
+		this.recv = newThrottle(rate)
+	case OptionConnSendRate:
+		this.connSend = rate
+	case OptionConnRecvRate:
+		this.connRecv = rate
+	default:
+		return ErrBadOption
+	}
+	return nil
+}
+
+func (this *rateLimits) get(name string) (interface{}, error) {
+	switch name {
+	case OptionSendRate:
+		if this.send == nil {
+			return Rate{}, nil
+		}
+		return this.send.rate, nil
+	case OptionRecvRate:
+		if this.recv == nil {
+			return Rate{}, nil
+		}
+		return this.recv.rate, nil
+	case OptionConnSendRate:
+		return this.connSend, nil
+	case OptionConnRecvRate:
+		return this.connRecv, nil
+	}
+	return nil, ErrBadOption
+}
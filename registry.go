@@ -0,0 +1,58 @@
+package nano
+
+import (
+	"sort"
+	"sync"
+)
+
+// transports is the process wide transport registry, which sockets fall
+// back to for schemes they have no transport of their own for.
+var transports struct {
+	byScheme map[string]Transport
+	sync.RWMutex
+}
+
+// RegisterTransport makes a transport available to all sockets, the way
+// database/sql drivers are registered.  Transport packages call it from
+// their init, so that importing them is enough:
+//
+//	import _ "github.com/funkygao/nano/transport/tcp"
+//
+// A transport added to a socket with AddTransport takes precedence over
+// the registered one for the same scheme, e.g. to set transport options.
+// It panics if t is nil or its scheme is already registered.
+func RegisterTransport(t Transport) {
+	if t == nil {
+		panic("nano: RegisterTransport transport is nil")
+	}
+
+	transports.Lock()
+	defer transports.Unlock()
+	if transports.byScheme == nil {
+		transports.byScheme = make(map[string]Transport)
+	}
+	if _, dup := transports.byScheme[t.Scheme()]; dup {
+		panic("nano: RegisterTransport called twice for " + t.Scheme())
+	}
+	transports.byScheme[t.Scheme()] = t
+}
+
+// RegisteredTransports returns the sorted schemes of the registered
+// transports.
+func RegisteredTransports() []string {
+	transports.RLock()
+	defer transports.RUnlock()
+	r := make([]string, 0, len(transports.byScheme))
+	for scheme := range transports.byScheme {
+		r = append(r, scheme)
+	}
+	sort.Strings(r)
+	return r
+}
+
+func registeredTransport(scheme string) (Transport, bool) {
+	transports.RLock()
+	defer transports.RUnlock()
+	t, present := transports.byScheme[scheme]
+	return t, present
+}
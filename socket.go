@@ -2,6 +2,7 @@ package nano
 
 import (
 	"bytes"
+	"os"
 )
 
 // Socket is the main access handle applications use to access the SP
@@ -43,8 +44,12 @@ type Socket interface {
 	// returns immediately, and an asynchronous goroutine is started to
 	// establish and maintain the connection, reconnecting as needed.
 	// If the address is invalid, then an error is returned.
+	// Options can be given in the address query, see DialOptions.
 	Dial(addr string) error
 
+	// DialOptions is like Dial, with options for the dialer.  They
+	// override the options given in the address query, e.g.
+	// "tcp://host:9000?snappy=1&nodelay=0&maxrecv=8m".
 	DialOptions(addr string, options map[string]interface{}) error
 
 	// NewDialer returns a Dialer object which can be used to get
@@ -72,10 +77,54 @@ type Socket interface {
 
 	// AddTransport adds a new Transport to the socket.  Transport specific
 	// options may have been configured on the Transport prior to this.
+	// Without it, the transports registered with RegisterTransport are
+	// used.
 	AddTransport(Transport)
 
 	// SetPortHook sets a PortHook function to be called when a Port is
 	// added or removed from this socket (connect/disconnect).  The previous
 	// hook is returned (nil if none.)
 	SetPortHook(PortHook) PortHook
+
+	// ListenerFiles returns duplicates of the listening descriptors of
+	// all the active listeners, for a graceful binary upgrade: the new
+	// process is started with them and HandoffEnv, and adopts them when
+	// it listens on the same addresses.  Listeners of transports without
+	// descriptors are skipped.
+	ListenerFiles() ([]*os.File, error)
+
+	// OpenContext opens a new Context on the Socket, for protocols that
+	// can run several exchanges at once over the same connections, e.g.
+	// REQ.  Other protocols return ErrProtoOp.
+	OpenContext() (Context, error)
+}
+
+// Context is a handle on a Socket with its own protocol state, e.g. its
+// own outstanding request for REQ.  The Contexts of a Socket share its
+// connections, and each can be used by a different goroutine.  A single
+// Context is used by one goroutine at a time, the way a Socket is.
+type Context interface {
+
+	// Close closes the Context, and abandons its exchange in progress.
+	// The Socket stays open.
+	Close() error
+
+	// Send is like the Socket Send, in the Context.
+	Send([]byte) error
+
+	// SendMsg is like the Socket SendMsg, in the Context.
+	SendMsg(*Message) error
+
+	// Recv is like the Socket Recv, in the Context.
+	Recv() ([]byte, error)
+
+	// RecvMsg is like the Socket RecvMsg, in the Context.
+	RecvMsg() (*Message, error)
+
+	// GetOption is used to retrieve an option of the Context.
+	GetOption(name string) (interface{}, error)
+
+	// SetOption is used to set an option of the Context, e.g. its own
+	// deadlines.  Options start from the values of the Socket.
+	SetOption(name string, value interface{}) error
 }
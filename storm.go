@@ -0,0 +1,188 @@
+package nano
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// StormHeaderLen is the length of the header a StormGuard puts on
+// messages: the hops they have left (4 bytes), their origin (8) and their
+// sequence number at the origin (8).
+const StormHeaderLen = 20
+
+const (
+	defaultReplayWindow = 1024 // sequence numbers remembered per origin
+	maxOrigins          = 4096 // the least recently seen go first
+)
+
+// StormStats counts the messages a StormGuard dropped.
+type StormStats struct {
+	Duplicates int // seen already, or too old to tell
+	Expired    int // out of hops
+}
+
+// replayWindow remembers which of the last sequence numbers of an origin
+// were seen, in a ring of bits.
+type replayWindow struct {
+	top  uint64 // highest sequence number seen
+	bits []uint64
+	seen time.Time
+}
+
+// admit records seq, and reports whether it was not seen yet.
+func (this *replayWindow) admit(seq uint64) bool {
+	size := uint64(len(this.bits)) * 64
+	switch {
+	case seq > this.top:
+		if seq-this.top >= size {
+			for i := range this.bits {
+				this.bits[i] = 0
+			}
+		} else {
+			for s := this.top + 1; s < seq; s++ {
+				this.bits[s%size/64] &^= 1 << (s % 64)
+			}
+		}
+		this.top = seq
+
+	case this.top-seq >= size:
+		return false
+
+	case this.bits[seq%size/64]&(1<<(seq%64)) != 0:
+		return false
+	}
+
+	this.bits[seq%size/64] |= 1 << (seq % 64)
+	return true
+}
+
+// StormGuard keeps message storms out of topologies with cycles, for the
+// protocols that forward messages: each message carries its origin and
+// sequence number, and the hops it has left, and is dropped when it comes
+// back around a cycle, or is out of hops.  Duplicates are told apart in a
+// sliding window of the last sequence numbers of each origin.
+type StormGuard struct {
+	origin  uint64
+	next    uint64
+	window  int
+	origins map[uint64]*replayWindow
+	stats   StormStats
+
+	sync.Mutex
+}
+
+// NewStormGuard returns a StormGuard with an origin of its own.
+func NewStormGuard() *StormGuard {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		binary.BigEndian.PutUint64(b[:], uint64(time.Now().UnixNano()))
+	}
+	return &StormGuard{
+		origin:  binary.BigEndian.Uint64(b[:]),
+		window:  defaultReplayWindow,
+		origins: make(map[uint64]*replayWindow),
+	}
+}
+
+// SetWindow sets how many of the last sequence numbers of each origin are
+// remembered, rounded up to a multiple of 64.  It forgets those seen so
+// far.
+func (this *StormGuard) SetWindow(n int) error {
+	if n <= 0 {
+		return ErrBadValue
+	}
+	this.Lock()
+	this.window = (n + 63) / 64 * 64
+	this.origins = make(map[uint64]*replayWindow)
+	this.Unlock()
+	return nil
+}
+
+// Window returns the number of sequence numbers remembered per origin.
+func (this *StormGuard) Window() int {
+	this.Lock()
+	defer this.Unlock()
+	return this.window
+}
+
+// Stamp returns the header of a new message, with hops to go.  The
+// message counts as seen, so that it is dropped when it comes back.
+func (this *StormGuard) Stamp(hops int) []byte {
+	this.Lock()
+	this.next++
+	seq := this.next
+	this.admit(this.origin, seq)
+	this.Unlock()
+
+	h := make([]byte, StormHeaderLen)
+	binary.BigEndian.PutUint32(h, uint32(hops))
+	binary.BigEndian.PutUint64(h[4:], this.origin)
+	binary.BigEndian.PutUint64(h[12:], seq)
+	return h
+}
+
+// Admit reports whether the message of header h is to be delivered: it
+// has hops left, and was not seen yet.
+func (this *StormGuard) Admit(h []byte) bool {
+	this.Lock()
+	defer this.Unlock()
+	if binary.BigEndian.Uint32(h) == 0 {
+		this.stats.Expired++
+		return false
+	}
+	if !this.admit(binary.BigEndian.Uint64(h[4:]), binary.BigEndian.Uint64(h[12:])) {
+		this.stats.Duplicates++
+		return false
+	}
+	return true
+}
+
+// Forward takes a hop off the header h of a message to send on, and
+// reports whether it has any left to go.
+func (this *StormGuard) Forward(h []byte) bool {
+	hops := binary.BigEndian.Uint32(h)
+	if hops <= 1 {
+		this.Lock()
+		this.stats.Expired++
+		this.Unlock()
+		return false
+	}
+	binary.BigEndian.PutUint32(h, hops-1)
+	return true
+}
+
+// Stats returns the messages dropped so far.
+func (this *StormGuard) Stats() StormStats {
+	this.Lock()
+	defer this.Unlock()
+	return this.stats
+}
+
+// admit must be called with the lock held.
+func (this *StormGuard) admit(origin, seq uint64) bool {
+	now := time.Now()
+	w := this.origins[origin]
+	if w == nil {
+		if len(this.origins) >= maxOrigins {
+			this.evict()
+		}
+		w = &replayWindow{bits: make([]uint64, this.window/64)}
+		this.origins[origin] = w
+	}
+	w.seen = now
+	return w.admit(seq)
+}
+
+// evict forgets the origin seen the least recently.
+func (this *StormGuard) evict() {
+	var oldest uint64
+	var t time.Time
+	for origin, w := range this.origins {
+		if t.IsZero() || w.seen.Before(t) {
+			oldest, t = origin, w.seen
+		}
+	}
+	delete(this.origins, oldest)
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/reqrep"
+	"github.com/funkygao/nano/transport/tcp"
+	"github.com/funkygao/nano/transport/websocket"
+)
+
+func listenWithRejects(t *testing.T, addr string,
+	opts map[string]interface{}) (nano.Socket, chan error) {
+	sock := reqrep.NewRepSocket()
+	sock.AddTransport(tcp.NewTransport())
+
+	rejects := make(chan error, 10)
+	sock.SetPortHook(func(action nano.PortAction, p nano.Port) bool {
+		if action == nano.PortActionReject {
+			reason, _ := p.GetProp(nano.PropRejectReason)
+			rejects <- reason.(error)
+		}
+		return true
+	})
+
+	assert.Equal(t, nil, sock.ListenOptions(addr, opts))
+	return sock, rejects
+}
+
+func TestListenerMaxConnections(t *testing.T) {
+	addr := "tcp://127.0.0.1:3340"
+	sock, rejects := listenWithRejects(t, addr, map[string]interface{}{
+		nano.OptionMaxConnections: 1,
+	})
+	defer sock.Close()
+
+	tran := tcp.NewTransport()
+	proto := reqrep.NewReqSocket().GetProtocol()
+	d, err := tran.NewDialer(addr, proto)
+	assert.Equal(t, nil, err)
+
+	p1, err := d.Dial()
+	assert.Equal(t, nil, err)
+	defer p1.Close()
+
+	p2, err := d.Dial()
+	if err == nil {
+		p2.Close()
+		t.Fatal("2nd connection should be refused")
+	}
+
+	select {
+	case reason := <-rejects:
+		assert.Equal(t, nano.ErrConnLimit, reason)
+	case <-time.After(time.Second):
+		t.Fatal("reject not reported")
+	}
+}
+
+func TestListenerDenyCIDR(t *testing.T) {
+	addr := "tcp://127.0.0.1:3341"
+	sock, rejects := listenWithRejects(t, addr, map[string]interface{}{
+		nano.OptionDenyCIDR: []string{"127.0.0.0/8"},
+	})
+	defer sock.Close()
+
+	d, err := tcp.NewTransport().NewDialer(addr,
+		reqrep.NewReqSocket().GetProtocol())
+	assert.Equal(t, nil, err)
+	if p, err := d.Dial(); err == nil {
+		p.Close()
+		t.Fatal("connection should be denied")
+	}
+
+	select {
+	case reason := <-rejects:
+		assert.Equal(t, nano.ErrAddrDenied, reason)
+	case <-time.After(time.Second):
+		t.Fatal("reject not reported")
+	}
+}
+
+func TestListenerAdmissionBadValue(t *testing.T) {
+	sock := reqrep.NewRepSocket()
+	sock.AddTransport(tcp.NewTransport())
+	defer sock.Close()
+
+	_, err := sock.NewListener("tcp://127.0.0.1:3342", map[string]interface{}{
+		nano.OptionAllowCIDR: "not-a-cidr",
+	})
+	assert.Equal(t, nano.ErrBadValue, err)
+
+	l, err := sock.NewListener("tcp://127.0.0.1:3342", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, l.SetOption(nano.OptionAcceptRate, 10))
+	v, err := l.GetOption(nano.OptionAcceptRate)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 10, v)
+}
+
+func TestListenerAdmissionWhileListening(t *testing.T) {
+	mux := http.NewServeMux()
+	ln, err := net.Listen("tcp", "127.0.0.1:3343")
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+	go http.Serve(ln, mux)
+
+	sock := reqrep.NewRepSocket()
+	defer sock.Close()
+	sock.AddTransport(tcp.NewTransport())
+	sock.AddTransport(websocket.NewTransport())
+	rejects := make(chan error, 10)
+	sock.SetPortHook(func(action nano.PortAction, p nano.Port) bool {
+		if action == nano.PortActionReject {
+			reason, _ := p.GetProp(nano.PropRejectReason)
+			select {
+			case rejects <- reason.(error):
+			default:
+			}
+		}
+		return true
+	})
+
+	// also on the application's HTTP server
+	for addr, opts := range map[string]map[string]interface{}{
+		"tcp://127.0.0.1:3344":     nil,
+		"ws://127.0.0.1:3343/nano": {nano.OptionWebSocketMux: mux},
+	} {
+		l, err := sock.NewListener(addr, opts)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, l.Listen())
+		assert.Equal(t, nil, l.SetOption(nano.OptionDenyCIDR, "127.0.0.0/8"))
+
+		req := reqrep.NewReqSocket()
+		req.AddTransport(tcp.NewTransport())
+		req.AddTransport(websocket.NewTransport())
+		assert.Equal(t, nil, req.Dial(addr))
+		select {
+		case reason := <-rejects:
+			assert.Equal(t, nano.ErrAddrDenied, reason)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: reject not reported", addr)
+		}
+		req.Close()
+	}
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pubsub"
+	"github.com/funkygao/nano/protocol/reqrep"
+	"github.com/funkygao/nano/transport/inproc"
+)
+
+// newEchoRep replies to every request with the request itself, unless
+// drop tells to ignore it.
+func newEchoRep(t *testing.T, addr string, drop func([]byte) bool) nano.Socket {
+	rep := reqrep.NewRepSocket()
+	rep.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, rep.Listen(addr))
+	go func() {
+		for {
+			m, err := rep.RecvMsg()
+			if err != nil {
+				return
+			}
+			if drop != nil && drop(m.Body) {
+				m.Free()
+				continue
+			}
+			if rep.SendMsg(m) != nil {
+				return
+			}
+		}
+	}()
+	return rep
+}
+
+func newReq(t *testing.T, addr string) nano.Socket {
+	req := reqrep.NewReqSocket()
+	req.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, req.SetOption(nano.OptionRecvDeadline, 2*time.Second))
+	assert.Equal(t, nil, req.Dial(addr))
+	return req
+}
+
+func TestReqContexts(t *testing.T) {
+	addr := "inproc://req/contexts"
+	rep := newEchoRep(t, addr, nil)
+	defer rep.Close()
+	req := newReq(t, addr)
+	defer req.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			ctx, err := req.OpenContext()
+			assert.Equal(t, nil, err)
+			defer ctx.Close()
+			for i := 0; i < 20; i++ {
+				body := fmt.Sprintf("%d-%d", g, i)
+				assert.Equal(t, nil, ctx.Send([]byte(body)))
+				reply, err := ctx.Recv()
+				assert.Equal(t, nil, err)
+				assert.Equal(t, body, string(reply))
+			}
+		}(g)
+	}
+
+	// the socket itself still works alongside
+	assert.Equal(t, nil, req.Send([]byte("socket")))
+	reply, err := req.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "socket", string(reply))
+	wg.Wait()
+}
+
+func TestReqContextRetry(t *testing.T) {
+	addr := "inproc://req/context-retry"
+	var lk sync.Mutex
+	seen := make(map[string]bool)
+	rep := newEchoRep(t, addr, func(body []byte) bool {
+		lk.Lock()
+		defer lk.Unlock()
+		first := !seen[string(body)]
+		seen[string(body)] = true
+		return first
+	})
+	defer rep.Close()
+	req := newReq(t, addr)
+	defer req.Close()
+
+	ctx, err := req.OpenContext()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, ctx.SetOption(nano.OptionRetryTime, 50*time.Millisecond))
+	v, err := ctx.GetOption(nano.OptionRetryTime)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 50*time.Millisecond, v)
+	v, err = ctx.GetOption(nano.OptionRecvDeadline)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2*time.Second, v)
+
+	assert.Equal(t, nil, ctx.Send([]byte("again")))
+	reply, err := ctx.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "again", string(reply))
+
+	// a new request abandons the outstanding one
+	assert.Equal(t, nil, ctx.SetOption(nano.OptionRetryTime, time.Hour))
+	assert.Equal(t, nil, ctx.Send([]byte("lost")))
+	assert.Equal(t, nil, ctx.Send([]byte("lost")))
+	reply, err = ctx.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "lost", string(reply))
+}
+
+func TestReqContextErrors(t *testing.T) {
+	req := reqrep.NewReqSocket()
+	ctx, err := req.OpenContext()
+	assert.Equal(t, nil, err)
+	_, err = ctx.Recv()
+	assert.Equal(t, nano.ErrProtoState, err)
+	assert.Equal(t, nano.ErrBadValue, ctx.SetOption(nano.OptionRetryTime, 1))
+	assert.Equal(t, nano.ErrBadOption, ctx.SetOption(nano.OptionRaw, true))
+
+	// no connection to take the request
+	assert.Equal(t, nil, ctx.SetOption(nano.OptionSendDeadline, 50*time.Millisecond))
+	assert.Equal(t, nano.ErrSendTimeout, ctx.Send([]byte("x")))
+	_, err = ctx.Recv()
+	assert.Equal(t, nano.ErrProtoState, err)
+
+	assert.Equal(t, nil, ctx.Close())
+	assert.Equal(t, nano.ErrClosed, ctx.Close())
+	assert.Equal(t, nano.ErrClosed, ctx.Send([]byte("x")))
+
+	assert.Equal(t, nil, req.SetOption(nano.OptionRaw, true))
+	_, err = req.OpenContext()
+	assert.Equal(t, nano.ErrProtoOp, err)
+	req.Close()
+	_, err = req.OpenContext()
+	assert.Equal(t, nano.ErrClosed, err)
+
+	pub := pubsub.NewPubSocket()
+	defer pub.Close()
+	_, err = pub.OpenContext()
+	assert.Equal(t, nano.ErrProtoOp, err)
+}
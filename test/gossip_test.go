@@ -0,0 +1,123 @@
+package test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/gossip"
+	"github.com/funkygao/nano/transport/inproc"
+)
+
+func newGossipSocket(t *testing.T, name string) nano.Socket {
+	sock := gossip.NewSocket()
+	sock.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, sock.SetOption(nano.OptionGossipInterval, 50*time.Millisecond))
+	assert.Equal(t, nil, sock.SetOption(nano.OptionRecvDeadline, 2*time.Second))
+	assert.Equal(t, nil, sock.Listen("inproc://gossip/"+name))
+	return sock
+}
+
+// recvAll receives n messages, and fails on any more.
+func recvAll(t *testing.T, sock nano.Socket, n int) []string {
+	var got []string
+	for i := 0; i < n; i++ {
+		m, err := sock.Recv()
+		assert.Equal(t, nil, err)
+		got = append(got, string(m))
+	}
+	sock.SetOption(nano.OptionRecvDeadline, 200*time.Millisecond)
+	_, err := sock.Recv()
+	assert.Equal(t, nano.ErrRecvTimeout, err)
+	sort.Strings(got)
+	return got
+}
+
+func TestGossipMesh(t *testing.T) {
+	// a ring with chords, where every message reaches most members over
+	// more than one path
+	const n = 8
+	socks := make([]nano.Socket, n)
+	for i := range socks {
+		socks[i] = newGossipSocket(t, fmt.Sprintf("mesh%d", i))
+		assert.Equal(t, nil, socks[i].SetOption(nano.OptionGossipFanout, 2))
+		defer socks[i].Close()
+	}
+	for i := range socks {
+		assert.Equal(t, nil, socks[i].Dial(fmt.Sprintf("inproc://gossip/mesh%d", (i+1)%n)))
+		assert.Equal(t, nil, socks[i].Dial(fmt.Sprintf("inproc://gossip/mesh%d", (i+3)%n)))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for i, sock := range socks {
+		assert.Equal(t, nil, sock.Send([]byte{'a' + byte(i)}))
+	}
+	for i, sock := range socks {
+		var want []string
+		for j := 0; j < n; j++ {
+			if j != i {
+				want = append(want, string([]byte{'a' + byte(j)}))
+			}
+		}
+		assert.Equal(t, want, recvAll(t, sock, n-1))
+	}
+}
+
+func TestGossipLateJoiner(t *testing.T) {
+	a := newGossipSocket(t, "a")
+	defer a.Close()
+	b := newGossipSocket(t, "b")
+	defer b.Close()
+	assert.Equal(t, nil, b.Dial("inproc://gossip/a"))
+	time.Sleep(50 * time.Millisecond)
+
+	for _, s := range []string{"x", "y", "z"} {
+		assert.Equal(t, nil, a.Send([]byte(s)))
+	}
+	assert.Equal(t, []string{"x", "y", "z"}, recvAll(t, b, 3))
+
+	// c was not there when they were sent, anti-entropy with b repairs it
+	c := newGossipSocket(t, "c")
+	defer c.Close()
+	assert.Equal(t, nil, c.Dial("inproc://gossip/b"))
+	assert.Equal(t, []string{"x", "y", "z"}, recvAll(t, c, 3))
+}
+
+func TestGossipRetention(t *testing.T) {
+	a := newGossipSocket(t, "old")
+	defer a.Close()
+	assert.Equal(t, nil, a.SetOption(nano.OptionGossipRetention, 100*time.Millisecond))
+	assert.Equal(t, nil, a.Send([]byte("stale")))
+	time.Sleep(200 * time.Millisecond)
+
+	b := newGossipSocket(t, "new")
+	defer b.Close()
+	assert.Equal(t, nil, b.Dial("inproc://gossip/old"))
+	b.SetOption(nano.OptionRecvDeadline, 300*time.Millisecond)
+	_, err := b.Recv()
+	assert.Equal(t, nano.ErrRecvTimeout, err)
+}
+
+func TestGossipOptions(t *testing.T) {
+	sock := gossip.NewSocket()
+	defer sock.Close()
+	v, err := sock.GetOption(nano.OptionGossipPeers)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 10, v)
+	v, err = sock.GetOption(nano.OptionTtl)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 8, v)
+
+	assert.Equal(t, nano.ErrBadValue, sock.SetOption(nano.OptionTtl, 256))
+	assert.Equal(t, nano.ErrBadValue, sock.SetOption(nano.OptionGossipPeers, 0))
+	assert.Equal(t, nano.ErrBadValue, sock.SetOption(nano.OptionGossipFanout, "3"))
+	assert.Equal(t, nano.ErrBadValue, sock.SetOption(nano.OptionGossipInterval, time.Duration(0)))
+	assert.Equal(t, nano.ErrBadValue, sock.SetOption(nano.OptionGossipRetention, time.Second*-1))
+	assert.Equal(t, nil, sock.SetOption(nano.OptionGossipFanout, 5))
+	v, err = sock.GetOption(nano.OptionGossipFanout)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, v)
+}
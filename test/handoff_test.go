@@ -0,0 +1,108 @@
+package test
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/reqrep"
+	_ "github.com/funkygao/nano/transport/tcp"
+)
+
+const handoffAddr = "tcp://127.0.0.1:3380"
+
+// TestHandoffChild is the new process of TestListenerHandoff.
+func TestHandoffChild(t *testing.T) {
+	if os.Getenv("NANO_HANDOFF_CHILD") == "" {
+		t.Skip("only run by TestListenerHandoff")
+	}
+
+	sock := reqrep.NewRepSocket()
+	defer sock.Close()
+	// the parent still listens, so this only works by adoption
+	if err := sock.Listen(handoffAddr); err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout.WriteString("READY\n")
+
+	sock.SetOption(nano.OptionRecvDeadline, 5*time.Second)
+	if _, err := sock.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	sock.Send([]byte("child"))
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestListenerHandoff(t *testing.T) {
+	parent := reqrep.NewRepSocket()
+	assert.Equal(t, nil, parent.Listen(handoffAddr))
+	files, err := parent.ListenerFiles()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(files))
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandoffChild$")
+	cmd.ExtraFiles = files
+	cmd.Env = append(nano.HandoffEnv(files), "NANO_HANDOFF_CHILD=1")
+	stdout, _ := cmd.StdoutPipe()
+	assert.Equal(t, nil, cmd.Start())
+	for _, f := range files {
+		f.Close()
+	}
+
+	ready := make(chan bool)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if scanner.Text() == "READY" {
+				ready <- true
+			}
+		}
+		close(ready)
+	}()
+	if !<-ready {
+		cmd.Wait()
+		t.Fatal("child failed to listen")
+	}
+
+	// the old process stops accepting, the port stays open
+	parent.Close()
+
+	req := reqrep.NewReqSocket()
+	defer req.Close()
+	assert.Equal(t, nil, req.Dial(handoffAddr))
+	req.SetOption(nano.OptionRecvDeadline, 5*time.Second)
+	assert.Equal(t, nil, req.Send([]byte("who")))
+	b, err := req.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "child", string(b))
+	assert.Equal(t, nil, cmd.Wait())
+}
+
+func TestListenFdOption(t *testing.T) {
+	a := reqrep.NewRepSocket()
+	assert.Equal(t, nil, a.Listen("tcp://127.0.0.1:3381"))
+	files, err := a.ListenerFiles()
+	assert.Equal(t, nil, err)
+	defer files[0].Close()
+
+	b := reqrep.NewRepSocket()
+	defer b.Close()
+	assert.Equal(t, nil, b.ListenOptions("tcp://127.0.0.1:3381",
+		map[string]interface{}{nano.OptionListenFd: files[0]}))
+	a.Close()
+
+	req := reqrep.NewReqSocket()
+	defer req.Close()
+	req.SetOption(nano.OptionRecvDeadline, 5*time.Second)
+	assert.Equal(t, nil, req.Dial("tcp://127.0.0.1:3381"))
+	assert.Equal(t, nil, req.Send([]byte("ping")))
+
+	b.SetOption(nano.OptionRecvDeadline, 5*time.Second)
+	m, err := b.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ping", string(m))
+}
@@ -0,0 +1,201 @@
+package test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// Proxy is a stand-in SOCKS5 or HTTP CONNECT proxy, for testing dialers
+// set up with nano.OptionProxy.
+type Proxy struct {
+	// URL is the value for nano.OptionProxy, credentials included.
+	URL string
+
+	scheme  string
+	user    *url.Userinfo
+	l       net.Listener
+	tunnels int32
+}
+
+// NewProxy starts a proxy on the loopback, scheme is "socks5" or "http".
+// If user is not empty, clients must authenticate with user and pass.
+func NewProxy(t *testing.T, scheme, user, pass string) *Proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Proxy listen failed: %v", err)
+	}
+
+	p := &Proxy{scheme: scheme, l: l}
+	u := &url.URL{Scheme: scheme, Host: l.Addr().String()}
+	if user != "" {
+		p.user = url.UserPassword(user, pass)
+		u.User = p.user
+	}
+	p.URL = u.String()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+// Tunnels returns how many connections went through the proxy.
+func (p *Proxy) Tunnels() int {
+	return int(atomic.LoadInt32(&p.tunnels))
+}
+
+// Close stops accepting, established tunnels stay up.
+func (p *Proxy) Close() {
+	p.l.Close()
+}
+
+func (p *Proxy) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	var target net.Conn
+	if p.scheme == "socks5" {
+		target = p.socks5(conn, r)
+	} else {
+		target = p.connect(conn, r)
+	}
+	if target == nil {
+		conn.Close()
+		return
+	}
+
+	atomic.AddInt32(&p.tunnels, 1)
+	go func() {
+		io.Copy(target, r)
+		target.Close()
+	}()
+	io.Copy(conn, target)
+	conn.Close()
+}
+
+func (p *Proxy) socks5(conn net.Conn, r *bufio.Reader) net.Conn {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || hdr[0] != 5 {
+		return nil
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return nil
+	}
+	want := byte(0)
+	if p.user != nil {
+		want = 2
+	}
+	ok := false
+	for _, m := range methods {
+		ok = ok || m == want
+	}
+	if !ok {
+		conn.Write([]byte{5, 0xff})
+		return nil
+	}
+	conn.Write([]byte{5, want})
+
+	if want == 2 {
+		user, pass := readString(r, 1), ""
+		if user != nil {
+			if b := readString(r, 0); b != nil {
+				pass = string(b)
+			}
+		}
+		want, _ := p.user.Password()
+		if user == nil || string(user) != p.user.Username() || pass != want {
+			conn.Write([]byte{1, 1})
+			return nil
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(r, req[:]); err != nil || req[1] != 1 {
+		return nil
+	}
+	var host string
+	switch req[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if req[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return nil
+		}
+		host = ip.String()
+	case 3:
+		b := readString(r, 0)
+		if b == nil {
+			return nil
+		}
+		host = string(b)
+	default:
+		return nil
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return nil
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host,
+		strconv.Itoa(int(port[0])<<8|int(port[1]))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return target
+}
+
+// readString reads a length prefixed string, after skipping skip bytes.
+func readString(r *bufio.Reader, skip int) []byte {
+	if _, err := r.Discard(skip); err != nil {
+		return nil
+	}
+	n, err := r.ReadByte()
+	if err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil
+	}
+	return b
+}
+
+func (p *Proxy) connect(conn net.Conn, r *bufio.Reader) net.Conn {
+	req, err := http.ReadRequest(r)
+	if err != nil || req.Method != http.MethodConnect {
+		return nil
+	}
+	if p.user != nil {
+		pass, _ := p.user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(p.user.Username() + ":" + pass))
+		if req.Header.Get("Proxy-Authorization") != "Basic "+auth {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return nil
+		}
+	}
+
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return nil
+	}
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return target
+}
@@ -0,0 +1,157 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pubsub"
+	"github.com/funkygao/nano/transport/capture"
+	"github.com/funkygao/nano/transport/inproc"
+)
+
+func newPub(t *testing.T, addr string) nano.Socket {
+	pub := pubsub.NewPubSocket()
+	pub.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, pub.Listen(addr))
+	return pub
+}
+
+func TestSubscribeUpstream(t *testing.T) {
+	addr := "inproc://pubsub/upstream"
+	pub := newPub(t, addr)
+
+	// filtered at the source, with its traffic recorded
+	var wire bytes.Buffer
+	rec, err := capture.NewRecorder(&wire)
+	assert.Equal(t, nil, err)
+	sub := pubsub.NewSubSocket()
+	defer sub.Close()
+	sub.AddTransport(rec.Wrap(inproc.NewTransport()))
+	assert.Equal(t, nil, sub.SetOption(nano.OptionSubscribeUpstream, true))
+	assert.Equal(t, nil, sub.SetOption(nano.OptionSubscribe, "a"))
+	assert.Equal(t, nil, sub.SetOption(nano.OptionRecvDeadline, 2*time.Second))
+	assert.Equal(t, nil, sub.Dial(addr))
+
+	// as before
+	all := pubsub.NewSubSocket()
+	defer all.Close()
+	all.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, all.SetOption(nano.OptionSubscribe, ""))
+	assert.Equal(t, nil, all.SetOption(nano.OptionRecvDeadline, 2*time.Second))
+	assert.Equal(t, nil, all.Dial(addr))
+	time.Sleep(100 * time.Millisecond)
+
+	for _, s := range []string{"b1", "b2", "a1"} {
+		assert.Equal(t, nil, pub.Send([]byte(s)))
+	}
+	for _, s := range []string{"b1", "b2", "a1"} {
+		m, err := all.Recv()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, s, string(m))
+	}
+	m, err := sub.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a1", string(m))
+
+	// subscriptions are sent again to a new connection
+	pub.Close()
+	pub = newPub(t, addr)
+	defer pub.Close()
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, nil, sub.SetOption(nano.OptionSubscribe, "c"))
+	time.Sleep(50 * time.Millisecond)
+	for _, s := range []string{"b3", "c1", "a2"} {
+		assert.Equal(t, nil, pub.Send([]byte(s)))
+	}
+	for _, s := range []string{"c1", "a2"} {
+		m, err := sub.Recv()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, s, string(m))
+	}
+
+	rec.Close()
+	r, err := capture.NewReader(&wire)
+	assert.Equal(t, nil, err)
+	var received []string
+	for {
+		f, err := r.Next()
+		if err != nil {
+			break
+		}
+		if f.Dir == capture.Received {
+			received = append(received, string(f.Body))
+		}
+	}
+	assert.Equal(t, []string{"a1", "c1", "a2"}, received)
+}
+
+func TestSubscribeUpstreamOrder(t *testing.T) {
+	addr := "inproc://pubsub/order"
+	pub := newPub(t, addr)
+	defer pub.Close()
+
+	var wire bytes.Buffer
+	rec, err := capture.NewRecorder(&wire)
+	assert.Equal(t, nil, err)
+	sub := pubsub.NewSubSocket()
+	defer sub.Close()
+	sub.AddTransport(rec.Wrap(inproc.NewTransport()))
+	assert.Equal(t, nil, sub.SetOption(nano.OptionSubscribe, "a"))
+	assert.Equal(t, nil, sub.SetOption(nano.OptionSubscribe, "b"))
+	assert.Equal(t, nil, sub.SetOption(nano.OptionSubscribeUpstream, true))
+	assert.Equal(t, nil, sub.Dial(addr))
+	time.Sleep(100 * time.Millisecond)
+	rec.Close()
+
+	// the PUB is told to filter once it knows all the subscriptions
+	r, err := capture.NewReader(&wire)
+	assert.Equal(t, nil, err)
+	var sent []string
+	for {
+		f, err := r.Next()
+		if err != nil {
+			break
+		}
+		if f.Dir == capture.Sent {
+			sent = append(sent, string(f.Body))
+		}
+	}
+	assert.Equal(t, 3, len(sent))
+	assert.Equal(t, "\x02", sent[len(sent)-1])
+}
+
+func TestSubscribePattern(t *testing.T) {
+	addr := "inproc://pubsub/pattern"
+	pub := newPub(t, addr)
+	defer pub.Close()
+
+	sub := pubsub.NewSubSocket()
+	defer sub.Close()
+	sub.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nano.ErrBadValue, sub.SetOption(nano.OptionSubscribePattern, "orders.*eu"))
+	assert.Equal(t, nil, sub.SetOption(nano.OptionSubscribePattern, "orders.*.eu"))
+	assert.Equal(t, nil, sub.SetOption(nano.OptionSubscribePattern, "metrics.#"))
+	assert.Equal(t, nil, sub.SetOption(nano.OptionSubscribeUpstream, true))
+	assert.Equal(t, nil, sub.SetOption(nano.OptionRecvDeadline, 2*time.Second))
+	assert.Equal(t, nil, sub.Dial(addr))
+	time.Sleep(100 * time.Millisecond)
+
+	for _, s := range []string{"orders.1.us x", "orders.2.eu y", "metricsx", "metrics.cpu 1", "other"} {
+		assert.Equal(t, nil, pub.Send([]byte(s)))
+	}
+	for _, s := range []string{"orders.2.eu y", "metrics.cpu 1"} {
+		m, err := sub.Recv()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, s, string(m))
+	}
+
+	assert.Equal(t, nil, sub.SetOption(nano.OptionUnsubscribePattern, "metrics.#"))
+	assert.Equal(t, nano.ErrBadValue, sub.SetOption(nano.OptionUnsubscribePattern, "metrics.#"))
+	assert.Equal(t, nil, pub.Send([]byte("metrics.mem 2")))
+	sub.SetOption(nano.OptionRecvDeadline, 200*time.Millisecond)
+	_, err := sub.Recv()
+	assert.Equal(t, nano.ErrRecvTimeout, err)
+}
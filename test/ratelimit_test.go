@@ -0,0 +1,83 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pipeline"
+	"github.com/funkygao/nano/transport/inproc"
+)
+
+func TestSocketSendRate(t *testing.T) {
+	addr := "inproc://ratelimit"
+	pull := pipeline.NewPullSocket()
+	pull.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, pull.Listen(addr))
+	defer pull.Close()
+
+	push := pipeline.NewPushSocket()
+	push.AddTransport(inproc.NewTransport())
+	rate := nano.Rate{MsgsPerSec: 20}
+	assert.Equal(t, nil, push.SetOption(nano.OptionSendRate, rate))
+	v, err := push.GetOption(nano.OptionSendRate)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, rate, v)
+	assert.Equal(t, nil, push.Dial(addr))
+	defer push.Close()
+
+	const n = 30
+	t0 := time.Now()
+	for i := 0; i < n; i++ {
+		assert.Equal(t, nil, push.Send([]byte("hello")))
+	}
+	assert.Equal(t, nil, pull.SetOption(nano.OptionRecvDeadline, 5*time.Second))
+	for i := 0; i < n; i++ {
+		_, err := pull.Recv()
+		assert.Equal(t, nil, err)
+	}
+
+	// 20 pass as the initial burst, the remaining 10 take half a second
+	if elapsed := time.Since(t0); elapsed < 400*time.Millisecond {
+		t.Fatalf("rate not enforced: %d msgs in %s", n, elapsed)
+	}
+}
+
+func TestRateRefundOnClose(t *testing.T) {
+	addr := "inproc://ratelimit/refund"
+	push := pipeline.NewPushSocket()
+	push.AddTransport(inproc.NewTransport())
+	defer push.Close()
+	assert.Equal(t, nil, push.SetOption(nano.OptionSendRate, nano.Rate{BytesPerSec: 1000}))
+	assert.Equal(t, nil, push.Listen(addr))
+
+	pull := pipeline.NewPullSocket()
+	pull.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, pull.Dial(addr))
+	time.Sleep(50 * time.Millisecond)
+
+	// ten seconds worth of bytes, and the connection goes away meanwhile
+	assert.Equal(t, nil, push.Send(make([]byte, 10000)))
+	time.Sleep(100 * time.Millisecond)
+	pull.Close()
+
+	// the next connection does not pay for them
+	pull = pipeline.NewPullSocket()
+	pull.AddTransport(inproc.NewTransport())
+	defer pull.Close()
+	assert.Equal(t, nil, pull.Dial(addr))
+	assert.Equal(t, nil, pull.SetOption(nano.OptionRecvDeadline, 2*time.Second))
+	assert.Equal(t, nil, push.Send([]byte("hello")))
+	m, err := pull.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", string(m))
+}
+
+func TestRateBadValue(t *testing.T) {
+	push := pipeline.NewPushSocket()
+	defer push.Close()
+	assert.Equal(t, nano.ErrBadValue, push.SetOption(nano.OptionConnSendRate, 100))
+	assert.Equal(t, nano.ErrBadValue,
+		push.SetOption(nano.OptionConnRecvRate, nano.Rate{BytesPerSec: -1}))
+}
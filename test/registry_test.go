@@ -0,0 +1,82 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pair"
+	_ "github.com/funkygao/nano/transport/inproc"
+	_ "github.com/funkygao/nano/transport/tcp"
+)
+
+func TestRegisteredTransports(t *testing.T) {
+	schemes := nano.RegisteredTransports()
+	assert.Equal(t, true, len(schemes) >= 2)
+
+	// no AddTransport needed
+	srv := pair.NewSocket()
+	defer srv.Close()
+	cli := pair.NewSocket()
+	defer cli.Close()
+	assert.Equal(t, nil, srv.Listen("inproc://registry"))
+	assert.Equal(t, nil, cli.Dial("inproc://registry"))
+
+	cli.SetOption(nano.OptionSendDeadline, time.Second)
+	srv.SetOption(nano.OptionRecvDeadline, time.Second)
+	assert.Equal(t, nil, cli.Send([]byte("hi")))
+	b, err := srv.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hi", string(b))
+
+	defer func() {
+		assert.Equal(t, true, recover() != nil)
+	}()
+	nano.RegisterTransport(tcpImpostor{})
+}
+
+type tcpImpostor struct {
+	nano.Transport
+}
+
+func (tcpImpostor) Scheme() string {
+	return "tcp"
+}
+
+func TestAddressOptions(t *testing.T) {
+	sock := pair.NewSocket()
+	defer sock.Close()
+
+	d, err := sock.NewDialer("tcp://127.0.0.1:3370?snappy=1&nodelay=0&maxrecv=8m", nil)
+	assert.Equal(t, nil, err)
+	v, err := d.GetOption(nano.OptionMaxRecvSize)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 8<<20, v)
+	v, _ = d.GetOption(nano.OptionNoDelay)
+	assert.Equal(t, false, v)
+	v, _ = d.GetOption(nano.OptionSnappy)
+	assert.Equal(t, true, v)
+	assert.Equal(t, "tcp://127.0.0.1:3370?snappy=1&nodelay=0&maxrecv=8m", d.Address())
+
+	// explicit options win
+	d, err = sock.NewDialer("tcp://127.0.0.1:3370?nodelay=0", map[string]interface{}{
+		nano.OptionNoDelay: true,
+	})
+	assert.Equal(t, nil, err)
+	v, _ = d.GetOption(nano.OptionNoDelay)
+	assert.Equal(t, true, v)
+
+	l, err := sock.NewListener("tcp://127.0.0.1:3371?maxconns=2&deny=10.0.0.0/8,192.168.0.0/16", nil)
+	assert.Equal(t, nil, err)
+	v, _ = l.GetOption(nano.OptionMaxConnections)
+	assert.Equal(t, 2, v)
+	v, _ = l.GetOption(nano.OptionDenyCIDR)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, v)
+	l.Close()
+
+	_, err = sock.NewDialer("tcp://127.0.0.1:3370?snapy=1", nil)
+	assert.Equal(t, nano.ErrBadOption, err)
+	_, err = sock.NewDialer("tcp://127.0.0.1:3370?maxrecv=lots", nil)
+	assert.Equal(t, nano.ErrBadValue, err)
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pubsub"
+	"github.com/funkygao/nano/protocol/reqrep"
+	"github.com/funkygao/nano/transport/inproc"
+)
+
+func TestRepRequests(t *testing.T) {
+	addr := "inproc://rep/requests"
+	rep := reqrep.NewRepSocket()
+	defer rep.Close()
+	rep.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, rep.Listen(addr))
+
+	// the first requests are replied to last
+	const n = 20
+	var pending sync.WaitGroup
+	pending.Add(n)
+	go func() {
+		for i := 0; i < n; i++ {
+			req, err := reqrep.RecvRequest(rep)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, 0, len(req.Msg.Header))
+			go func(i int) {
+				pending.Done()
+				pending.Wait()
+				time.Sleep(time.Duration(n-i) * 5 * time.Millisecond)
+				assert.Equal(t, nil, req.Reply(append([]byte("re:"), req.Msg.Body...)))
+				req.Msg.Free()
+				assert.Equal(t, nano.ErrProtoState, req.Reply(nil))
+			}(i)
+		}
+	}()
+
+	req := newReq(t, addr)
+	defer req.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, err := req.OpenContext()
+			assert.Equal(t, nil, err)
+			defer ctx.Close()
+			body := fmt.Sprint(i)
+			assert.Equal(t, nil, ctx.Send([]byte(body)))
+			reply, err := ctx.Recv()
+			assert.Equal(t, nil, err)
+			assert.Equal(t, "re:"+body, string(reply))
+		}(i)
+	}
+	wg.Wait()
+
+	// in turn, as before
+	go func() {
+		m, err := rep.RecvMsg()
+		if err == nil {
+			assert.Equal(t, 0, len(m.Header)) // the backtrace is kept aside
+			rep.SendMsg(m)
+		}
+	}()
+	assert.Equal(t, nil, req.Send([]byte("echo")))
+	reply, err := req.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "echo", string(reply))
+}
+
+func TestRepRequestErrors(t *testing.T) {
+	pub := pubsub.NewPubSocket()
+	defer pub.Close()
+	_, err := reqrep.RecvRequest(pub)
+	assert.Equal(t, nano.ErrProtoOp, err)
+
+	rep := reqrep.NewRepSocket()
+	assert.Equal(t, nil, rep.SetOption(nano.OptionRecvDeadline, 10*time.Millisecond))
+	_, err = reqrep.RecvRequest(rep)
+	assert.Equal(t, nano.ErrRecvTimeout, err)
+	rep.Close()
+	_, err = reqrep.RecvRequest(rep)
+	assert.Equal(t, nano.ErrClosed, err)
+}
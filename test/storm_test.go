@@ -0,0 +1,164 @@
+package test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/bus"
+	"github.com/funkygao/nano/protocol/star"
+	"github.com/funkygao/nano/transport/inproc"
+	"github.com/funkygao/nano/transport/tcp"
+)
+
+func TestStormGuard(t *testing.T) {
+	g, g2 := nano.NewStormGuard(), nano.NewStormGuard()
+	h := g.Stamp(3)
+	assert.Equal(t, false, g.Admit(h)) // its own, back around a cycle
+	assert.Equal(t, true, g2.Admit(h))
+	assert.Equal(t, false, g2.Admit(h))
+	assert.Equal(t, nano.StormStats{Duplicates: 1}, g2.Stats())
+
+	assert.Equal(t, true, g2.Forward(h))
+	assert.Equal(t, true, g2.Forward(h))
+	assert.Equal(t, false, g2.Forward(h))
+	assert.Equal(t, nano.StormStats{Duplicates: 1, Expired: 1}, g2.Stats())
+
+	// out of order within the window, too old out of it
+	assert.Equal(t, nano.ErrBadValue, g2.SetWindow(0))
+	assert.Equal(t, nil, g2.SetWindow(50))
+	assert.Equal(t, 64, g2.Window())
+	var hs [][]byte
+	for i := 0; i < 100; i++ {
+		hs = append(hs, g.Stamp(1))
+	}
+	assert.Equal(t, true, g2.Admit(hs[99]))
+	assert.Equal(t, true, g2.Admit(hs[40]))
+	assert.Equal(t, false, g2.Admit(hs[40]))
+	assert.Equal(t, false, g2.Admit(hs[30]))
+	assert.Equal(t, true, g2.Admit(hs[98]))
+}
+
+func newStormSocket(t *testing.T, sock nano.Socket, addr string) nano.Socket {
+	sock.AddTransport(inproc.NewTransport())
+	if addr != "" {
+		assert.Equal(t, nil, sock.Listen(addr))
+	}
+	return sock
+}
+
+// recvOnly receives the messages of a socket until it times out.
+func recvOnly(t *testing.T, sock nano.Socket) []string {
+	assert.Equal(t, nil, sock.SetOption(nano.OptionRecvDeadline, 300*time.Millisecond))
+	var got []string
+	for {
+		m, err := sock.Recv()
+		if err != nil {
+			assert.Equal(t, nano.ErrRecvTimeout, err)
+			return got
+		}
+		got = append(got, string(m))
+	}
+}
+
+func TestStarCycle(t *testing.T) {
+	var nodes []nano.Socket
+	for _, name := range []string{"a", "b", "c"} {
+		nodes = append(nodes, newStormSocket(t, star.NewSocket(), "inproc://star/cycle/"+name))
+		defer nodes[len(nodes)-1].Close()
+	}
+	// a triangle
+	assert.Equal(t, nil, nodes[0].Dial("inproc://star/cycle/b"))
+	assert.Equal(t, nil, nodes[1].Dial("inproc://star/cycle/c"))
+	assert.Equal(t, nil, nodes[2].Dial("inproc://star/cycle/a"))
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, nil, nodes[0].Send([]byte("hello")))
+	assert.Equal(t, []string{"hello"}, recvOnly(t, nodes[1]))
+	assert.Equal(t, []string{"hello"}, recvOnly(t, nodes[2]))
+	assert.Equal(t, 0, len(recvOnly(t, nodes[0])))
+
+	dups := 0
+	for _, n := range nodes {
+		v, err := n.GetOption(nano.OptionStormStats)
+		assert.Equal(t, nil, err)
+		dups += v.(nano.StormStats).Duplicates
+	}
+	if dups == 0 {
+		t.Fatal("no duplicates dropped")
+	}
+}
+
+func TestBusDeviceCycle(t *testing.T) {
+	newBus := func(addr string) nano.Socket {
+		sock := newStormSocket(t, bus.NewSocket(), addr)
+		assert.Equal(t, nil, sock.SetOption(nano.OptionAntiReplay, true))
+		return sock
+	}
+
+	// two devices, linked twice
+	d1 := newBus("inproc://bus/cycle/d1")
+	defer d1.Close()
+	d2 := newBus("inproc://bus/cycle/d2")
+	defer d2.Close()
+	assert.Equal(t, nil, nano.Device(d1, d1))
+	assert.Equal(t, nil, nano.Device(d2, d2))
+	assert.Equal(t, nil, d1.Dial("inproc://bus/cycle/d2"))
+	assert.Equal(t, nil, d2.Dial("inproc://bus/cycle/d1"))
+
+	x := newBus("")
+	defer x.Close()
+	assert.Equal(t, nil, x.Dial("inproc://bus/cycle/d1"))
+	y := newBus("")
+	defer y.Close()
+	assert.Equal(t, nil, y.Dial("inproc://bus/cycle/d2"))
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, nil, x.Send([]byte("hello")))
+	assert.Equal(t, []string{"hello"}, recvOnly(t, y))
+	assert.Equal(t, 0, len(recvOnly(t, x)))
+	v, err := d2.GetOption(nano.OptionStormStats)
+	assert.Equal(t, nil, err)
+	if v.(nano.StormStats).Duplicates == 0 {
+		t.Fatal("no duplicates dropped")
+	}
+
+	// out of hops at the first device
+	assert.Equal(t, nil, x.SetOption(nano.OptionTtl, 1))
+	assert.Equal(t, nil, x.Send([]byte("near")))
+	assert.Equal(t, 0, len(recvOnly(t, y)))
+	v, err = d1.GetOption(nano.OptionStormStats)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, v.(nano.StormStats).Expired)
+
+	assert.Equal(t, nano.ErrBadValue, x.SetOption(nano.OptionTtl, 0))
+	assert.Equal(t, nano.ErrBadValue, x.SetOption(nano.OptionAntiReplay, 1))
+}
+
+func TestStarRejectsFirstVersion(t *testing.T) {
+	sock := star.NewSocket()
+	defer sock.Close()
+	sock.AddTransport(tcp.NewTransport())
+	assert.Equal(t, nil, sock.Listen("tcp://127.0.0.1:3400"))
+
+	conn, err := net.Dial("tcp", "127.0.0.1:3400")
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+
+	// a STAR peer without storm headers
+	hdr := []byte{0, 'S', 'P', 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(hdr[4:], 100*16)
+	_, err = conn.Write(hdr)
+	assert.Equal(t, nil, err)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.ReadFull(conn, hdr)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nano.ProtoStar, binary.BigEndian.Uint16(hdr[4:]))
+	_, err = conn.Read(hdr)
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,221 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pubsub"
+	"github.com/funkygao/nano/protocol/survey"
+	"github.com/funkygao/nano/transport/inproc"
+)
+
+// newRespondents starts n respondents that answer "name:question", but
+// the silent ones, which never answer.
+func newRespondents(t *testing.T, addr string, n, silent int) []nano.Socket {
+	var socks []nano.Socket
+	for i := 0; i < n; i++ {
+		sock := survey.NewRespondentSocket()
+		sock.AddTransport(inproc.NewTransport())
+		assert.Equal(t, nil, sock.Dial(addr))
+		socks = append(socks, sock)
+		if i < silent {
+			continue
+		}
+		go func(name string) {
+			for {
+				q, err := sock.Recv()
+				if err != nil {
+					return
+				}
+				if sock.Send([]byte(name+":"+string(q))) != nil {
+					return
+				}
+			}
+		}(fmt.Sprint("r", i))
+	}
+	return socks
+}
+
+func newSurveyor(t *testing.T, addr string, n, silent int) (nano.Socket, func()) {
+	sock := survey.NewSurveyorSocket()
+	sock.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, sock.Listen(addr))
+	respondents := newRespondents(t, addr, n, silent)
+	time.Sleep(100 * time.Millisecond)
+	return sock, func() {
+		for _, r := range respondents {
+			r.Close()
+		}
+		sock.Close()
+	}
+}
+
+func bodies(rs []survey.Response) []string {
+	var all []string
+	for _, r := range rs {
+		all = append(all, string(r.Msg.Body))
+		r.Msg.Free()
+	}
+	sort.Strings(all)
+	return all
+}
+
+func TestSurveyStreams(t *testing.T) {
+	sock, done := newSurveyor(t, "inproc://survey/streams", 3, 0)
+	defer done()
+	assert.Equal(t, nil, sock.SetOption(nano.OptionSurveyTime, 300*time.Millisecond))
+
+	// overlapping, with their own deadlines
+	a, err := survey.Survey(context.Background(), sock, []byte("a"))
+	assert.Equal(t, nil, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	b, err := survey.Survey(ctx, sock, []byte("b"))
+	assert.Equal(t, nil, err)
+
+	var rs []survey.Response
+	for r := range b {
+		assert.Equal(t, true, r.Port != nil)
+		rs = append(rs, r)
+	}
+	assert.Equal(t, []string{"r0:b", "r1:b", "r2:b"}, bodies(rs))
+	rs = nil
+	for r := range a {
+		rs = append(rs, r)
+	}
+	assert.Equal(t, []string{"r0:a", "r1:a", "r2:a"}, bodies(rs))
+
+	// ended by its context
+	ctx, cancel = context.WithCancel(context.Background())
+	c, err := survey.Survey(ctx, sock, []byte("c"))
+	assert.Equal(t, nil, err)
+	cancel()
+	for r := range c {
+		r.Msg.Free()
+	}
+
+	// the socket still runs its own survey, until the receive deadline
+	assert.Equal(t, nil, sock.SetOption(nano.OptionRecvDeadline, 500*time.Millisecond))
+	assert.Equal(t, nil, sock.Send([]byte("d")))
+	var all []string
+	for {
+		m, err := sock.Recv()
+		if err != nil {
+			break
+		}
+		all = append(all, string(m))
+	}
+	sort.Strings(all)
+	assert.Equal(t, []string{"r0:d", "r1:d", "r2:d"}, all)
+}
+
+func TestSurveyQuorum(t *testing.T) {
+	sock, done := newSurveyor(t, "inproc://survey/quorum", 10, 1)
+	defer done()
+	assert.Equal(t, nil, sock.SetOption(nano.OptionSurveyTime, time.Duration(0)))
+
+	// the silent respondent is not waited for
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	t0 := time.Now()
+	rs, err := survey.Quorum(ctx, sock, []byte("q"), 0.9)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 9, len(bodies(rs)))
+	rs, err = survey.Collect(ctx, sock, []byte("c"), 3)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(bodies(rs)))
+	if time.Since(t0) > 2*time.Second {
+		t.Fatal(time.Since(t0))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	rs, err = survey.Quorum(ctx, sock, []byte("all"), 1)
+	assert.Equal(t, nano.ErrNoQuorum, err)
+	assert.Equal(t, 9, len(bodies(rs)))
+}
+
+func TestSurveyQuorumStalled(t *testing.T) {
+	sock, done := newSurveyor(t, "inproc://survey/stalled", 3, 1)
+	defer done()
+	assert.Equal(t, nil, sock.SetOption(nano.OptionSurveyTime, time.Duration(0)))
+
+	// the silent respondent falls too far behind to be sent the survey
+	for i := 0; i < 500; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err := survey.Survey(ctx, sock, []byte("fill"))
+		assert.Equal(t, nil, err)
+		cancel()
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	rs, err := survey.Quorum(ctx, sock, []byte("q"), 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(bodies(rs)))
+}
+
+func TestSurveyErrors(t *testing.T) {
+	pub := pubsub.NewPubSocket()
+	defer pub.Close()
+	_, err := survey.Survey(context.Background(), pub, nil)
+	assert.Equal(t, nano.ErrProtoOp, err)
+
+	sock := survey.NewSurveyorSocket()
+	_, err = survey.Quorum(context.Background(), sock, nil, 1.5)
+	assert.Equal(t, nano.ErrBadValue, err)
+	_, err = survey.Collect(context.Background(), sock, nil, 0)
+	assert.Equal(t, nano.ErrBadValue, err)
+	_, err = survey.Quorum(context.Background(), sock, nil, 0.5)
+	assert.Equal(t, nano.ErrNoQuorum, err)
+
+	// it would never end
+	assert.Equal(t, nil, sock.SetOption(nano.OptionSurveyTime, time.Duration(0)))
+	_, err = survey.Survey(context.Background(), sock, nil)
+	assert.Equal(t, nano.ErrBadValue, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = survey.Survey(ctx, sock, nil)
+	assert.Equal(t, context.Canceled, err)
+
+	sock.Close()
+	_, err = survey.Survey(context.Background(), sock, nil)
+	assert.Equal(t, nano.ErrClosed, err)
+}
+
+func TestSurveyExpiry(t *testing.T) {
+	addr := "inproc://survey/expiry"
+	sock := survey.NewSurveyorSocket()
+	defer sock.Close()
+	sock.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, sock.SetOption(nano.OptionSurveyTime, 100*time.Millisecond))
+	assert.Equal(t, nil, sock.Listen(addr))
+
+	resp := survey.NewRespondentSocket()
+	defer resp.Close()
+	resp.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, resp.Dial(addr))
+	go func() {
+		if _, err := resp.Recv(); err == nil {
+			time.Sleep(300 * time.Millisecond)
+			resp.Send([]byte("late"))
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the Recv waiting when the survey expires ignores the late response
+	assert.Equal(t, nil, sock.Send([]byte("who")))
+	assert.Equal(t, nil, sock.SetOption(nano.OptionRecvDeadline, time.Second))
+	_, err := sock.Recv()
+	assert.Equal(t, nano.ErrRecvTimeout, err)
+	_, err = sock.Recv()
+	assert.Equal(t, nano.ErrProtoState, err)
+}
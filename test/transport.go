@@ -0,0 +1,269 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/reqrep"
+)
+
+// TranTest provides a common test suite for transports, used by the
+// transport packages' own tests.
+type TranTest struct {
+	addr     string
+	tran     nano.Transport
+	protoReq nano.Protocol
+	protoRep nano.Protocol
+	cliCfg   *tls.Config
+	srvCfg   *tls.Config
+	dopts    map[string]interface{}
+}
+
+// NewTranTest creates a TranTest for tran listening on addr.
+func NewTranTest(tran nano.Transport, addr string) *TranTest {
+	tt := &TranTest{
+		addr:     addr,
+		tran:     tran,
+		protoReq: reqrep.NewReqSocket().GetProtocol(),
+		protoRep: reqrep.NewRepSocket().GetProtocol(),
+	}
+	if tran.Scheme() == "tls+tcp" || tran.Scheme() == "wss" {
+		tt.srvCfg, tt.cliCfg = tlsConfigs()
+	}
+	return tt
+}
+
+// tlsConfigs returns a server config with a throwaway self-signed
+// certificate, and a client config that trusts it.
+func tlsConfigs() (srv, cli *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"nano test"}},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+
+	srv = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}}}
+	cli = &tls.Config{InsecureSkipVerify: true}
+	return
+}
+
+// WithDialerOption sets an option on the dialers of the tests.
+func (tt *TranTest) WithDialerOption(name string, v interface{}) *TranTest {
+	if tt.dopts == nil {
+		tt.dopts = make(map[string]interface{})
+	}
+	tt.dopts[name] = v
+	return tt
+}
+
+func (tt *TranTest) newListener(t *testing.T) nano.PipeListener {
+	l, err := tt.tran.NewListener(tt.addr, tt.protoRep)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	if tt.srvCfg != nil {
+		if err = l.SetOption(nano.OptionTlsConfig, tt.srvCfg); err != nil {
+			t.Fatalf("Failed setting TLS config: %v", err)
+		}
+	}
+	return l
+}
+
+func (tt *TranTest) newDialer(t *testing.T) nano.PipeDialer {
+	d, err := tt.tran.NewDialer(tt.addr, tt.protoReq)
+	if err != nil {
+		t.Fatalf("NewDialer failed: %v", err)
+	}
+	if tt.cliCfg != nil {
+		if err = d.SetOption(nano.OptionTlsConfig, tt.cliCfg); err != nil {
+			t.Fatalf("Failed setting TLS config: %v", err)
+		}
+	}
+	for name, v := range tt.dopts {
+		if err = d.SetOption(name, v); err != nil {
+			t.Fatalf("Failed setting %s: %v", name, err)
+		}
+	}
+	return d
+}
+
+// TranTestListenAndAccept tests that we can dial and accept.
+func (tt *TranTest) TranTestListenAndAccept(t *testing.T) {
+	l := tt.newListener(t)
+	defer l.Close()
+	if err := l.Listen(); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client, err := tt.newDialer(t).Dial()
+		if err != nil {
+			t.Errorf("Dial failed: %v", err)
+			return
+		}
+		defer client.Close()
+		if !client.IsOpen() {
+			t.Error("Client is closed")
+		}
+		if client.RemoteProtocol() != tt.protoRep.Number() {
+			t.Errorf("Client peer protocol: %d", client.RemoteProtocol())
+		}
+	}()
+
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer server.Close()
+	if server.RemoteProtocol() != tt.protoReq.Number() {
+		t.Errorf("Server peer protocol: %d", server.RemoteProtocol())
+	}
+	<-done
+}
+
+// TranTestDuplicateListen checks that a second listen on the same
+// address fails.
+func (tt *TranTest) TranTestDuplicateListen(t *testing.T) {
+	l1 := tt.newListener(t)
+	defer l1.Close()
+	if err := l1.Listen(); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	l2 := tt.newListener(t)
+	defer l2.Close()
+	if err := l2.Listen(); err == nil {
+		t.Fatal("Duplicate listen should not be permitted!")
+	}
+}
+
+// TranTestConnRefused checks that dialing with no listener fails.
+func (tt *TranTest) TranTestConnRefused(t *testing.T) {
+	c, err := tt.newDialer(t).Dial()
+	if err == nil || c != nil {
+		t.Fatalf("Connection not refused (%s)!", tt.addr)
+	}
+}
+
+// TranTestSendRecv sends a request and a reply across a pipe.
+func (tt *TranTest) TranTestSendRecv(t *testing.T) {
+	ping := []byte("REQUEST_MESSAGE")
+	ack := []byte("RESPONSE_MESSAGE")
+
+	l := tt.newListener(t)
+	defer l.Close()
+	if err := l.Listen(); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		client, err := tt.newDialer(t).Dial()
+		if err != nil {
+			t.Errorf("Dial failed: %v", err)
+			return
+		}
+		defer client.Close()
+
+		req := nano.NewMessage(len(ping))
+		req.Body = append(req.Body, ping...)
+		if err = client.SendMsg(req); err != nil {
+			t.Errorf("Client send error: %v", err)
+			return
+		}
+		if err = client.Flush(); err != nil {
+			t.Errorf("Client flush error: %v", err)
+			return
+		}
+
+		rep, err := client.RecvMsg()
+		if err != nil {
+			t.Errorf("Client receive error: %v", err)
+			return
+		}
+		if !bytes.Equal(rep.Body, ack) {
+			t.Errorf("Reply mismatch: %v, %v", rep.Body, ack)
+		}
+		if len(rep.Header) != 0 {
+			t.Errorf("Client reply non-empty header: %v", rep.Header)
+		}
+		rep.Free()
+	}()
+
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer server.Close()
+
+	req, err := server.RecvMsg()
+	if err != nil {
+		t.Fatalf("Server receive error: %v", err)
+	}
+	if !bytes.Equal(req.Body, ping) {
+		t.Errorf("Request mismatch: %v, %v", req.Body, ping)
+	}
+	if len(req.Header) != 0 {
+		t.Errorf("Server request non-empty header: %v", req.Header)
+	}
+	req.Free()
+
+	rep := nano.NewMessage(len(ack))
+	rep.Body = append(rep.Body, ack...)
+	if err = server.SendMsg(rep); err != nil {
+		t.Fatalf("Server send error: %v", err)
+	}
+	if err = server.Flush(); err != nil {
+		t.Fatalf("Server flush error: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client timeout")
+	}
+}
+
+// TranTestScheme checks the transport scheme matches the address.
+func (tt *TranTest) TranTestScheme(t *testing.T) {
+	if _, err := nano.StripScheme(tt.tran, tt.addr); err != nil {
+		t.Errorf("Scheme %s mismatch for %s", tt.tran.Scheme(), tt.addr)
+	}
+}
+
+// TranTestAll runs all the tests.
+func (tt *TranTest) TranTestAll(t *testing.T) {
+	tt.TranTestListenAndAccept(t)
+	tt.TranTestDuplicateListen(t)
+	tt.TranTestConnRefused(t)
+	tt.TranTestSendRecv(t)
+	tt.TranTestScheme(t)
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/xpubsub"
+	"github.com/funkygao/nano/transport/inproc"
+)
+
+func newXPub(t *testing.T, dir, addr string) nano.Socket {
+	pub := xpubsub.MakeXPubSocket()
+	pub.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, pub.SetOption(nano.OptionLogDir, dir))
+	assert.Equal(t, nil, pub.Listen(addr))
+	return pub
+}
+
+func newXSub(t *testing.T, addr string, sub interface{}) nano.Socket {
+	s := xpubsub.NewXSubSocket()
+	s.AddTransport(inproc.NewTransport())
+	assert.Equal(t, nil, s.SetOption(nano.OptionRecvDeadline, 2*time.Second))
+	assert.Equal(t, nil, s.SetOption(nano.OptionSubscribe, sub))
+	assert.Equal(t, nil, s.Dial(addr))
+	return s
+}
+
+func publish(t *testing.T, pub nano.Socket, topic string, payloads ...string) {
+	for _, p := range payloads {
+		assert.Equal(t, nil, pub.Send(xpubsub.Encode(topic, []byte(p))))
+	}
+}
+
+// expect receives messages of a topic, and returns the last sequence
+// number.
+func expect(t *testing.T, sub nano.Socket, topic string, payloads ...string) uint64 {
+	var seq uint64
+	for _, want := range payloads {
+		m, err := sub.RecvMsg()
+		assert.Equal(t, nil, err)
+		if err != nil {
+			t.FailNow()
+		}
+		tp, payload, err := xpubsub.Decode(m.Body)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, topic, tp)
+		assert.Equal(t, want, string(payload))
+		seq = xpubsub.Seq(m)
+		m.Free()
+	}
+	return seq
+}
+
+func TestXPubReplay(t *testing.T) {
+	addr := "inproc://xpub/replay"
+	pub := newXPub(t, t.TempDir(), addr)
+	defer pub.Close()
+	publish(t, pub, "orders", "1", "2")
+	publish(t, pub, "quotes", "q")
+
+	// the whole log, then what comes next
+	all := newXSub(t, addr, xpubsub.Subscription{Topic: "orders", From: 1})
+	defer all.Close()
+	// only what comes next
+	now := newXSub(t, addr, "orders")
+	defer now.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	publish(t, pub, "orders", "3")
+	assert.Equal(t, uint64(3), expect(t, all, "orders", "1", "2", "3"))
+	assert.Equal(t, uint64(3), expect(t, now, "orders", "3"))
+
+	assert.Equal(t, nano.ErrProtoOp, all.Send([]byte("x")))
+	_, err := pub.Recv()
+	assert.Equal(t, nano.ErrProtoOp, err)
+}
+
+func TestXPubResume(t *testing.T) {
+	addr := "inproc://xpub/resume"
+	dir := t.TempDir()
+	pub := newXPub(t, dir, addr)
+	sub := newXSub(t, addr, xpubsub.Subscription{Topic: "t", From: 1})
+	defer sub.Close()
+
+	publish(t, pub, "t", "a", "b", "c")
+	expect(t, sub, "t", "a", "b", "c")
+	assert.Equal(t, nil, xpubsub.Ack(sub, "t", 2))
+
+	// the publisher restarts, with what was published meanwhile in its log
+	pub.Close()
+	pub = newXPub(t, dir, addr)
+	defer pub.Close()
+	publish(t, pub, "t", "d")
+	// c is not delivered twice
+	assert.Equal(t, uint64(4), expect(t, sub, "t", "d"))
+	assert.Equal(t, nil, xpubsub.Ack(sub, "t", 4))
+	offset, err := xpubsub.Offset(sub, "t")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(5), offset)
+
+	// the subscriber restarts, from its saved offset
+	sub.Close()
+	publish(t, pub, "t", "e")
+	sub2 := newXSub(t, addr, xpubsub.Subscription{Topic: "t", From: offset})
+	defer sub2.Close()
+	assert.Equal(t, uint64(5), expect(t, sub2, "t", "e"))
+}
+
+func TestXPubErrors(t *testing.T) {
+	pub := xpubsub.MakeXPubSocket()
+	defer pub.Close()
+	assert.Equal(t, nano.ErrNoLogDir, pub.Send(xpubsub.Encode("t", nil)))
+	assert.Equal(t, nil, pub.SetOption(nano.OptionLogDir, t.TempDir()))
+	assert.Equal(t, nano.ErrProtoState, pub.SetOption(nano.OptionLogDir, t.TempDir()))
+	assert.Equal(t, nano.ErrNoTopic, pub.Send([]byte("no topic")))
+	assert.Equal(t, nil, pub.Send(xpubsub.Encode("t", nil)))
+	assert.Equal(t, nano.ErrBadValue, pub.SetOption(nano.OptionLogRetentionTime, 7))
+
+	sub := xpubsub.NewXSubSocket()
+	defer sub.Close()
+	assert.Equal(t, nano.ErrBadValue, xpubsub.Ack(sub, "t", 1))
+	assert.Equal(t, nano.ErrBadValue, sub.SetOption(nano.OptionUnsubscribe, "t"))
+	assert.Equal(t, nano.ErrProtoOp, xpubsub.Ack(pub, "t", 1))
+}
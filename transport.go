@@ -1,5 +1,10 @@
 package nano
 
+import (
+	"net"
+	"os"
+)
+
 // Pipe behaves like a full-duplex message-oriented connection between two
 // peers.  Callers may call operations on a Pipe simultaneously from
 // different goroutines.  (These are different from net.Conn because they
@@ -72,6 +77,15 @@ type PipeDialer interface {
 	GetOption(name string) (value interface{}, err error)
 }
 
+// ClosablePipeDialer is an optional interface that a PipeDialer can
+// implement to learn that it is of no more use, e.g. to abort a slow dial.
+type ClosablePipeDialer interface {
+
+	// Close aborts the dial in progress, if any.  It is called once
+	// the dialer or its socket is closed.
+	Close() error
+}
+
 // Dialer is an interface to the underlying dialer for a transport
 // and address.
 type Dialer interface {
@@ -129,6 +143,53 @@ type PipeListener interface {
 	GetOption(name string) (value interface{}, err error)
 }
 
+// ConnGate decides whether an inbound connection may proceed to the SP
+// handshake.  The core supplies one to transports that support listener
+// admission control.
+type ConnGate interface {
+
+	// Admit vets a freshly accepted connection.  On success it returns
+	// the connection to use from then on, whose Close releases the
+	// admission slot.  On failure the connection has already been closed.
+	Admit(conn net.Conn) (net.Conn, error)
+}
+
+// GatedPipeListener is an optional interface that a PipeListener can
+// implement to have inbound connections vetted by a ConnGate before the
+// SP handshake.
+type GatedPipeListener interface {
+
+	// SetGate installs the gate.  It is called before Listen.
+	SetGate(ConnGate)
+}
+
+// IdentifiedPipe is an optional interface that a Pipe can implement to
+// learn the id of the endpoint the core makes of it, e.g. for tracing.
+type IdentifiedPipe interface {
+
+	// SetEndpointId is called before the pipe is used.
+	SetEndpointId(EndpointId)
+}
+
+// FilePipeListener is an optional interface that a PipeListener can
+// implement to export its listening sockets, e.g. for a graceful upgrade.
+type FilePipeListener interface {
+
+	// Files returns duplicates of the listening descriptors, which
+	// the caller must close.
+	Files() ([]*os.File, error)
+}
+
+// TruncatingPipe is an optional interface that a Pipe of a stream
+// transport can implement for fault injection.
+type TruncatingPipe interface {
+
+	// SendTruncated sends the frame header and part of the message, as a
+	// connection lost in the middle of the frame would, and frees the
+	// message.  The pipe is of no use afterwards, and is to be closed.
+	SendTruncated(*Message) error
+}
+
 // Listener is an interface to the underlying listener for a transport
 // and address.
 type Listener interface {
@@ -149,6 +210,11 @@ type Listener interface {
 
 	// GetOption gets an option value from the Listener.
 	GetOption(name string) (interface{}, error)
+
+	// Files returns duplicates of the listening descriptors, for handing
+	// over to another process.  ErrNoListenFd is returned if the transport
+	// has none, e.g. inproc.
+	Files() ([]*os.File, error)
 }
 
 // Transport is the interface for transport suppliers to implement.
@@ -168,3 +234,27 @@ type Transport interface {
 	// any "listen" backlog.
 	NewListener(url string, protocol Protocol) (PipeListener, error)
 }
+
+// QueryTransport is an optional interface that a Transport can implement
+// when its addresses are URLs whose query it reads itself, e.g. a
+// websocket path with an access token.
+type QueryTransport interface {
+
+	// KeepsQuery tells whether the address query parameters that are not
+	// options are to be left in the address, instead of being refused.
+	KeepsQuery() bool
+}
+
+// FdConn is a connection able to pass file descriptors along with the
+// data, such as a unix domain socket.  The ipc pipe carries Message.Fds
+// over connections implementing it.
+type FdConn interface {
+	net.Conn
+
+	// WriteFds writes b, with the descriptors attached to it.
+	WriteFds(b []byte, fds []int) (int, error)
+
+	// TakeFds returns the next n descriptors received so far, in order.
+	// The descriptors arrive no later than the bytes they are attached to.
+	TakeFds(n int) ([]int, error)
+}
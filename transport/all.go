@@ -6,9 +6,12 @@ import (
 	"github.com/funkygao/nano/transport/ipc"
 	"github.com/funkygao/nano/transport/tcp"
 	"github.com/funkygao/nano/transport/tlstcp"
+	"github.com/funkygao/nano/transport/websocket"
 )
 
-// AddAll registers all known transports to the given socket.
+// AddAll registers all known transports to the given socket.  Importing
+// this package already registers them for all sockets, see
+// nano.RegisterTransport.
 // This allows a program to support all known transports as
 // well as supporting as yet-unknown transports, with a single command.
 func AddAll(sock nano.Socket) {
@@ -16,11 +19,15 @@ func AddAll(sock nano.Socket) {
 	sock.AddTransport(inproc.NewTransport())
 	sock.AddTransport(ipc.NewTransport())
 	sock.AddTransport(tlstcp.NewTransport())
+	sock.AddTransport(websocket.NewTransport())
+	sock.AddTransport(websocket.NewTLSTransport())
 }
 
 func AddAllOptions(sock nano.Socket, opts ...interface{}) {
 	sock.AddTransport(tcp.NewTransport(opts...))
 	sock.AddTransport(ipc.NewTransport(opts...))
-	sock.AddTransport(inproc.NewTransport()) // TODO
+	sock.AddTransport(inproc.NewTransport(opts...))
 	sock.AddTransport(tlstcp.NewTransport())
+	sock.AddTransport(websocket.NewTransport())
+	sock.AddTransport(websocket.NewTLSTransport())
 }
@@ -0,0 +1,298 @@
+// Package capture records the messages going through nano pipes to a
+// file, for replaying them later with the replay transport.
+//
+// A Recorder wraps a transport, keeping its scheme, so that a socket
+// captures its traffic without any change to its addresses:
+//
+//	rec, err := capture.Create("sub.cap")
+//	sock.AddTransport(rec.Wrap(tcp.NewTransport()))
+//	...
+//	rec.Close()
+//
+// The file starts with the 8 byte magic "NANOCAP1" and the capture start
+// time as 8 bytes of big endian Unix nanoseconds.  Each frame follows as:
+//
+//	direction   1 byte, 0 sent, 1 received
+//	time        uvarint nanoseconds since the capture start
+//	endpoint    uvarint
+//	protocols   2 uvarints, local and remote
+//	header      uvarint length, bytes
+//	body        uvarint length, bytes
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+const magic = "NANOCAP1"
+
+// ErrBadCapture is returned when reading a file that is no capture.
+var ErrBadCapture = errors.New("bad capture file")
+
+// Direction tells whether a frame was sent or received.
+type Direction uint8
+
+const (
+	Sent Direction = iota
+	Received
+)
+
+func (d Direction) String() string {
+	if d == Sent {
+		return "sent"
+	}
+	return "received"
+}
+
+// Frame is a message captured on a pipe.
+type Frame struct {
+	Time        time.Time
+	Dir         Direction
+	Endpoint    nano.EndpointId
+	LocalProto  uint16
+	RemoteProto uint16
+	Header      []byte
+	Body        []byte
+}
+
+// Recorder writes frames to a capture.  It is safe for concurrent use,
+// and shared by all the pipes it records.
+type Recorder struct {
+	w      *bufio.Writer
+	c      io.Closer
+	start  time.Time
+	buf    []byte
+	err    error
+	closed bool
+	sync.Mutex
+}
+
+// NewRecorder starts a capture on w.  If w is an io.Closer, the recorder
+// closes it on Close.
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	r := &Recorder{
+		w:     bufio.NewWriter(w),
+		start: time.Now(),
+		buf:   make([]byte, 0, 64),
+	}
+	r.c, _ = w.(io.Closer)
+
+	var hdr [16]byte
+	copy(hdr[:], magic)
+	binary.BigEndian.PutUint64(hdr[8:], uint64(r.start.UnixNano()))
+	if _, err := r.w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Create starts a capture in the named file.
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewRecorder(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record writes a frame.  A write error stops the capture, and is
+// returned by this and all later calls.
+func (this *Recorder) Record(f *Frame) error {
+	this.Lock()
+	defer this.Unlock()
+	if this.closed {
+		return nano.ErrClosed
+	}
+	if this.err != nil {
+		return this.err
+	}
+
+	elapsed := f.Time.Sub(this.start)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	b := append(this.buf[:0], byte(f.Dir))
+	b = appendUvarint(b, uint64(elapsed))
+	b = appendUvarint(b, uint64(f.Endpoint))
+	b = appendUvarint(b, uint64(f.LocalProto))
+	b = appendUvarint(b, uint64(f.RemoteProto))
+	b = appendUvarint(b, uint64(len(f.Header)))
+	b = append(b, f.Header...)
+	b = appendUvarint(b, uint64(len(f.Body)))
+	this.buf = b
+
+	if _, this.err = this.w.Write(b); this.err == nil {
+		_, this.err = this.w.Write(f.Body)
+	}
+	return this.err
+}
+
+// Flush writes the buffered frames out.
+func (this *Recorder) Flush() error {
+	this.Lock()
+	defer this.Unlock()
+	if this.err == nil {
+		this.err = this.w.Flush()
+	}
+	return this.err
+}
+
+// Close ends the capture.  Pipes still open are no longer recorded.
+func (this *Recorder) Close() error {
+	err := this.Flush()
+
+	this.Lock()
+	defer this.Unlock()
+	if this.closed {
+		return nano.ErrClosed
+	}
+	this.closed = true
+	if this.c != nil {
+		if cerr := this.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(b, tmp[:n]...)
+}
+
+// Reader reads the frames of a capture.
+type Reader struct {
+	r     *bufio.Reader
+	c     io.Closer
+	start time.Time
+}
+
+// NewReader checks the capture header on r.
+func NewReader(r io.Reader) (*Reader, error) {
+	this := &Reader{r: bufio.NewReader(r)}
+	this.c, _ = r.(io.Closer)
+
+	var hdr [16]byte
+	if _, err := io.ReadFull(this.r, hdr[:]); err != nil || string(hdr[:8]) != magic {
+		return nil, ErrBadCapture
+	}
+	this.start = time.Unix(0, int64(binary.BigEndian.Uint64(hdr[8:])))
+	return this, nil
+}
+
+// Open opens the named capture.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Start returns when the capture started.
+func (this *Reader) Start() time.Time {
+	return this.start
+}
+
+// Next returns the next frame, or io.EOF after the last one.  A capture
+// cut short, e.g. by a crash, ends with io.ErrUnexpectedEOF.
+func (this *Reader) Next() (*Frame, error) {
+	dir, err := this.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if Direction(dir) > Received {
+		return nil, ErrBadCapture
+	}
+
+	var v [4]uint64
+	for i := range v {
+		if v[i], err = binary.ReadUvarint(this.r); err != nil {
+			return nil, unexpected(err)
+		}
+	}
+	f := &Frame{
+		Dir:         Direction(dir),
+		Time:        this.start.Add(time.Duration(v[0])),
+		Endpoint:    nano.EndpointId(v[1]),
+		LocalProto:  uint16(v[2]),
+		RemoteProto: uint16(v[3]),
+	}
+	if f.Header, err = this.bytes(); err != nil {
+		return nil, err
+	}
+	if f.Body, err = this.bytes(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (this *Reader) bytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(this.r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	if n > 1<<31 {
+		return nil, ErrBadCapture
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(this.r, b); err != nil {
+		return nil, unexpected(err)
+	}
+	return b, nil
+}
+
+// Close closes the underlying file.
+func (this *Reader) Close() error {
+	if this.c != nil {
+		return this.c.Close()
+	}
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ReadFile returns all the frames of the named capture.
+func ReadFile(path string) ([]*Frame, error) {
+	r, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var frames []*Frame
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, f)
+	}
+}
@@ -0,0 +1,84 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pubsub"
+	"github.com/funkygao/nano/transport/inproc"
+)
+
+func TestCaptureRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub.cap")
+	rec, err := Create(path)
+	assert.Equal(t, nil, err)
+
+	pub := pubsub.NewPubSocket()
+	defer pub.Close()
+	assert.Equal(t, nil, pub.Listen("inproc://capture"))
+
+	sub := pubsub.NewSubSocket()
+	defer sub.Close()
+	sub.AddTransport(rec.Wrap(inproc.NewTransport()))
+	sub.SetOption(nano.OptionSubscribe, []byte(""))
+	sub.SetOption(nano.OptionRecvDeadline, time.Second)
+	assert.Equal(t, nil, sub.Dial("inproc://capture"))
+	time.Sleep(50 * time.Millisecond)
+
+	for _, s := range []string{"one", "two", "three"} {
+		assert.Equal(t, nil, pub.Send([]byte(s)))
+		m, err := sub.Recv()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, s, string(m))
+	}
+	assert.Equal(t, nil, rec.Close())
+
+	frames, err := ReadFile(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(frames))
+	for i, s := range []string{"one", "two", "three"} {
+		f := frames[i]
+		assert.Equal(t, Received, f.Dir)
+		assert.Equal(t, nano.ProtoSub, f.LocalProto)
+		assert.Equal(t, nano.ProtoPub, f.RemoteProto)
+		assert.Equal(t, s, string(f.Body))
+		assert.Equal(t, true, f.Endpoint != 0)
+		assert.Equal(t, frames[0].Endpoint, f.Endpoint)
+	}
+	assert.Equal(t, true, !frames[2].Time.Before(frames[0].Time))
+}
+
+func TestCaptureTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	assert.Equal(t, nil, err)
+	f := &Frame{Time: time.Now(), Dir: Sent, Endpoint: 7,
+		LocalProto: nano.ProtoReq, RemoteProto: nano.ProtoRep,
+		Header: []byte{0x80, 0, 0, 1}, Body: []byte("hello")}
+	assert.Equal(t, nil, rec.Record(f))
+	assert.Equal(t, nil, rec.Record(f))
+	assert.Equal(t, nil, rec.Close())
+
+	// cut in the middle of the second frame
+	b := buf.Bytes()
+	r, err := NewReader(bytes.NewReader(b[:len(b)-3]))
+	assert.Equal(t, nil, err)
+	got, err := r.Next()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nano.EndpointId(7), got.Endpoint)
+	assert.Equal(t, f.Header, got.Header)
+	assert.Equal(t, "hello", string(got.Body))
+	_, err = r.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = NewReader(bytes.NewReader([]byte("not a capture at all")))
+	assert.Equal(t, ErrBadCapture, err)
+	_, err = Open(filepath.Join(t.TempDir(), "missing.cap"))
+	assert.Equal(t, true, os.IsNotExist(err))
+}
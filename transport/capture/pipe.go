@@ -0,0 +1,63 @@
+package capture
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+// pipe implements the nano.Pipe interface, recording what goes through.
+type pipe struct {
+	nano.Pipe
+	r  *Recorder
+	id uint32 // nano.EndpointId
+}
+
+// NewPipe returns p with its messages recorded by r.  The frames carry
+// the endpoint id once the core has assigned one.
+func NewPipe(p nano.Pipe, r *Recorder) nano.Pipe {
+	return &pipe{Pipe: p, r: r}
+}
+
+func (this *pipe) SetEndpointId(id nano.EndpointId) {
+	atomic.StoreUint32(&this.id, uint32(id))
+	if ip, ok := this.Pipe.(nano.IdentifiedPipe); ok {
+		ip.SetEndpointId(id)
+	}
+}
+
+func (this *pipe) record(dir Direction, m *nano.Message) {
+	this.r.Record(&Frame{
+		Time:        time.Now(),
+		Dir:         dir,
+		Endpoint:    nano.EndpointId(atomic.LoadUint32(&this.id)),
+		LocalProto:  this.LocalProtocol(),
+		RemoteProto: this.RemoteProtocol(),
+		Header:      m.Header,
+		Body:        m.Body,
+	})
+}
+
+func (this *pipe) SendMsg(m *nano.Message) error {
+	// before the pipe recycles m
+	this.record(Sent, m)
+	return this.Pipe.SendMsg(m)
+}
+
+func (this *pipe) RecvMsg() (*nano.Message, error) {
+	m, err := this.Pipe.RecvMsg()
+	if err != nil {
+		return nil, err
+	}
+	this.record(Received, m)
+	return m, nil
+}
+
+// Wrap returns t with the pipes it makes recorded.  The scheme stays the
+// same, so that AddTransport replaces the registered t for a socket.
+func (this *Recorder) Wrap(t nano.Transport) nano.Transport {
+	return nano.WrapTransport(t, "", func(p nano.Pipe) (nano.Pipe, error) {
+		return NewPipe(p, this), nil
+	})
+}
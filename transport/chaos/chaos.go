@@ -0,0 +1,276 @@
+// Package chaos implements a fault injecting transport for nano, which
+// decorates another transport to test how sockets cope with a bad network:
+// REQ retries, surveyor deadlines, dialer reconnects and the like.
+//
+// The wrapped transports are registered as "chaos+<scheme>", e.g.
+// chaos+tcp://127.0.0.1:5678 or chaos+inproc://test, and are driven by
+// the Default controller.  Tests script the faults while sockets run:
+//
+//	chaos.Default.Set(chaos.Faults{Drop: 0.1, Latency: time.Millisecond})
+//	chaos.Default.FailHandshakes(2)
+//	chaos.Default.Disconnect()
+//
+// Any other transport can be wrapped, with a controller of its own:
+//
+//	c := chaos.NewController(1)
+//	sock.AddTransport(c.Wrap(mytransport.NewTransport()))
+package chaos
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/transport/inproc"
+	"github.com/funkygao/nano/transport/ipc"
+	"github.com/funkygao/nano/transport/tcp"
+	"github.com/funkygao/nano/transport/tlstcp"
+	"github.com/funkygao/nano/transport/websocket"
+)
+
+// Prefix is prepended to the scheme of a wrapped transport.
+const Prefix = "chaos+"
+
+// Fault is what happens to a message sent over a wrapped pipe.
+type Fault int
+
+const (
+	// Pass sends the message as is.
+	Pass Fault = iota
+
+	// Drop silently discards the message.
+	Drop
+
+	// Duplicate sends the message twice.
+	Duplicate
+
+	// Reorder holds the message back until after the next one, or for
+	// a short while if there is none.
+	Reorder
+
+	// Truncate cuts the connection in the middle of the message: the
+	// sender believes it was sent, the peer reads a short frame and loses
+	// the connection.  Transports without frames, like inproc, only lose
+	// the connection.
+	Truncate
+
+	// Disconnect closes the connection before the message is sent, and
+	// the send fails.
+	Disconnect
+)
+
+// reorderHold is how long a reordered message waits for a successor.
+const reorderHold = 10 * time.Millisecond
+
+// Faults describes the faults to inject.  Probabilities are in [0, 1] and
+// drawn per message in the order of the Fault constants.
+type Faults struct {
+	Latency time.Duration // added to every message sent
+	Jitter  time.Duration // random extra latency, up to this
+
+	Drop       float64
+	Duplicate  float64
+	Reorder    float64
+	Truncate   float64
+	Disconnect float64
+
+	// Handshake is the probability that a connection fails right after
+	// it is established, as on an SP header mismatch.
+	Handshake float64
+
+	// Script, if set, decides the fault of each message instead of the
+	// probabilities, e.g. to drop exactly the third request.
+	Script func(m *nano.Message) Fault
+}
+
+// Stats counts the faults injected by a controller.
+type Stats struct {
+	Sent        int
+	Dropped     int
+	Duplicated  int
+	Reordered   int
+	Truncated   int
+	Disconnects int
+	Handshakes  int
+}
+
+// Controller holds the faults of the transports it wraps, and can be
+// changed at any time, also while their pipes are in use.
+type Controller struct {
+	faults     Faults
+	rnd        *rand.Rand
+	handshakes int // forced handshake failures to come
+	stats      Stats
+	pipes      map[*pipe]struct{}
+	sync.Mutex
+}
+
+// Default drives the transports registered by this package.
+var Default = NewController(1)
+
+func init() {
+	for _, t := range []nano.Transport{
+		inproc.NewTransport(),
+		ipc.NewTransport(),
+		tcp.NewTransport(),
+		tlstcp.NewTransport(),
+		websocket.NewTransport(),
+		websocket.NewTLSTransport(),
+	} {
+		nano.RegisterTransport(Default.Wrap(t))
+	}
+}
+
+// NewController returns a controller injecting no faults, whose random
+// draws are repeatable for a seed.
+func NewController(seed int64) *Controller {
+	return &Controller{
+		rnd:   rand.New(rand.NewSource(seed)),
+		pipes: make(map[*pipe]struct{}),
+	}
+}
+
+// Set replaces the faults to inject.
+func (this *Controller) Set(f Faults) {
+	this.Lock()
+	this.faults = f
+	this.Unlock()
+}
+
+// Faults returns the faults being injected.
+func (this *Controller) Faults() Faults {
+	this.Lock()
+	defer this.Unlock()
+	return this.faults
+}
+
+// Reset stops injecting faults, and clears the stats and any pending
+// handshake failures.
+func (this *Controller) Reset() {
+	this.Lock()
+	this.faults = Faults{}
+	this.handshakes = 0
+	this.stats = Stats{}
+	this.Unlock()
+}
+
+// Seed restarts the random draws.
+func (this *Controller) Seed(seed int64) {
+	this.Lock()
+	this.rnd.Seed(seed)
+	this.Unlock()
+}
+
+// FailHandshakes makes the next n connections fail their handshake,
+// whether dialed or accepted.
+func (this *Controller) FailHandshakes(n int) {
+	this.Lock()
+	this.handshakes = n
+	this.Unlock()
+}
+
+// Disconnect closes all open connections at once, and returns how many.
+func (this *Controller) Disconnect() int {
+	this.Lock()
+	pipes := make([]*pipe, 0, len(this.pipes))
+	for p := range this.pipes {
+		pipes = append(pipes, p)
+	}
+	this.stats.Disconnects += len(pipes)
+	this.Unlock()
+
+	for _, p := range pipes {
+		p.Close()
+	}
+	return len(pipes)
+}
+
+// Stats returns the faults injected so far.
+func (this *Controller) Stats() Stats {
+	this.Lock()
+	defer this.Unlock()
+	return this.stats
+}
+
+// Wrap returns t with faults injected into its pipes.  Its scheme is the
+// one of t with Prefix.
+func (this *Controller) Wrap(t nano.Transport) nano.Transport {
+	return nano.WrapTransport(t, Prefix, this.adopt)
+}
+
+// draw decides the fault and latency of a message.
+func (this *Controller) draw(m *nano.Message) (Fault, time.Duration) {
+	this.Lock()
+	f := this.faults
+	delay := f.Latency
+	if f.Jitter > 0 {
+		delay += time.Duration(this.rnd.Int63n(int64(f.Jitter)))
+	}
+	fault := Pass
+	if f.Script == nil {
+		for i, p := range []float64{f.Drop, f.Duplicate, f.Reorder,
+			f.Truncate, f.Disconnect} {
+			if p > 0 && this.rnd.Float64() < p {
+				fault = Fault(i + 1)
+				break
+			}
+		}
+	}
+	this.Unlock()
+
+	if f.Script != nil {
+		// unlocked, the script may well change the faults
+		fault = f.Script(m)
+	}
+
+	this.Lock()
+	this.stats.Sent++
+	switch fault {
+	case Drop:
+		this.stats.Dropped++
+	case Duplicate:
+		this.stats.Duplicated++
+	case Reorder:
+		this.stats.Reordered++
+	case Truncate:
+		this.stats.Truncated++
+	case Disconnect:
+		this.stats.Disconnects++
+	}
+	this.Unlock()
+	return fault, delay
+}
+
+// handshake tells if a new connection may proceed.
+func (this *Controller) handshake() bool {
+	this.Lock()
+	defer this.Unlock()
+	if this.handshakes > 0 {
+		this.handshakes--
+	} else if p := this.faults.Handshake; p <= 0 || this.rnd.Float64() >= p {
+		return true
+	}
+	this.stats.Handshakes++
+	return false
+}
+
+// adopt wraps a freshly established pipe, unless its handshake is to fail.
+func (this *Controller) adopt(p nano.Pipe) (nano.Pipe, error) {
+	if !this.handshake() {
+		p.Close()
+		return nil, nano.ErrBadHeader
+	}
+
+	cp := &pipe{Pipe: p, c: this}
+	this.Lock()
+	this.pipes[cp] = struct{}{}
+	this.Unlock()
+	return cp, nil
+}
+
+func (this *Controller) forget(p *pipe) {
+	this.Lock()
+	delete(this.pipes, p)
+	this.Unlock()
+}
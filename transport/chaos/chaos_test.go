@@ -0,0 +1,206 @@
+package chaos
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pair"
+	"github.com/funkygao/nano/protocol/pipeline"
+	"github.com/funkygao/nano/protocol/reqrep"
+	"github.com/funkygao/nano/protocol/survey"
+	"github.com/funkygao/nano/test"
+	"github.com/funkygao/nano/transport/inproc"
+	"github.com/funkygao/nano/transport/tcp"
+)
+
+var tt = test.NewTranTest(NewController(1).Wrap(inproc.NewTransport()),
+	"chaos+inproc://chaos")
+
+func TestChaosTran(t *testing.T) {
+	tt.TranTestAll(t)
+}
+
+// body matches a message by its payload, which follows protocol headers.
+func body(m *nano.Message, s string) bool {
+	return bytes.HasSuffix(m.Body, []byte(s))
+}
+
+func TestChaosReqRetry(t *testing.T) {
+	defer Default.Reset()
+	lost := 0
+	Default.Set(Faults{Script: func(m *nano.Message) Fault {
+		if body(m, "ping") && lost < 2 {
+			lost++
+			return Drop
+		}
+		return Pass
+	}})
+
+	rep := reqrep.NewRepSocket()
+	defer rep.Close()
+	assert.Equal(t, nil, rep.Listen("chaos+inproc://retry"))
+	go func() {
+		for {
+			m, err := rep.Recv()
+			if err != nil {
+				return
+			}
+			rep.Send(append(m, '!'))
+		}
+	}()
+
+	req := reqrep.NewReqSocket()
+	defer req.Close()
+	req.SetOption(nano.OptionRetryTime, 50*time.Millisecond)
+	req.SetOption(nano.OptionRecvDeadline, 2*time.Second)
+	assert.Equal(t, nil, req.Dial("chaos+inproc://retry"))
+	assert.Equal(t, nil, req.Send([]byte("ping")))
+	m, err := req.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ping!", string(m))
+	assert.Equal(t, 2, Default.Stats().Dropped)
+}
+
+func TestChaosReconnect(t *testing.T) {
+	defer Default.Reset()
+	Default.FailHandshakes(2)
+
+	rep := reqrep.NewRepSocket()
+	defer rep.Close()
+	assert.Equal(t, nil, rep.Listen("chaos+tcp://127.0.0.1:3390"))
+	go func() {
+		for {
+			m, err := rep.Recv()
+			if err != nil {
+				return
+			}
+			rep.Send(m)
+		}
+	}()
+
+	req := reqrep.NewReqSocket()
+	defer req.Close()
+	req.SetOption(nano.OptionRetryTime, 100*time.Millisecond)
+	req.SetOption(nano.OptionRecvDeadline, 2*time.Second)
+	assert.Equal(t, nil, req.Dial("chaos+tcp://127.0.0.1:3390"))
+
+	assert.Equal(t, nil, req.Send([]byte("one")))
+	m, err := req.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "one", string(m))
+	assert.Equal(t, 2, Default.Stats().Handshakes)
+
+	// both ends of the connection go down, the dialer comes back
+	assert.Equal(t, 2, Default.Disconnect())
+	assert.Equal(t, nil, req.Send([]byte("two")))
+	m, err = req.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "two", string(m))
+}
+
+func TestChaosDupReorder(t *testing.T) {
+	defer Default.Reset()
+	Default.Set(Faults{Script: func(m *nano.Message) Fault {
+		switch {
+		case body(m, "a"):
+			return Reorder
+		case body(m, "c"):
+			return Duplicate
+		case body(m, "d"):
+			return Drop
+		}
+		return Pass
+	}})
+
+	pull := pipeline.NewPullSocket()
+	defer pull.Close()
+	pull.SetOption(nano.OptionRecvDeadline, time.Second)
+	assert.Equal(t, nil, pull.Listen("chaos+inproc://dup"))
+	push := pipeline.NewPushSocket()
+	defer push.Close()
+	assert.Equal(t, nil, push.Dial("chaos+inproc://dup"))
+
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		assert.Equal(t, nil, push.Send([]byte(s)))
+	}
+	var got string
+	for i := 0; i < 5; i++ {
+		m, err := pull.Recv()
+		assert.Equal(t, nil, err)
+		got += string(m)
+	}
+	assert.Equal(t, "bacce", got)
+}
+
+func TestChaosSurveyDeadline(t *testing.T) {
+	defer Default.Reset()
+	// the answer comes too late
+	Default.Set(Faults{Latency: 300 * time.Millisecond})
+
+	surveyor := survey.NewSurveyorSocket()
+	defer surveyor.Close()
+	surveyor.SetOption(nano.OptionSurveyTime, 100*time.Millisecond)
+	assert.Equal(t, nil, surveyor.Listen("chaos+inproc://survey"))
+
+	resp := survey.NewRespondentSocket()
+	defer resp.Close()
+	assert.Equal(t, nil, resp.Dial("chaos+inproc://survey"))
+	go func() {
+		if _, err := resp.Recv(); err == nil {
+			resp.Send([]byte("late"))
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, nil, surveyor.Send([]byte("who")))
+	surveyor.SetOption(nano.OptionRecvDeadline, time.Second)
+	// the Recv waiting when the survey expires ignores the late answer
+	_, err := surveyor.Recv()
+	if err != nano.ErrRecvTimeout && err != nano.ErrProtoState {
+		t.Fatalf("Recv across the survey expiry: %v", err)
+	}
+	_, err = surveyor.Recv()
+	assert.Equal(t, nano.ErrProtoState, err)
+}
+
+func TestChaosTruncate(t *testing.T) {
+	c := NewController(1)
+	c.Set(Faults{Truncate: 1})
+	tran := c.Wrap(tcp.NewTransport())
+	addr := "chaos+tcp://127.0.0.1:3390"
+	proto := pair.NewSocket().GetProtocol()
+
+	l, err := tran.NewListener(addr, proto)
+	assert.Equal(t, nil, err)
+	defer l.Close()
+	assert.Equal(t, nil, l.Listen())
+
+	sent := make(chan error, 1)
+	go func() {
+		d, err := tran.NewDialer(addr, proto)
+		if err != nil {
+			sent <- err
+			return
+		}
+		p, err := d.Dial()
+		if err != nil {
+			sent <- err
+			return
+		}
+		m := nano.NewMessage(64)
+		m.Body = append(m.Body, bytes.Repeat([]byte("x"), 64)...)
+		sent <- p.SendMsg(m)
+	}()
+
+	p, err := l.Accept()
+	assert.Equal(t, nil, err)
+	defer p.Close()
+	assert.Equal(t, nil, <-sent)
+	_, err = p.RecvMsg()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, 1, c.Stats().Truncated)
+}
@@ -0,0 +1,117 @@
+package chaos
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+// pipe implements the nano.Pipe interface, injecting the faults of its
+// controller into the messages it sends.
+type pipe struct {
+	nano.Pipe
+	c *Controller
+
+	sendLock sync.Mutex
+
+	held       *nano.Message // reordered, waiting for its successor
+	timer      *time.Timer
+	once       sync.Once
+	sync.Mutex // guards held
+}
+
+func (this *pipe) SendMsg(m *nano.Message) error {
+	fault, delay := this.c.draw(m)
+
+	this.sendLock.Lock()
+	defer this.sendLock.Unlock()
+
+	if delay > 0 {
+		// in order, as a slow link would be
+		time.Sleep(delay)
+	}
+
+	switch fault {
+	case Drop:
+		m.Free()
+		return nil
+
+	case Truncate:
+		if tp, ok := this.Pipe.(nano.TruncatingPipe); ok {
+			tp.SendTruncated(m)
+		} else {
+			// no frames to cut short, e.g. inproc
+			m.Free()
+		}
+		this.Close()
+		return nil
+
+	case Disconnect:
+		m.Free()
+		this.Close()
+		return nano.ErrClosed
+
+	case Reorder:
+		this.Lock()
+		if this.held == nil {
+			this.held = m
+			this.timer = time.AfterFunc(reorderHold, this.release)
+			this.Unlock()
+			return nil
+		}
+		this.Unlock()
+		// one is held already, which this one overtakes anyway
+
+	case Duplicate:
+		if err := this.Pipe.SendMsg(m.Dup()); err != nil {
+			m.Free()
+			return err
+		}
+	}
+
+	if err := this.Pipe.SendMsg(m); err != nil {
+		return err
+	}
+	return this.sendHeld()
+}
+
+// sendHeld sends the reordered message, if any, after its successor.
+func (this *pipe) sendHeld() error {
+	this.Lock()
+	m := this.held
+	if m != nil {
+		this.timer.Stop()
+		this.held = nil
+	}
+	this.Unlock()
+
+	if m == nil {
+		return nil
+	}
+	return this.Pipe.SendMsg(m)
+}
+
+// release sends the reordered message no successor came for.
+func (this *pipe) release() {
+	this.sendLock.Lock()
+	defer this.sendLock.Unlock()
+	if this.sendHeld() == nil {
+		this.Pipe.Flush()
+	}
+}
+
+// Close does not wait for a send in progress, which it is to abort.
+func (this *pipe) Close() error {
+	this.once.Do(func() {
+		this.c.forget(this)
+		this.Lock()
+		if this.held != nil {
+			this.timer.Stop()
+			this.held.Free()
+			this.held = nil
+		}
+		this.Unlock()
+	})
+	return this.Pipe.Close()
+}
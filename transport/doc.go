@@ -1,6 +1,14 @@
 // Package transport implements nano.Transport interface.
 // Examples of transport url:
 // tcp://*:5678  ipc://x.sock  inproc://test  tls+tcp://12.1.22.1:5678
+// ws://12.1.22.1:8080/path  wss://12.1.22.1:8443/path
+// shm://md-feed (same host, Linux)
+// fd://3,4  stdio:// (inherited descriptors)
+// chaos+tcp://127.0.0.1:5678 (fault injection, for tests)
+// replay:///tmp/sub.cap (captured traffic, for tests)
+//
+// Each transport package registers its transport with nano.RegisterTransport
+// on import, so sockets need not AddTransport them.
 /*
 type Transport interface {
 
@@ -0,0 +1,331 @@
+// Package fd implements transports running a single pipe over file
+// descriptors the process already has, e.g. to talk to a child process
+// over its stdin and stdout, without any port or socket file.
+//
+// The fd:// scheme takes one descriptor for a full duplex connection,
+// such as one end of a socketpair, or a read and a write descriptor, as
+// a pair of pipes:
+//
+//	fd://3
+//	fd://3,4
+//
+// The stdio:// scheme reads stdin and writes stdout; the process then
+// must not print anything else there, logs go to stderr.  It needs unix.
+//
+// Messages are framed like on tcp, the SP handshake included.  Either
+// side may dial or listen.  There is only ever one connection: the first
+// dial or accept gets it, and takes the descriptors over, closing them
+// with the pipe.  Later dials fail, and later accepts wait for the
+// listener to close.  On unix the descriptors are made non-blocking, so
+// that closing the pipe interrupts a pending read.
+//
+// Stdin and stdout are not taken over but duplicated, so that os.Stdin
+// and os.Stdout stay valid, and they are left blocking, as their mode is
+// shared with the parent process.  Closing the pipe then neither ends
+// the output for the peer, nor interrupts a pending read of stdin.
+package fd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+// options is used for shared GetOption/SetOption logic.
+type options map[string]interface{}
+
+// get retrieves an option value.
+func (o options) get(name string) (interface{}, error) {
+	if v, ok := o[name]; ok {
+		return v, nil
+	}
+	return nil, nano.ErrBadOption
+}
+
+// set stores a pipe option, the only ones there are.
+func (o options) set(name string, val interface{}) error {
+	if nano.IsPipeOption(name) {
+		return nano.SetPipeOption(o, name, val)
+	}
+	return nano.ErrBadOption
+}
+
+// addr is the net.Addr of both ends of a connection.
+type addr string
+
+func (a addr) Network() string {
+	return "fd"
+}
+
+func (a addr) String() string {
+	return string(a)
+}
+
+// conn implements net.Conn over a read and a write file, possibly the
+// same one.
+type conn struct {
+	r, w *os.File
+	addr addr
+	once sync.Once
+}
+
+func (this *conn) Read(b []byte) (int, error) {
+	return this.r.Read(b)
+}
+
+func (this *conn) Write(b []byte) (int, error) {
+	return this.w.Write(b)
+}
+
+func (this *conn) Close() (err error) {
+	this.once.Do(func() {
+		err = this.r.Close()
+		if this.w != this.r {
+			if werr := this.w.Close(); err == nil {
+				err = werr
+			}
+		}
+	})
+	return
+}
+
+func (this *conn) LocalAddr() net.Addr {
+	return this.addr
+}
+
+func (this *conn) RemoteAddr() net.Addr {
+	return this.addr
+}
+
+func (this *conn) SetDeadline(t time.Time) error {
+	if err := this.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return this.SetWriteDeadline(t)
+}
+
+func (this *conn) SetReadDeadline(t time.Time) error {
+	return this.r.SetReadDeadline(t)
+}
+
+func (this *conn) SetWriteDeadline(t time.Time) error {
+	return this.w.SetWriteDeadline(t)
+}
+
+// endpoint holds the descriptors of an address until a pipe takes them.
+type endpoint struct {
+	t      *fdTran
+	addr   string
+	rfd    int
+	wfd    int
+	proto  nano.Protocol
+	opts   options
+	used   bool
+	closeq chan struct{}
+	once   sync.Once
+	sync.Mutex
+}
+
+// pipe makes the one pipe of the address, or returns nil if it was made
+// already.
+func (this *endpoint) pipe() (nano.Pipe, error) {
+	this.Lock()
+	if this.used {
+		this.Unlock()
+		return nil, nil
+	}
+	this.used = true
+	this.Unlock()
+
+	open := newFile
+	if this.t.scheme == "stdio" {
+		open = dupFile
+	}
+	r, err := open(this.rfd, this.addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{r: r, w: r, addr: addr(this.addr)}
+	if this.wfd != this.rfd {
+		if c.w, err = open(this.wfd, this.addr); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	p, err := nano.NewConnPipe(c, this.proto,
+		nano.PipeProps(this.t.opts, this.opts)...)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetOption implements the PipeDialer and PipeListener SetOption method.
+func (this *endpoint) SetOption(n string, v interface{}) error {
+	return this.opts.set(n, v)
+}
+
+// GetOption implements the PipeDialer and PipeListener GetOption method.
+func (this *endpoint) GetOption(n string) (interface{}, error) {
+	return this.opts.get(n)
+}
+
+type dialer struct {
+	*endpoint
+}
+
+// Dial implements the PipeDialer Dial method.  Only the first dial
+// connects.
+func (d *dialer) Dial() (nano.Pipe, error) {
+	p, err := d.pipe()
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, nano.ErrClosed
+	}
+	return p, nil
+}
+
+type listener struct {
+	*endpoint
+}
+
+// Listen implements the PipeListener Listen method.
+func (l *listener) Listen() error {
+	return nil
+}
+
+// Accept implements the PipeListener Accept method.  Only the first
+// accept connects, the next one waits for the listener to close.
+func (l *listener) Accept() (nano.Pipe, error) {
+	p, err := l.pipe()
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		<-l.closeq
+		return nil, nano.ErrClosed
+	}
+	return p, nil
+}
+
+// Close implements the PipeListener Close method.  The descriptors, if
+// not taken yet, stay open.
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		close(l.closeq)
+	})
+	return nil
+}
+
+type fdTran struct {
+	scheme string
+	opts   options
+}
+
+// Scheme implements the Transport Scheme method.
+func (t *fdTran) Scheme() string {
+	return t.scheme
+}
+
+// parse returns the read and write descriptors of an address.
+func (t *fdTran) parse(addr string) (rfd, wfd int, err error) {
+	s, err := nano.StripScheme(t, addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if t.scheme == "stdio" {
+		if s != "" {
+			return 0, 0, nano.ErrBadAddr
+		}
+		rfd, wfd = stdio()
+		return rfd, wfd, nil
+	}
+
+	fds := strings.Split(s, ",")
+	if len(fds) > 2 {
+		return 0, 0, nano.ErrBadAddr
+	}
+	for i, f := range fds {
+		fd, err := strconv.Atoi(f)
+		if err != nil || fd < 0 {
+			return 0, 0, nano.ErrBadAddr
+		}
+		if i == 0 {
+			rfd = fd
+		}
+		wfd = fd
+	}
+	return rfd, wfd, nil
+}
+
+func (t *fdTran) newEndpoint(addr string, proto nano.Protocol) (*endpoint, error) {
+	rfd, wfd, err := t.parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &endpoint{
+		t:      t,
+		addr:   addr,
+		rfd:    rfd,
+		wfd:    wfd,
+		proto:  proto,
+		opts:   make(options),
+		closeq: make(chan struct{}),
+	}, nil
+}
+
+// NewDialer implements the Transport NewDialer method.
+func (t *fdTran) NewDialer(addr string, proto nano.Protocol) (nano.PipeDialer, error) {
+	ep, err := t.newEndpoint(addr, proto)
+	if err != nil {
+		return nil, err
+	}
+	return &dialer{ep}, nil
+}
+
+// NewListener implements the Transport NewListener method.
+func (t *fdTran) NewListener(addr string, proto nano.Protocol) (nano.PipeListener, error) {
+	ep, err := t.newEndpoint(addr, proto)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{ep}, nil
+}
+
+func init() {
+	nano.RegisterTransport(NewTransport())
+	nano.RegisterTransport(NewStdioTransport())
+}
+
+func newTransport(scheme string, opts []interface{}) nano.Transport {
+	t := &fdTran{scheme: scheme, opts: make(options)}
+	if len(opts)%2 != 0 {
+		return nil
+	}
+	for i := 0; i+1 < len(opts); i += 2 {
+		name, ok := opts[i].(string)
+		if !ok || t.opts.set(name, opts[i+1]) != nil {
+			return nil
+		}
+	}
+	return t
+}
+
+// NewTransport allocates a new fd:// transport.  Options are those of
+// pipes, such as nano.OptionMaxRecvSize.
+func NewTransport(opts ...interface{}) nano.Transport {
+	return newTransport("fd", opts)
+}
+
+// NewStdioTransport allocates a new stdio:// transport.
+func NewStdioTransport(opts ...interface{}) nano.Transport {
+	return newTransport("stdio", opts)
+}
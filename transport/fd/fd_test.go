@@ -0,0 +1,127 @@
+// +build !windows,!plan9
+
+package fd
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/reqrep"
+)
+
+func echo(t *testing.T, rep, req nano.Socket) {
+	go func() {
+		m, err := rep.Recv()
+		if err == nil {
+			rep.Send(append(m, '!'))
+		}
+	}()
+
+	req.SetOption(nano.OptionRecvDeadline, 2*time.Second)
+	assert.Equal(t, nil, req.Send([]byte("ping")))
+	m, err := req.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ping!", string(m))
+}
+
+func TestFdSocketpair(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Equal(t, nil, err)
+
+	rep := reqrep.NewRepSocket()
+	defer rep.Close()
+	assert.Equal(t, nil, rep.Listen(fmt.Sprintf("fd://%d", fds[0])))
+	req := reqrep.NewReqSocket()
+	defer req.Close()
+	assert.Equal(t, nil, req.Dial(fmt.Sprintf("fd://%d", fds[1])))
+
+	echo(t, rep, req)
+}
+
+func TestFdPipes(t *testing.T) {
+	var up, down [2]int
+	assert.Equal(t, nil, syscall.Pipe(up[:]))
+	assert.Equal(t, nil, syscall.Pipe(down[:]))
+
+	rep := reqrep.NewRepSocket()
+	defer rep.Close()
+	assert.Equal(t, nil, rep.Dial(fmt.Sprintf("fd://%d,%d", down[0], up[1])))
+	req := reqrep.NewReqSocket()
+	defer req.Close()
+	assert.Equal(t, nil, req.Dial(fmt.Sprintf("fd://%d,%d", up[0], down[1])))
+
+	echo(t, rep, req)
+}
+
+func TestFdOnce(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Equal(t, nil, err)
+	defer syscall.Close(fds[1])
+
+	proto := reqrep.NewReqSocket().GetProtocol()
+	d, err := NewTransport(nano.OptionNoHandshake, true).
+		NewDialer(fmt.Sprintf("fd://%d", fds[0]), proto)
+	assert.Equal(t, nil, err)
+	p, err := d.Dial()
+	assert.Equal(t, nil, err)
+	_, err = d.Dial()
+	assert.Equal(t, nano.ErrClosed, err)
+
+	// closing the pipe interrupts a pending read
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		p.Close()
+	}()
+	_, err = p.RecvMsg()
+	assert.Equal(t, true, err != nil)
+}
+
+func TestStdioDup(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Equal(t, nil, err)
+	defer syscall.Close(fds[0])
+
+	// fds[0] stands for stdin and stdout
+	ep, err := NewStdioTransport().(*fdTran).newEndpoint("stdio://",
+		reqrep.NewRepSocket().GetProtocol())
+	assert.Equal(t, nil, err)
+	ep.rfd, ep.wfd = fds[0], fds[0]
+
+	d, err := NewTransport().NewDialer(fmt.Sprintf("fd://%d", fds[1]),
+		reqrep.NewReqSocket().GetProtocol())
+	assert.Equal(t, nil, err)
+	dialed := make(chan nano.Pipe, 1)
+	go func() {
+		p, _ := d.Dial()
+		dialed <- p
+	}()
+
+	p, err := ep.pipe()
+	assert.Equal(t, nil, err)
+	peer := <-dialed
+	assert.NotEqual(t, nil, peer)
+	p.Close()
+	peer.Close()
+
+	// still open, and blocking
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fds[0]),
+		syscall.F_GETFL, 0)
+	assert.Equal(t, syscall.Errno(0), errno)
+	assert.Equal(t, uintptr(0), flags&syscall.O_NONBLOCK)
+}
+
+func TestFdAddr(t *testing.T) {
+	proto := reqrep.NewReqSocket().GetProtocol()
+	for _, addr := range []string{"fd://", "fd://x", "fd://-1", "fd://1,2,3"} {
+		_, err := NewTransport().NewDialer(addr, proto)
+		assert.Equal(t, nano.ErrBadAddr, err)
+	}
+	_, err := NewStdioTransport().NewDialer("stdio://1", proto)
+	assert.Equal(t, nano.ErrBadAddr, err)
+	_, err = NewStdioTransport().NewListener("stdio://", proto)
+	assert.Equal(t, nil, err)
+}
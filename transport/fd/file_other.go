@@ -0,0 +1,22 @@
+// +build windows plan9
+
+package fd
+
+import (
+	"os"
+
+	"github.com/funkygao/nano"
+)
+
+func newFile(fd int, name string) (*os.File, error) {
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// dupFile wraps a duplicate of fd, stdio needs unix.
+func dupFile(fd int, name string) (*os.File, error) {
+	return nil, nano.ErrBadTran
+}
+
+func stdio() (int, int) {
+	return int(os.Stdin.Fd()), int(os.Stdout.Fd())
+}
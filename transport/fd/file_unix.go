@@ -0,0 +1,36 @@
+// +build !windows,!plan9
+
+package fd
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/funkygao/nano"
+)
+
+// newFile takes fd over, and makes it non-blocking for the runtime poller
+// to wait on it.
+func newFile(fd int, name string) (*os.File, error) {
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		nano.Debugf("%s: %v", name, err)
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// dupFile wraps a duplicate of fd, which stays open for the rest of the
+// process, e.g. as os.Stdout.  The duplicate is left blocking, since the
+// mode is shared with fd, and with the parent process fd is inherited
+// from.
+func dupFile(fd int, name string) (*os.File, error) {
+	dup, err := syscall.Dup(fd)
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(dup)
+	return os.NewFile(uintptr(dup), name), nil
+}
+
+func stdio() (int, int) {
+	return syscall.Stdin, syscall.Stdout
+}
@@ -1,4 +1,8 @@
 // Package inproc implements an simple inproc transport for nano.
+//
+// Messages are not serialized: the *Message itself is handed to the peer
+// over a channel, so a send costs a channel operation.  Ownership of the
+// message passes to the receiver, just as with any other transport.
 package inproc
 
 import (
@@ -14,8 +18,11 @@ type inproc struct {
 	wq     chan *nano.Message
 	closeq chan struct{}
 	readyq chan struct{}
+	once   sync.Once
 	proto  nano.Protocol
 	addr   addr
+	props  map[string]interface{}
+	qlen   int // capacity of the channel this side writes to
 	peer   *inproc
 }
 
@@ -36,10 +43,14 @@ func (addr) Network() string {
 type listener struct {
 	addr      string
 	proto     nano.Protocol
+	opts      options
+	t         *inprocTran
 	accepters []*inproc
 }
 
-type inprocTran struct{}
+type inprocTran struct {
+	opts map[string]interface{}
+}
 
 var listeners struct {
 	// Who is listening, on which "address"?
@@ -51,6 +62,25 @@ var listeners struct {
 func init() {
 	listeners.byAddr = make(map[string]*listener)
 	listeners.cv.L = &listeners.mx
+
+	nano.RegisterTransport(NewTransport())
+}
+
+func newInproc(a string, proto nano.Protocol, t *inprocTran, opts options) *inproc {
+	p := &inproc{
+		proto:  proto,
+		addr:   addr(a),
+		readyq: make(chan struct{}),
+		closeq: make(chan struct{}),
+		props:  make(map[string]interface{}),
+		qlen:   opts.qlen(),
+	}
+	for n, v := range t.opts {
+		p.props[n] = v
+	}
+	p.props[nano.PropLocalAddr] = p.addr
+	p.props[nano.PropRemoteAddr] = p.addr
+	return p
 }
 
 func (p *inproc) RecvMsg() (*nano.Message, error) {
@@ -58,17 +88,19 @@ func (p *inproc) RecvMsg() (*nano.Message, error) {
 		return nil, nano.ErrClosed
 	}
 	select {
-	case m, ok := <-p.rq:
-		if m == nil || !ok {
-			return nil, nano.ErrClosed
-		}
-		// Upper protocols expect to have to pick header and
-		// body part.  So mush them back together.
-		//msg.Body = append(msg.Header, msg.Body...)
-		//msg.Header = make([]byte, 0, 32)
+	case m := <-p.rq:
 		return m, nil
 	case <-p.closeq:
 		return nil, nano.ErrClosed
+	case <-p.peer.closeq:
+		// Like a socket, whatever the peer sent before closing can
+		// still be received.
+		select {
+		case m := <-p.rq:
+			return m, nil
+		default:
+			return nil, nano.ErrClosed
+		}
 	}
 }
 
@@ -78,22 +110,21 @@ func (p *inproc) Flush() error {
 
 func (p *inproc) SendMsg(m *nano.Message) error {
 	if p.peer == nil {
+		m.Free()
 		return nano.ErrClosed
 	}
 
-	// Upper protocols expect to have to pick header and body part.
-	// Also we need to have a fresh copy of the message for receiver, to
-	// break ownership.
-	nmsg := nano.NewMessage(len(m.Header) + len(m.Body))
-	nmsg.Body = append(nmsg.Body, m.Header...)
-	nmsg.Body = append(nmsg.Body, m.Body...)
+	// No copy: the receiver takes over the message, with the header
+	// folded into the body as the upper protocols expect to split them.
+	m = m.Handover()
 	select {
-	case p.wq <- nmsg:
+	case p.wq <- m:
 		return nil
 	case <-p.closeq:
-		nmsg.Free()
-		return nano.ErrClosed
+	case <-p.peer.closeq:
 	}
+	m.Free()
+	return nano.ErrClosed
 }
 
 func (p *inproc) LocalProtocol() uint16 {
@@ -105,7 +136,9 @@ func (p *inproc) RemoteProtocol() uint16 {
 }
 
 func (p *inproc) Close() error {
-	close(p.closeq)
+	p.once.Do(func() {
+		close(p.closeq)
+	})
 	return nil
 }
 
@@ -114,32 +147,35 @@ func (p *inproc) IsOpen() bool {
 	case <-p.closeq:
 		return false
 	default:
-		return true
 	}
+	if p.peer != nil {
+		select {
+		case <-p.peer.closeq:
+			return false
+		default:
+		}
+	}
+	return true
 }
 
 func (p *inproc) GetProp(name string) (interface{}, error) {
-	switch name {
-	case nano.PropRemoteAddr:
-		return p.addr, nil
-	case nano.PropLocalAddr:
-		return p.addr, nil
+	if v, ok := p.props[name]; ok {
+		return v, nil
 	}
-	// We have no special properties
 	return nil, nano.ErrBadProperty
 }
 
 type dialer struct {
 	addr  string
 	proto nano.Protocol
+	opts  options
+	t     *inprocTran
 }
 
 func (d *dialer) Dial() (nano.Pipe, error) {
 
 	var server *inproc
-	client := &inproc{proto: d.proto, addr: addr(d.addr)}
-	client.readyq = make(chan struct{})
-	client.closeq = make(chan struct{})
+	client := newInproc(d.addr, d.proto, d.t, d.opts)
 
 	listeners.mx.Lock()
 
@@ -153,6 +189,7 @@ func (d *dialer) Dial() (nano.Pipe, error) {
 		}
 
 		if !nano.ValidPeers(client.proto, l.proto) {
+			listeners.mx.Unlock()
 			return nil, nano.ErrBadProto
 		}
 
@@ -168,8 +205,8 @@ func (d *dialer) Dial() (nano.Pipe, error) {
 
 	listeners.mx.Unlock()
 
-	server.wq = make(chan *nano.Message)
-	server.rq = make(chan *nano.Message)
+	server.wq = make(chan *nano.Message, server.qlen)
+	server.rq = make(chan *nano.Message, client.qlen)
 	client.rq = server.wq
 	client.wq = server.rq
 	server.peer = client
@@ -180,12 +217,12 @@ func (d *dialer) Dial() (nano.Pipe, error) {
 	return client, nil
 }
 
-func (*dialer) SetOption(string, interface{}) error {
-	return nano.ErrBadOption
+func (d *dialer) SetOption(n string, v interface{}) error {
+	return d.opts.set(n, v)
 }
 
-func (*dialer) GetOption(string) (interface{}, error) {
-	return nil, nano.ErrBadOption
+func (d *dialer) GetOption(n string) (interface{}, error) {
+	return d.opts.get(n)
 }
 
 func (l *listener) Listen() error {
@@ -201,9 +238,7 @@ func (l *listener) Listen() error {
 }
 
 func (l *listener) Accept() (nano.Pipe, error) {
-	server := &inproc{proto: l.proto, addr: addr(l.addr)}
-	server.readyq = make(chan struct{})
-	server.closeq = make(chan struct{})
+	server := newInproc(l.addr, l.proto, l.t, l.opts)
 
 	listeners.mx.Lock()
 	l.accepters = append(l.accepters, server)
@@ -218,12 +253,12 @@ func (l *listener) Accept() (nano.Pipe, error) {
 	}
 }
 
-func (*listener) SetOption(string, interface{}) error {
-	return nano.ErrBadOption
+func (l *listener) SetOption(n string, v interface{}) error {
+	return l.opts.set(n, v)
 }
 
-func (*listener) GetOption(string) (interface{}, error) {
-	return nil, nano.ErrBadOption
+func (l *listener) GetOption(n string) (interface{}, error) {
+	return l.opts.get(n)
 }
 
 func (l *listener) Close() error {
@@ -237,7 +272,7 @@ func (l *listener) Close() error {
 	listeners.mx.Unlock()
 
 	for _, s := range servers {
-		close(s.closeq)
+		s.Close()
 	}
 
 	return nil
@@ -251,18 +286,43 @@ func (t *inprocTran) NewDialer(addr string, proto nano.Protocol) (nano.PipeDiale
 	if _, err := nano.StripScheme(t, addr); err != nil {
 		return nil, err
 	}
-	return &dialer{addr: addr, proto: proto}, nil
+	return &dialer{addr: addr, proto: proto, opts: newOptions(t), t: t}, nil
 }
 
 func (t *inprocTran) NewListener(addr string, proto nano.Protocol) (nano.PipeListener, error) {
 	if _, err := nano.StripScheme(t, addr); err != nil {
 		return nil, err
 	}
-	l := &listener{addr: addr, proto: proto}
+	l := &listener{addr: addr, proto: proto, opts: newOptions(t), t: t}
 	return l, nil
 }
 
-// NewTransport allocates a new inproc:// transport.
-func NewTransport() nano.Transport {
-	return &inprocTran{}
+var validOpts = map[string]bool{
+	nano.OptionNoHandshake: true,
+	nano.OptionDeflate:     true,
+	nano.OptionSnappy:      true,
+	nano.OptionWriteQLen:   true,
+	nano.OptionMaxRecvSize: true,
+}
+
+// NewTransport allocates a new inproc:// transport.  It accepts the same
+// options as the other transports, so that AddAllOptions can pass them
+// on; as nothing goes over a wire, only nano.OptionWriteQLen has an effect,
+// the others are just reported as pipe properties.
+func NewTransport(opts ...interface{}) nano.Transport {
+	t := &inprocTran{opts: make(map[string]interface{})}
+	if len(opts)%2 != 0 {
+		return nil
+	}
+	for i := 0; i+1 < len(opts); i += 2 {
+		name := opts[i].(string)
+		if _, present := validOpts[name]; !present {
+			// invalid option
+			return nil
+		}
+
+		t.opts[name] = opts[i+1]
+	}
+
+	return t
 }
@@ -3,6 +3,8 @@ package inproc
 import (
 	"testing"
 
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/reqrep"
 	"github.com/funkygao/nano/test"
 )
 
@@ -31,3 +33,113 @@ func TestInpSchem(t *testing.T) {
 func TestInp(t *testing.T) {
 	tt.TranTestAll(t)
 }
+
+func inpPair(t *testing.T, addr string, qlen int) (client, server nano.Pipe) {
+	tran := NewTransport()
+	l, err := tran.NewListener(addr, protoRep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := tran.NewDialer(addr, protoReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.SetOption(nano.OptionWriteQLen, qlen); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var err error
+		if server, err = l.Accept(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if client, err = d.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	l.Close()
+	return
+}
+
+var protoReq = reqrep.NewReqSocket().GetProtocol()
+var protoRep = reqrep.NewRepSocket().GetProtocol()
+
+func TestInpZeroCopy(t *testing.T) {
+	client, server := inpPair(t, "inproc://zerocopy", 1)
+	defer server.Close()
+
+	m := nano.NewMessage(16)
+	m.Header = append(m.Header, 0x80, 0, 0, 1)
+	m.Body = append(m.Body, "hello"...)
+	if err := client.SendMsg(m); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := server.RecvMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != m {
+		t.Error("message was copied")
+	}
+	if len(r.Header) != 0 || string(r.Body) != "\x80\x00\x00\x01hello" {
+		t.Errorf("header not folded into body: %v %q", r.Header, r.Body)
+	}
+
+	// a shared message must not be handed over as is
+	m = nano.NewMessage(16)
+	m.Body = append(m.Body, "world"...)
+	dup := m.Dup()
+	if err = client.SendMsg(m); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ = server.RecvMsg(); r == dup || string(r.Body) != "world" {
+		t.Errorf("shared message handed over: %q", r.Body)
+	}
+
+	// queued messages survive the sender closing
+	m = nano.NewMessage(16)
+	m.Body = append(m.Body, "bye"...)
+	if err = client.SendMsg(m); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if server.IsOpen() {
+		t.Error("peer close not seen")
+	}
+	if r, err = server.RecvMsg(); err != nil || string(r.Body) != "bye" {
+		t.Errorf("queued message lost: %v", err)
+	}
+	if _, err = server.RecvMsg(); err != nano.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err = server.SendMsg(nano.NewMessage(0)); err != nano.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestInpOptions(t *testing.T) {
+	tran := NewTransport(nano.OptionWriteQLen, 8, nano.OptionSnappy, true)
+	d, err := tran.NewDialer("inproc://opts", protoReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.GetOption(nano.OptionWriteQLen); err != nil || v.(int) != 8 {
+		t.Errorf("transport default not applied: %v %v", v, err)
+	}
+	if d.SetOption(nano.OptionWriteQLen, -1) != nano.ErrBadValue {
+		t.Error("negative queue length accepted")
+	}
+	if d.SetOption(nano.OptionNoDelay, true) != nano.ErrBadOption {
+		t.Error("unsupported option accepted")
+	}
+	if NewTransport(nano.OptionNoDelay, true) != nil {
+		t.Error("invalid transport option accepted")
+	}
+}
@@ -0,0 +1,48 @@
+package inproc
+
+import (
+	"github.com/funkygao/nano"
+)
+
+// options is used for shared GetOption/SetOption logic.
+type options map[string]interface{}
+
+func newOptions(t *inprocTran) options {
+	o := make(options)
+	o[nano.OptionWriteQLen] = 0 // unbuffered, a rendezvous
+	if v, ok := t.opts[nano.OptionWriteQLen]; ok {
+		o.set(nano.OptionWriteQLen, v)
+	}
+	return o
+}
+
+func (o options) get(name string) (interface{}, error) {
+	if v, ok := o[name]; !ok {
+		return nil, nano.ErrBadOption
+	} else {
+		return v, nil
+	}
+}
+
+func (o options) set(name string, val interface{}) error {
+	switch name {
+	case nano.OptionWriteQLen:
+		// capacity of the channel towards the peer, in messages
+		switch v := val.(type) {
+		case int:
+			if v < 0 {
+				return nano.ErrBadValue
+			}
+			o[name] = v
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+	}
+
+	return nano.ErrBadOption
+}
+
+func (o options) qlen() int {
+	return o[nano.OptionWriteQLen].(int)
+}
@@ -0,0 +1,12 @@
+// +build windows plan9
+
+package ipc
+
+import (
+	"net"
+)
+
+// newFdConn leaves the connection alone, there is no descriptor passing.
+func newFdConn(c net.Conn, uc *net.UnixConn) net.Conn {
+	return c
+}
@@ -0,0 +1,100 @@
+// +build !windows,!plan9
+
+package ipc
+
+import (
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/funkygao/nano"
+)
+
+// maxFds bounds the descriptors received along with one read.
+const maxFds = 253
+
+// fdConn implements nano.FdConn on a unix socket.  Conn is the connection
+// as handed to the core, possibly wrapped by admission control; the data
+// is read from the socket itself, to collect the descriptors.
+type fdConn struct {
+	net.Conn
+	uc *net.UnixConn
+
+	oob []byte
+	fds []int // received, not taken yet
+	sync.Mutex
+}
+
+func newFdConn(c net.Conn, uc *net.UnixConn) net.Conn {
+	return &fdConn{
+		Conn: c,
+		uc:   uc,
+		oob:  make([]byte, syscall.CmsgSpace(maxFds*4)),
+	}
+}
+
+func (this *fdConn) Read(b []byte) (int, error) {
+	n, oobn, _, _, err := this.uc.ReadMsgUnix(b, this.oob)
+	if oobn > 0 {
+		this.collect(this.oob[:oobn])
+	}
+	if n < 0 {
+		// recvmsg failed, io.Reader must not see it
+		n = 0
+	}
+	return n, err
+}
+
+func (this *fdConn) collect(oob []byte) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		nano.Debugf("%v", err)
+		return
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	for i := range msgs {
+		if msgs[i].Header.Level != syscall.SOL_SOCKET ||
+			msgs[i].Header.Type != syscall.SCM_RIGHTS {
+			continue
+		}
+		if fds, err := syscall.ParseUnixRights(&msgs[i]); err == nil {
+			this.fds = append(this.fds, fds...)
+		}
+	}
+}
+
+// WriteFds implements the nano.FdConn WriteFds method.
+func (this *fdConn) WriteFds(b []byte, fds []int) (int, error) {
+	n, _, err := this.uc.WriteMsgUnix(b, syscall.UnixRights(fds...), nil)
+	if err == nil && n < len(b) {
+		var m int
+		m, err = this.uc.Write(b[n:])
+		n += m
+	}
+	return n, err
+}
+
+// TakeFds implements the nano.FdConn TakeFds method.
+func (this *fdConn) TakeFds(n int) ([]int, error) {
+	this.Lock()
+	defer this.Unlock()
+	if len(this.fds) < n {
+		return nil, nano.ErrFdPassing
+	}
+	fds := append([]int(nil), this.fds[:n]...)
+	this.fds = this.fds[n:]
+	return fds, nil
+}
+
+// Close closes the descriptors nobody took along with the connection.
+func (this *fdConn) Close() error {
+	this.Lock()
+	for _, fd := range this.fds {
+		syscall.Close(fd)
+	}
+	this.fds = nil
+	this.Unlock()
+	return this.Conn.Close()
+}
@@ -0,0 +1,134 @@
+// +build linux
+
+package ipc
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pair"
+)
+
+func TestIpcPeerCredAndFds(t *testing.T) {
+	addr := "ipc://" + filepath.Join(t.TempDir(), "fds.sock")
+	proto := pair.NewSocket().GetProtocol()
+	tran := NewTransport()
+
+	l, err := tran.NewListener(addr, proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	d, _ := tran.NewDialer(addr, proto)
+	clients := make(chan nano.Pipe, 1)
+	go func() {
+		c, err := d.Dial()
+		if err != nil {
+			t.Error(err)
+		}
+		clients <- c
+	}()
+
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := <-clients
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	for _, p := range []nano.Pipe{server, client} {
+		uid, err := p.GetProp(nano.PropPeerUid)
+		if err != nil || uid.(int) != os.Getuid() {
+			t.Errorf("peer uid: %v %v", uid, err)
+		}
+		pid, _ := p.GetProp(nano.PropPeerPid)
+		if pid != os.Getpid() {
+			t.Errorf("peer pid: %v", pid)
+		}
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "passed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// a plain message, then one with a descriptor
+	m := nano.NewMessage(8)
+	m.Body = append(m.Body, "plain"...)
+	if err = client.SendMsg(m); err != nil {
+		t.Fatal(err)
+	}
+	m = nano.NewMessage(8)
+	m.Body = append(m.Body, "file"...)
+	m.Fds = []int{int(f.Fd())}
+	if err = client.SendMsg(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if r, err := server.RecvMsg(); err != nil || string(r.Body) != "plain" || len(r.Fds) != 0 {
+		t.Fatalf("plain message: %v", err)
+	}
+	r, err := server.RecvMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Body) != "file" || len(r.Fds) != 1 {
+		t.Fatalf("got %q with %d fds", r.Body, len(r.Fds))
+	}
+
+	// the received descriptor refers to the same file
+	got := os.NewFile(uintptr(r.Fds[0]), "received")
+	defer got.Close()
+	if _, err = got.WriteString("hello"); err != nil {
+		t.Fatal(err)
+	}
+	var st1, st2 syscall.Stat_t
+	syscall.Fstat(int(f.Fd()), &st1)
+	syscall.Fstat(r.Fds[0], &st2)
+	if st1.Ino != st2.Ino || st2.Size != 5 {
+		t.Errorf("different file: %v %v", st1.Ino, st2.Ino)
+	}
+}
+
+func TestIpcFdsCompressed(t *testing.T) {
+	addr := "ipc://" + filepath.Join(t.TempDir(), "snappy.sock")
+	proto := pair.NewSocket().GetProtocol()
+	tran := NewTransport(nano.OptionSnappy, true)
+
+	l, _ := tran.NewListener(addr, proto)
+	if err := l.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if p, err := l.Accept(); err == nil {
+			p.RecvMsg()
+			p.Close()
+		}
+	}()
+
+	d, _ := tran.NewDialer(addr, proto)
+	c, err := d.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	m := nano.NewMessage(0)
+	m.Fds = []int{0}
+	if err = c.SendMsg(m); err != nano.ErrFdPassing {
+		t.Errorf("expected ErrFdPassing, got %v", err)
+	}
+}
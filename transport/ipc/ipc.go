@@ -2,7 +2,14 @@
 package ipc
 
 import (
+	"errors"
 	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/funkygao/nano"
 )
@@ -22,11 +29,96 @@ func (o options) get(name string) (interface{}, error) {
 	}
 }
 
-// SetOption sets an option.  We have none, so just ErrBadOption.
-func (o options) set(string, interface{}) error {
+// SetOption sets an option.
+func (o options) set(name string, val interface{}) error {
+	if nano.IsPipeOption(name) {
+		return nano.SetPipeOption(o, name, val)
+	}
+
+	switch name {
+	case nano.OptionIpcMode:
+		switch v := val.(type) {
+		case os.FileMode:
+			o[name] = v.Perm()
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+
+	case nano.OptionListenFd:
+		switch val.(type) {
+		case int, *os.File:
+			o[name] = val
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+
+	case nano.OptionIpcOwner:
+		switch v := val.(type) {
+		case string:
+			if _, _, err := lookupOwner(v); err != nil {
+				return nano.ErrBadValue
+			}
+			o[name] = v
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+	}
+
 	return nano.ErrBadOption
 }
 
+// lookupOwner resolves "user[:group]" into ids, -1 for the group if
+// omitted so that chown leaves it alone.
+func lookupOwner(owner string) (uid, gid int, err error) {
+	uname, gname := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		uname, gname = owner[:i], owner[i+1:]
+	}
+
+	var u *user.User
+	if u, err = user.Lookup(uname); err != nil {
+		if u, err = user.LookupId(uname); err != nil {
+			return
+		}
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return
+	}
+
+	gid = -1
+	if gname == "" {
+		return
+	}
+	var g *user.Group
+	if g, err = user.LookupGroup(gname); err != nil {
+		if g, err = user.LookupGroupId(gname); err != nil {
+			return
+		}
+	}
+	gid, err = strconv.Atoi(g.Gid)
+	return
+}
+
+// isAbstract returns true for Linux abstract namespace addresses, which
+// have no file in the filesystem.
+func isAbstract(addr *net.UnixAddr) bool {
+	return strings.HasPrefix(addr.Name, "@")
+}
+
+func resolveAddr(addr string) (*net.UnixAddr, error) {
+	a, err := net.ResolveUnixAddr("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	if isAbstract(a) && !abstractSupported {
+		return nil, nano.ErrBadAddr
+	}
+	return a, nil
+}
+
 type dialer struct {
 	t     *ipcTran
 	addr  *net.UnixAddr
@@ -41,8 +133,8 @@ func (d *dialer) Dial() (nano.Pipe, error) {
 		return nil, err
 	}
 
-	return nano.NewConnPipeIPC(conn, d.proto,
-		nano.FlattenOptions(d.t.opts)...)
+	props := append(nano.PipeProps(d.t.opts, d.opts), peerCredProps(conn)...)
+	return nano.NewConnPipeIPC(newFdConn(conn, conn), d.proto, props...)
 }
 
 // SetOption implements a stub PipeDialer SetOption method.
@@ -61,14 +153,104 @@ type listener struct {
 	proto    nano.Protocol
 	listener *net.UnixListener
 	opts     options
+	gate     nano.ConnGate
 }
 
 // Listen implements the PipeListener Listen method.
 func (l *listener) Listen() error {
-	if listener, err := net.ListenUnix("unix", l.addr); err != nil {
+	if v, ok := l.opts[nano.OptionListenFd]; ok {
+		nl, err := nano.FileListener(v)
+		if err != nil {
+			return err
+		}
+		return l.adopt(nl)
+	}
+	if nl := nano.TakeListenFd(func(a net.Addr) bool {
+		return nano.SameListenAddr(a, l.addr)
+	}); nl != nil {
+		nano.Debugf("adopted inherited %v", nl.Addr())
+		return l.adopt(nl)
+	}
+
+	if !isAbstract(l.addr) {
+		if err := removeStale(l.addr); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.ListenUnix("unix", l.addr)
+	if err != nil {
 		return err
-	} else {
-		l.listener = listener
+	}
+
+	if !isAbstract(l.addr) {
+		if err = l.configFile(); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	l.listener = listener
+	return nil
+}
+
+// adopt takes over an open listening socket, whose file is left as is.
+func (l *listener) adopt(nl net.Listener) error {
+	ul, ok := nl.(*net.UnixListener)
+	if !ok {
+		nl.Close()
+		return nano.ErrBadValue
+	}
+	l.listener = ul
+	return nil
+}
+
+// removeStale removes the socket file left behind by a process that
+// crashed without closing its listener.  A socket still being listened
+// on, or a file that is no socket, is left alone.
+func removeStale(addr *net.UnixAddr) error {
+	fi, err := os.Lstat(addr.Name)
+	if err != nil {
+		// nothing there, or let bind tell what is wrong
+		return nil
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return nano.ErrAddrInUse
+	}
+
+	conn, err := net.DialTimeout("unix", addr.Name, time.Second)
+	if err == nil {
+		conn.Close()
+		return nano.ErrAddrInUse
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nano.ErrAddrInUse
+	}
+
+	nano.Debugf("remove stale %s", addr.Name)
+	if err = os.Remove(addr.Name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// configFile applies the mode and owner options to the socket file.
+// There is a short window after bind during which it has the default
+// permissions; put the socket in a private directory if that matters.
+func (l *listener) configFile() error {
+	if v, ok := l.opts[nano.OptionIpcMode]; ok {
+		if err := os.Chmod(l.addr.Name, v.(os.FileMode)); err != nil {
+			return err
+		}
+	}
+	if v, ok := l.opts[nano.OptionIpcOwner]; ok {
+		uid, gid, err := lookupOwner(v.(string))
+		if err != nil {
+			return err
+		}
+		if err = os.Chown(l.addr.Name, uid, gid); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -80,16 +262,45 @@ func (l *listener) Accept() (nano.Pipe, error) {
 		return nil, err
 	}
 
-	return nano.NewConnPipeIPC(conn, l.proto,
-		nano.FlattenOptions(l.t.opts)...)
+	var c net.Conn = conn
+	if l.gate != nil {
+		// reject before handshake
+		if c, err = l.gate.Admit(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	props := append(nano.PipeProps(l.t.opts, l.opts), peerCredProps(conn)...)
+	return nano.NewConnPipeIPC(newFdConn(c, conn), l.proto, props...)
 }
 
 // Close implements the PipeListener Close method.
 func (l *listener) Close() error {
-	l.listener.Close()
+	if l.listener != nil {
+		l.listener.Close()
+	}
 	return nil
 }
 
+// Files implements the FilePipeListener Files method.
+func (l *listener) Files() ([]*os.File, error) {
+	if l.listener == nil {
+		return nil, nano.ErrClosed
+	}
+	f, err := nano.ListenerFile(l.listener, "ipc:"+l.addr.String())
+	if err != nil {
+		return nil, err
+	}
+	// the file now belongs to whoever takes over
+	l.listener.SetUnlinkOnClose(false)
+	return []*os.File{f}, nil
+}
+
+// SetGate implements the GatedPipeListener SetGate method.
+func (l *listener) SetGate(gate nano.ConnGate) {
+	l.gate = gate
+}
+
 // SetOption implements a stub PipeListener SetOption method.
 func (l *listener) SetOption(n string, v interface{}) error {
 	return l.opts.set(n, v)
@@ -117,8 +328,8 @@ func (t *ipcTran) NewDialer(addr string, proto nano.Protocol) (nano.PipeDialer,
 		return nil, err
 	}
 
-	d := &dialer{t: t, proto: proto, opts: nil}
-	if d.addr, err = net.ResolveUnixAddr("unix", addr); err != nil {
+	d := &dialer{t: t, proto: proto, opts: make(options)}
+	if d.addr, err = resolveAddr(addr); err != nil {
 		return nil, err
 	}
 	return d, nil
@@ -127,13 +338,13 @@ func (t *ipcTran) NewDialer(addr string, proto nano.Protocol) (nano.PipeDialer,
 // NewListener implements the Transport NewListener method.
 func (t *ipcTran) NewListener(addr string, proto nano.Protocol) (nano.PipeListener, error) {
 	var err error
-	l := &listener{t: t, proto: proto}
+	l := &listener{t: t, proto: proto, opts: make(options)}
 
 	if addr, err = nano.StripScheme(t, addr); err != nil {
 		return nil, err
 	}
 
-	if l.addr, err = net.ResolveUnixAddr("unix", addr); err != nil {
+	if l.addr, err = resolveAddr(addr); err != nil {
 		return nil, err
 	}
 
@@ -144,6 +355,11 @@ var validOpts = map[string]bool{
 	nano.OptionNoHandshake: true,
 	nano.OptionDeflate:     true,
 	nano.OptionSnappy:      true,
+	nano.OptionMaxRecvSize: true,
+}
+
+func init() {
+	nano.RegisterTransport(NewTransport())
 }
 
 // NewTransport allocates a new IPC transport.
@@ -0,0 +1,89 @@
+// +build linux darwin freebsd
+
+package ipc
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/reqrep"
+	"github.com/funkygao/nano/test"
+)
+
+var protoRep = reqrep.NewRepSocket().GetProtocol()
+
+func TestIpcStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+
+	// a crashed process leaves its socket file behind
+	ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ul.SetUnlinkOnClose(false)
+	ul.Close()
+
+	l, err := NewTransport().NewListener("ipc://"+path, protoRep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatalf("stale socket not removed: %v", err)
+	}
+	l.Close()
+
+	// anything else is not ours to remove
+	os.WriteFile(path, []byte("data"), 0600)
+	l, _ = NewTransport().NewListener("ipc://"+path, protoRep)
+	if err = l.Listen(); err != nano.ErrAddrInUse {
+		t.Errorf("expected ErrAddrInUse, got %v", err)
+	}
+}
+
+func TestIpcFileOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mode.sock")
+	l, err := NewTransport().NewListener("ipc://"+path, protoRep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.SetOption(nano.OptionIpcMode, 0600) != nano.ErrBadValue {
+		t.Error("int mode accepted")
+	}
+	if l.SetOption(nano.OptionIpcOwner, "no-such-user-here") != nano.ErrBadValue {
+		t.Error("unknown owner accepted")
+	}
+	if err = l.SetOption(nano.OptionIpcMode, os.FileMode(0600)); err != nil {
+		t.Fatal(err)
+	}
+	owner := strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid())
+	if err = l.SetOption(nano.OptionIpcOwner, owner); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("mode %v", fi.Mode())
+	}
+}
+
+func TestIpcAbstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := NewTransport().NewListener("ipc://@nano", protoRep); err != nano.ErrBadAddr {
+			t.Errorf("expected ErrBadAddr, got %v", err)
+		}
+		return
+	}
+	test.NewTranTest(NewTransport(), "ipc://@nano-test-abstract").TranTestAll(t)
+}
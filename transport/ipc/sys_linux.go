@@ -0,0 +1,38 @@
+// +build linux
+
+package ipc
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/funkygao/nano"
+)
+
+// abstractSupported tells if ipc://@name addresses are available.
+const abstractSupported = true
+
+// peerCredProps returns the SO_PEERCRED credentials of the peer as pipe
+// properties.
+func peerCredProps(uc *net.UnixConn) []interface{} {
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd),
+			syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		nano.Debugf("SO_PEERCRED: %v", err)
+		return nil
+	}
+
+	return []interface{}{
+		nano.PropPeerPid, int(cred.Pid),
+		nano.PropPeerUid, int(cred.Uid),
+		nano.PropPeerGid, int(cred.Gid),
+	}
+}
@@ -0,0 +1,14 @@
+// +build !linux
+
+package ipc
+
+import (
+	"net"
+)
+
+const abstractSupported = false
+
+// peerCredProps has no peer credentials to offer.
+func peerCredProps(uc *net.UnixConn) []interface{} {
+	return nil
+}
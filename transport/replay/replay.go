@@ -0,0 +1,302 @@
+// Package replay implements a transport for nano that plays a capture,
+// recorded with the capture package, back as a fake peer.
+//
+// The address is the path of the capture, e.g. replay:///tmp/sub.cap.
+// Of the captured frames, the pipes play those that were exchanged from
+// a peer protocol to the local one, whichever side they were recorded on:
+// a SUB dialing a capture made at either its PUB or another SUB receives
+// what that SUB received.  Each captured endpoint is played by a pipe of
+// its own, returned by successive dials or accepts.  Messages sent to the
+// fake peer are discarded.
+package replay
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/transport/capture"
+)
+
+type replayTran struct{}
+
+func init() {
+	nano.RegisterTransport(NewTransport())
+}
+
+// NewTransport allocates a new replay:// transport.
+func NewTransport() nano.Transport {
+	return &replayTran{}
+}
+
+func (t *replayTran) Scheme() string {
+	return "replay"
+}
+
+func (t *replayTran) NewDialer(addr string, proto nano.Protocol) (nano.PipeDialer, error) {
+	path, err := nano.StripScheme(t, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &dialer{player: newPlayer(path, proto)}, nil
+}
+
+func (t *replayTran) NewListener(addr string, proto nano.Protocol) (nano.PipeListener, error) {
+	path, err := nano.StripScheme(t, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{player: newPlayer(path, proto),
+		closeq: make(chan struct{})}, nil
+}
+
+// player hands out the captured endpoints as pipes, one after another.
+type player struct {
+	path    string
+	proto   nano.Protocol
+	speed   float64
+	streams [][]*capture.Frame
+	loaded  bool
+	sync.Mutex
+}
+
+func newPlayer(path string, proto nano.Protocol) *player {
+	return &player{path: path, proto: proto, speed: 1}
+}
+
+// load reads the frames towards proto, grouped by endpoint in order of
+// appearance.
+func (this *player) load() error {
+	frames, err := capture.ReadFile(this.path)
+	if err != nil {
+		return err
+	}
+
+	index := make(map[nano.EndpointId]int)
+	for _, f := range frames {
+		from, to := f.LocalProto, f.RemoteProto
+		if f.Dir == capture.Received {
+			from, to = to, from
+		}
+		if from != this.proto.PeerNumber() || to != this.proto.Number() {
+			continue
+		}
+
+		i, present := index[f.Endpoint]
+		if !present {
+			i = len(this.streams)
+			index[f.Endpoint] = i
+			this.streams = append(this.streams, nil)
+		}
+		this.streams[i] = append(this.streams[i], f)
+	}
+	this.loaded = true
+	return nil
+}
+
+// next returns the pipe of the next endpoint, or nil if all were played.
+func (this *player) next() (*pipe, error) {
+	this.Lock()
+	defer this.Unlock()
+	if !this.loaded {
+		if err := this.load(); err != nil {
+			return nil, err
+		}
+	}
+	if len(this.streams) == 0 {
+		return nil, nil
+	}
+
+	p := newPipe(this.streams[0], this.proto, this.speed)
+	this.streams = this.streams[1:]
+	return p, nil
+}
+
+func (this *player) setOption(name string, v interface{}) error {
+	switch name {
+	case nano.OptionReplaySpeed:
+		speed, ok := v.(float64)
+		if !ok || speed < 0 {
+			return nano.ErrBadValue
+		}
+		this.Lock()
+		this.speed = speed
+		this.Unlock()
+		return nil
+	}
+	return nano.ErrBadOption
+}
+
+func (this *player) getOption(name string) (interface{}, error) {
+	switch name {
+	case nano.OptionReplaySpeed:
+		this.Lock()
+		defer this.Unlock()
+		return this.speed, nil
+	}
+	return nil, nano.ErrBadOption
+}
+
+// dialer implements the nano.PipeDialer interface.
+type dialer struct {
+	*player
+}
+
+// Dial plays the next captured endpoint.  Once all were played, the
+// connection is refused.
+func (this *dialer) Dial() (nano.Pipe, error) {
+	p, err := this.next()
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, nano.ErrConnRefused
+	}
+	return p, nil
+}
+
+func (this *dialer) SetOption(name string, v interface{}) error {
+	return this.setOption(name, v)
+}
+
+func (this *dialer) GetOption(name string) (interface{}, error) {
+	return this.getOption(name)
+}
+
+// listener implements the nano.PipeListener interface.
+type listener struct {
+	*player
+	closeq chan struct{}
+	once   sync.Once
+}
+
+func (this *listener) Listen() error {
+	this.Lock()
+	defer this.Unlock()
+	return this.load()
+}
+
+// Accept plays the next captured endpoint.  Once all were played, it
+// waits for the listener to close.
+func (this *listener) Accept() (nano.Pipe, error) {
+	p, err := this.next()
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		<-this.closeq
+		return nil, nano.ErrClosed
+	}
+	return p, nil
+}
+
+func (this *listener) Close() error {
+	this.once.Do(func() {
+		close(this.closeq)
+	})
+	return nil
+}
+
+func (this *listener) SetOption(name string, v interface{}) error {
+	return this.setOption(name, v)
+}
+
+func (this *listener) GetOption(name string) (interface{}, error) {
+	return this.getOption(name)
+}
+
+// pipe implements the nano.Pipe interface, playing one endpoint.
+type pipe struct {
+	frames []*capture.Frame
+	proto  nano.Protocol
+	speed  float64
+	start  time.Time // when the first frame was played
+	first  time.Time // when the first frame was captured
+	closeq chan struct{}
+	once   sync.Once
+}
+
+func newPipe(frames []*capture.Frame, proto nano.Protocol, speed float64) *pipe {
+	return &pipe{
+		frames: frames,
+		proto:  proto,
+		speed:  speed,
+		start:  time.Now(),
+		first:  frames[0].Time,
+		closeq: make(chan struct{}),
+	}
+}
+
+// RecvMsg returns the next frame when it is due.  After the last one,
+// it waits for the pipe to close.
+func (this *pipe) RecvMsg() (*nano.Message, error) {
+	if len(this.frames) == 0 {
+		<-this.closeq
+		return nil, nano.ErrClosed
+	}
+
+	f := this.frames[0]
+	if this.speed > 0 {
+		offset := f.Time.Sub(this.first)
+		due := this.start.Add(time.Duration(float64(offset) / this.speed))
+		select {
+		case <-time.After(due.Sub(time.Now())):
+		case <-this.closeq:
+			return nil, nano.ErrClosed
+		}
+	} else {
+		select {
+		case <-this.closeq:
+			return nil, nano.ErrClosed
+		default:
+		}
+	}
+	this.frames = this.frames[1:]
+
+	// as received from the wire, headers still in the body
+	m := nano.NewMessage(len(f.Header) + len(f.Body))
+	m.Body = append(m.Body, f.Header...)
+	m.Body = append(m.Body, f.Body...)
+	return m, nil
+}
+
+// SendMsg discards the message, nobody is listening.
+func (this *pipe) SendMsg(m *nano.Message) error {
+	m.Free()
+	if !this.IsOpen() {
+		return nano.ErrClosed
+	}
+	return nil
+}
+
+func (this *pipe) Flush() error {
+	return nil
+}
+
+func (this *pipe) Close() error {
+	this.once.Do(func() {
+		close(this.closeq)
+	})
+	return nil
+}
+
+func (this *pipe) IsOpen() bool {
+	select {
+	case <-this.closeq:
+		return false
+	default:
+		return true
+	}
+}
+
+func (this *pipe) LocalProtocol() uint16 {
+	return this.proto.Number()
+}
+
+func (this *pipe) RemoteProtocol() uint16 {
+	return this.proto.PeerNumber()
+}
+
+func (this *pipe) GetProp(name string) (interface{}, error) {
+	return nil, nano.ErrBadProperty
+}
@@ -0,0 +1,93 @@
+package replay
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pubsub"
+	"github.com/funkygao/nano/transport/capture"
+	"github.com/funkygao/nano/transport/inproc"
+)
+
+// record captures a PUB fanning out to two SUBs, at the PUB.
+func record(t *testing.T, gap time.Duration) string {
+	path := filepath.Join(t.TempDir(), "pub.cap")
+	rec, err := capture.Create(path)
+	assert.Equal(t, nil, err)
+
+	pub := pubsub.NewPubSocket()
+	defer pub.Close()
+	pub.AddTransport(rec.Wrap(inproc.NewTransport()))
+	assert.Equal(t, nil, pub.Listen("inproc://replay"))
+	for i := 0; i < 2; i++ {
+		sub := pubsub.NewSubSocket()
+		defer sub.Close()
+		sub.SetOption(nano.OptionSubscribe, []byte(""))
+		assert.Equal(t, nil, sub.Dial("inproc://replay"))
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	for _, s := range []string{"a", "b", "c"} {
+		assert.Equal(t, nil, pub.Send([]byte(s)))
+		time.Sleep(gap)
+	}
+	time.Sleep(50 * time.Millisecond) // sent by then
+	assert.Equal(t, nil, rec.Close())
+	return path
+}
+
+func recvAll(t *testing.T, sub nano.Socket, n int) string {
+	var got string
+	for i := 0; i < n; i++ {
+		m, err := sub.Recv()
+		assert.Equal(t, nil, err)
+		got += string(m)
+	}
+	return got
+}
+
+func TestReplayDial(t *testing.T) {
+	path := record(t, 0)
+
+	sub := pubsub.NewSubSocket()
+	defer sub.Close()
+	sub.SetOption(nano.OptionSubscribe, []byte(""))
+	sub.SetOption(nano.OptionRecvDeadline, time.Second)
+	d, err := sub.NewDialer("replay://"+path, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, d.SetOption(nano.OptionReplaySpeed, float64(0)))
+	assert.Equal(t, nil, d.Dial())
+
+	// one fake PUB connection per SUB captured
+	assert.Equal(t, "abc", recvAll(t, sub, 3))
+}
+
+func TestReplayListenSpeed(t *testing.T) {
+	path := record(t, 100*time.Millisecond)
+
+	sub := pubsub.NewSubSocket()
+	defer sub.Close()
+	sub.SetOption(nano.OptionSubscribe, []byte(""))
+	sub.SetOption(nano.OptionRecvDeadline, time.Second)
+	l, err := sub.NewListener("replay://"+path, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, l.SetOption(nano.OptionReplaySpeed, float64(2)))
+	assert.Equal(t, nil, l.Listen())
+
+	// both captured endpoints at once, in half the time
+	start := time.Now()
+	assert.Equal(t, "aabbcc", sortString(recvAll(t, sub, 6)))
+	elapsed := time.Since(start)
+	assert.Equal(t, true, elapsed >= 90*time.Millisecond)
+	assert.Equal(t, true, elapsed < 190*time.Millisecond)
+}
+
+func sortString(s string) string {
+	b := []byte(s)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	return string(b)
+}
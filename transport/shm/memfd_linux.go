@@ -0,0 +1,83 @@
+// +build linux
+
+package shm
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// memfd_create(2) and file sealing, which the syscall package lacks.
+const (
+	mfdCloexec       = 0x1
+	mfdAllowSealing  = 0x2
+	fcntlAddSeals    = 1033
+	fcntlGetSeals    = 1034
+	sealSeal         = 0x1
+	sealShrink       = 0x2
+	sealGrow         = 0x4
+	segmentSeals     = sealShrink | sealGrow // what takeOver requires
+	segmentSealsLock = segmentSeals | sealSeal
+)
+
+// sysMemfdCreate returns the number of memfd_create on this
+// architecture, or 0 if it is not known.
+func sysMemfdCreate() uintptr {
+	switch runtime.GOARCH {
+	case "amd64":
+		return 319
+	case "386":
+		return 356
+	case "arm":
+		return 385
+	case "arm64", "loong64", "riscv64":
+		return 279
+	case "ppc64", "ppc64le":
+		return 360
+	case "s390x":
+		return 350
+	case "mips", "mipsle":
+		return 4354
+	case "mips64", "mips64le":
+		return 5314
+	}
+	return 0
+}
+
+// memfdCreate returns an anonymous file in memory, which can be sealed.
+func memfdCreate(name string) (*os.File, error) {
+	nr := sysMemfdCreate()
+	if nr == 0 {
+		return nil, syscall.ENOSYS
+	}
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	fd, _, errno := syscall.Syscall(nr, uintptr(unsafe.Pointer(p)),
+		mfdCloexec|mfdAllowSealing, 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("memfd_create", errno)
+	}
+	return os.NewFile(fd, name), nil
+}
+
+func addSeals(fd uintptr, seals int) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, fcntlAddSeals,
+		uintptr(seals))
+	if errno != 0 {
+		return os.NewSyscallError("fcntl", errno)
+	}
+	return nil
+}
+
+func getSeals(fd int) (int, error) {
+	seals, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd),
+		fcntlGetSeals, 0)
+	if errno != 0 {
+		return 0, os.NewSyscallError("fcntl", errno)
+	}
+	return int(seals), nil
+}
@@ -0,0 +1,326 @@
+// +build linux
+
+package shm
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/funkygao/nano"
+)
+
+// spins is how many times a side polls a ring before it sleeps on an
+// eventfd.  As long as the peer keeps up, no syscall is made.
+const spins = 64
+
+// pipe implements the nano.Pipe interface over a pair of rings.
+type pipe struct {
+	conn net.Conn // the rendezvous connection, only watched for EOF
+	mem  []byte
+
+	tx      *ring
+	txData  *os.File // signaled when tx got data, the peer sleeps on it
+	txSpace *os.File // signaled when tx got room, we sleep on it
+	rx      *ring
+	rxData  *os.File
+	rxSpace *os.File
+	efds    []*os.File
+
+	proto   nano.Protocol
+	props   map[string]interface{}
+	maxRecv int64
+
+	rlock  sync.Mutex
+	wlock  sync.Mutex
+	closed int32
+	gone   int32 // the peer is
+	once   sync.Once
+}
+
+// newPipe runs the SP handshake over the rings in mem, the first one of
+// which carries what the dialer sends.  The pipe takes over conn, mem and
+// efds, also on failure.
+func newPipe(conn net.Conn, mem []byte, size int, efds []*os.File,
+	dialer bool, proto nano.Protocol, opts options, props []interface{}) (nano.Pipe, error) {
+	span := ringSpan(size)
+	this := &pipe{
+		conn:    conn,
+		mem:     mem,
+		efds:    efds,
+		proto:   proto,
+		props:   make(map[string]interface{}),
+		maxRecv: 1 << 20,
+	}
+	out, in := newRing(mem[:span]), newRing(mem[span:2*span])
+	if dialer {
+		this.tx, this.txData, this.txSpace = out, efds[0], efds[1]
+		this.rx, this.rxData, this.rxSpace = in, efds[2], efds[3]
+	} else {
+		this.tx, this.txData, this.txSpace = in, efds[2], efds[3]
+		this.rx, this.rxData, this.rxSpace = out, efds[0], efds[1]
+	}
+
+	this.props[nano.PropLocalAddr] = conn.LocalAddr()
+	this.props[nano.PropRemoteAddr] = conn.RemoteAddr()
+	for i := 0; i+1 < len(props); i += 2 {
+		this.props[props[i].(string)] = props[i+1]
+	}
+	if v, ok := opts[nano.OptionMaxRecvSize]; ok {
+		this.maxRecv = int64(v.(int))
+	}
+
+	go this.watch()
+
+	if err := this.handshake(); err != nil {
+		this.Close()
+		return nil, err
+	}
+	return this, nil
+}
+
+// handshake exchanges the SP headers, see connPipe.
+func (this *pipe) handshake() error {
+	var header [8]byte
+	header[1], header[2] = 'S', 'P'
+	binary.BigEndian.PutUint16(header[4:], this.proto.Number())
+
+	this.wlock.Lock()
+	err := this.writeAll(header[:])
+	this.wlock.Unlock()
+	if err != nil {
+		return err
+	}
+	nano.Debugf("send header: %v", header)
+
+	this.rlock.Lock()
+	err = this.readFull(header[:])
+	this.rlock.Unlock()
+	if err != nil {
+		return err
+	}
+	nano.Debugf("recv header: %v", header)
+
+	if header[0] != 0 || header[1] != 'S' || header[2] != 'P' ||
+		header[6] != 0 || header[7] != 0 {
+		return nano.ErrBadHeader
+	}
+	if header[3] != 0 {
+		return nano.ErrBadVersion
+	}
+	if binary.BigEndian.Uint16(header[4:]) != this.proto.PeerNumber() {
+		return nano.ErrBadProto
+	}
+	return nil
+}
+
+// watch waits for the peer to go, and wakes up whoever waits for it.
+func (this *pipe) watch() {
+	var b [1]byte
+	for {
+		if _, err := this.conn.Read(b[:]); err != nil {
+			break
+		}
+	}
+
+	atomic.StoreInt32(&this.gone, 1)
+	signal(this.rxData)
+	signal(this.txSpace)
+}
+
+func (this *pipe) canRecv() bool {
+	return this.rx.readable() > 0
+}
+
+func (this *pipe) canSend() bool {
+	return this.tx.writable() > 0
+}
+
+// wait returns once ready, or with an error if the pipe closed or the
+// peer is gone.  flag asks the peer to signal efd.
+func (this *pipe) wait(ready func() bool, flag *uint32, efd *os.File) error {
+	for i := 0; i < spins; i++ {
+		if ready() {
+			return nil
+		}
+		runtime.Gosched()
+	}
+
+	var b [8]byte
+	for {
+		atomic.StoreUint32(flag, 1)
+		if ready() {
+			atomic.StoreUint32(flag, 0)
+			return nil
+		}
+		if atomic.LoadInt32(&this.closed) != 0 {
+			return nano.ErrClosed
+		}
+		if atomic.LoadInt32(&this.gone) != 0 {
+			return io.EOF
+		}
+		if _, err := efd.Read(b[:]); err != nil {
+			return nano.ErrClosed
+		}
+	}
+}
+
+// wake signals efd if the peer sleeps on flag.
+func wake(flag *uint32, efd *os.File) {
+	if atomic.LoadUint32(flag) != 0 && atomic.CompareAndSwapUint32(flag, 1, 0) {
+		signal(efd)
+	}
+}
+
+// signal adds one to an eventfd.
+func signal(efd *os.File) {
+	one := uint64(1)
+	efd.Write((*[8]byte)(unsafe.Pointer(&one))[:])
+}
+
+// readFull must be called with rlock held.
+func (this *pipe) readFull(b []byte) error {
+	for len(b) > 0 {
+		n := this.rx.read(b)
+		if n == 0 {
+			if err := this.wait(this.canRecv, this.rx.rwait, this.rxData); err != nil {
+				return err
+			}
+			continue
+		}
+		b = b[n:]
+		wake(this.rx.wwait, this.rxSpace)
+	}
+	return nil
+}
+
+// writeAll must be called with wlock held.
+func (this *pipe) writeAll(b []byte) error {
+	for len(b) > 0 {
+		n := this.tx.write(b)
+		if n == 0 {
+			if err := this.wait(this.canSend, this.tx.wwait, this.txSpace); err != nil {
+				if err == io.EOF {
+					err = nano.ErrClosed
+				}
+				return err
+			}
+			continue
+		}
+		b = b[n:]
+		wake(this.tx.rwait, this.txData)
+	}
+	return nil
+}
+
+// SendMsg implements the Pipe SendMsg method.  The message is framed as
+// by connPipe, a 64-bit size followed by the message itself.
+func (this *pipe) SendMsg(msg *nano.Message) error {
+	var sz [8]byte
+	binary.BigEndian.PutUint64(sz[:], uint64(len(msg.Header)+len(msg.Body)))
+
+	this.wlock.Lock()
+	err := nano.ErrClosed
+	if atomic.LoadInt32(&this.closed) == 0 {
+		if err = this.writeAll(sz[:]); err == nil {
+			if err = this.writeAll(msg.Header); err == nil {
+				err = this.writeAll(msg.Body)
+			}
+		}
+	}
+	this.wlock.Unlock()
+	msg.Free()
+
+	if err != nil {
+		this.Close()
+	}
+	return err
+}
+
+// RecvMsg implements the Pipe RecvMsg method.
+func (this *pipe) RecvMsg() (*nano.Message, error) {
+	msg, err := this.recvMsg()
+	if err != nil {
+		this.Close()
+	}
+	return msg, err
+}
+
+func (this *pipe) recvMsg() (*nano.Message, error) {
+	var b [8]byte
+
+	this.rlock.Lock()
+	defer this.rlock.Unlock()
+	if atomic.LoadInt32(&this.closed) != 0 {
+		return nil, nano.ErrClosed
+	}
+
+	if err := this.readFull(b[:]); err != nil {
+		return nil, err
+	}
+	sz := int64(binary.BigEndian.Uint64(b[:]))
+	if sz > this.maxRecv || sz < 0 {
+		return nil, nano.ErrTooLong
+	}
+
+	msg := nano.NewMessage(int(sz))
+	msg.Body = msg.Body[0:sz]
+	if err := this.readFull(msg.Body); err != nil {
+		msg.Free()
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Flush implements the Pipe Flush method, messages are never buffered.
+func (this *pipe) Flush() error {
+	return nil
+}
+
+// Close implements the Pipe Close method.  What was sent before is still
+// received by the peer.
+func (this *pipe) Close() error {
+	this.once.Do(func() {
+		atomic.StoreInt32(&this.closed, 1)
+		this.conn.Close()
+		for _, f := range this.efds {
+			f.Close()
+		}
+
+		// unmap once no send or receive is copying
+		this.rlock.Lock()
+		this.wlock.Lock()
+		syscall.Munmap(this.mem)
+		this.wlock.Unlock()
+		this.rlock.Unlock()
+	})
+	return nil
+}
+
+// IsOpen implements the Pipe IsOpen method.
+func (this *pipe) IsOpen() bool {
+	return atomic.LoadInt32(&this.closed) == 0
+}
+
+// LocalProtocol returns our local protocol number.
+func (this *pipe) LocalProtocol() uint16 {
+	return this.proto.Number()
+}
+
+// RemoteProtocol returns our peer's protocol number.
+func (this *pipe) RemoteProtocol() uint16 {
+	return this.proto.PeerNumber()
+}
+
+func (this *pipe) GetProp(name string) (interface{}, error) {
+	if v, ok := this.props[name]; ok {
+		return v, nil
+	}
+	return nil, nano.ErrBadProperty
+}
@@ -0,0 +1,98 @@
+package shm
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Layout of a ring in the shared segment.  The counters live on cache
+// lines of their own, so that the two processes do not contend on them.
+const (
+	ringHead  = 0   // uint64, bytes written so far, by the producer
+	ringTail  = 64  // uint64, bytes read so far, by the consumer
+	ringRWait = 128 // uint32, 1 while the consumer waits for data
+	ringWWait = 192 // uint32, 1 while the producer waits for space
+	ringData  = 256
+
+	minRingSize     = 4 << 10
+	maxRingSize     = 1 << 30
+	defaultRingSize = 1 << 20
+)
+
+// ring is a single producer, single consumer byte stream in memory shared
+// by two processes.  It only moves bytes, waiting is up to the pipe.
+type ring struct {
+	data  []byte
+	mask  uint64
+	head  *uint64
+	tail  *uint64
+	rwait *uint32
+	wwait *uint32
+}
+
+// ringSpan is the room a ring of size bytes takes in the segment.
+func ringSpan(size int) int {
+	return ringData + size
+}
+
+func validRingSize(size int) bool {
+	return size >= minRingSize && size <= maxRingSize && size&(size-1) == 0
+}
+
+// newRing lays a ring over mem, which is ringSpan bytes long and page
+// aligned, as returned by mmap.
+func newRing(mem []byte) *ring {
+	return &ring{
+		data:  mem[ringData:],
+		mask:  uint64(len(mem) - ringData - 1),
+		head:  (*uint64)(unsafe.Pointer(&mem[ringHead])),
+		tail:  (*uint64)(unsafe.Pointer(&mem[ringTail])),
+		rwait: (*uint32)(unsafe.Pointer(&mem[ringRWait])),
+		wwait: (*uint32)(unsafe.Pointer(&mem[ringWWait])),
+	}
+}
+
+// readable returns how many bytes the consumer may read.
+func (this *ring) readable() uint64 {
+	return atomic.LoadUint64(this.head) - atomic.LoadUint64(this.tail)
+}
+
+// writable returns how many bytes the producer may write.
+func (this *ring) writable() uint64 {
+	return uint64(len(this.data)) - this.readable()
+}
+
+// write copies as much of b as fits, and returns how much it did.
+func (this *ring) write(b []byte) int {
+	head := atomic.LoadUint64(this.head)
+	free := uint64(len(this.data)) - (head - atomic.LoadUint64(this.tail))
+	if uint64(len(b)) > free {
+		b = b[:free]
+	}
+	if len(b) == 0 {
+		return 0
+	}
+
+	n := copy(this.data[head&this.mask:], b)
+	copy(this.data, b[n:])
+	atomic.StoreUint64(this.head, head+uint64(len(b)))
+	return len(b)
+}
+
+// read fills as much of b as there is data for, and returns how much
+// it did.
+func (this *ring) read(b []byte) int {
+	tail := atomic.LoadUint64(this.tail)
+	avail := atomic.LoadUint64(this.head) - tail
+	if uint64(len(b)) > avail {
+		b = b[:avail]
+	}
+	if len(b) == 0 {
+		return 0
+	}
+
+	n := copy(b, this.data[tail&this.mask:])
+	copy(b[n:], this.data)
+	atomic.StoreUint64(this.tail, tail+uint64(len(b)))
+	return len(b)
+}
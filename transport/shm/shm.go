@@ -0,0 +1,170 @@
+// Package shm implements a transport for peers on the same Linux host,
+// exchanging messages through rings in shared memory rather than sockets.
+//
+// The address is a name, e.g. shm://md-feed.  The listener binds it as
+// the abstract unix socket @nano-shm/<name>, which dialers connect to in
+// order to hand over a fresh segment with two rings, one per direction,
+// and the eventfds that wake up the peer waiting on them.  The segment is
+// a memfd sealed against resizing, which needs Linux 3.17, so that no
+// side can make the mapping of the other fault.  From then on
+// the socket carries nothing, it only tells either side when the other
+// is gone.  The SP handshake goes through the rings, like the messages,
+// which are copied once on each side and need no syscall while the peer
+// keeps up.
+package shm
+
+import (
+	"net"
+	"strings"
+
+	"github.com/funkygao/nano"
+)
+
+// options is used for shared GetOption/SetOption logic.
+type options map[string]interface{}
+
+// get retrieves an option value.
+func (o options) get(name string) (interface{}, error) {
+	if v, ok := o[name]; ok {
+		return v, nil
+	}
+	switch name {
+	case nano.OptionShmSize:
+		return defaultRingSize, nil
+	}
+	return nil, nano.ErrBadOption
+}
+
+// set validates and stores an option.
+func (o options) set(name string, val interface{}) error {
+	switch name {
+	case nano.OptionMaxRecvSize:
+		if v, ok := val.(int); !ok || v <= 0 {
+			return nano.ErrBadValue
+		}
+	case nano.OptionShmSize:
+		if v, ok := val.(int); !ok || !validRingSize(v) {
+			return nano.ErrBadValue
+		}
+	default:
+		return nano.ErrBadOption
+	}
+
+	o[name] = val
+	return nil
+}
+
+// merge returns the transport options overridden by those of o.
+func (o options) merge(topts options) options {
+	merged := make(options)
+	for _, opts := range []options{topts, o} {
+		for k, v := range opts {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+type dialer struct {
+	t     *shmTran
+	name  string
+	proto nano.Protocol
+	opts  options
+}
+
+// SetOption implements the PipeDialer SetOption method.
+func (d *dialer) SetOption(n string, v interface{}) error {
+	return d.opts.set(n, v)
+}
+
+// GetOption implements the PipeDialer GetOption method.
+func (d *dialer) GetOption(n string) (interface{}, error) {
+	return d.opts.merge(d.t.opts).get(n)
+}
+
+type listener struct {
+	t     *shmTran
+	name  string
+	proto nano.Protocol
+	opts  options
+	gate  nano.ConnGate
+	sock  *net.UnixListener // the rendezvous socket, once listening
+}
+
+// SetGate implements the GatedPipeListener SetGate method.
+func (l *listener) SetGate(gate nano.ConnGate) {
+	l.gate = gate
+}
+
+// SetOption implements the PipeListener SetOption method.
+func (l *listener) SetOption(n string, v interface{}) error {
+	return l.opts.set(n, v)
+}
+
+// GetOption implements the PipeListener GetOption method.
+func (l *listener) GetOption(n string) (interface{}, error) {
+	return l.opts.merge(l.t.opts).get(n)
+}
+
+type shmTran struct {
+	opts options
+}
+
+// Scheme implements the Transport Scheme method.
+func (t *shmTran) Scheme() string {
+	return "shm"
+}
+
+// checkName validates the name of an address, which must fit in an
+// abstract socket address.
+func checkName(name string) error {
+	if name == "" || len(name) > 90 || strings.ContainsRune(name, 0) {
+		return nano.ErrBadAddr
+	}
+	return nil
+}
+
+// NewDialer implements the Transport NewDialer method.
+func (t *shmTran) NewDialer(addr string, proto nano.Protocol) (nano.PipeDialer, error) {
+	name, err := nano.StripScheme(t, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkName(name); err != nil {
+		return nil, err
+	}
+	return &dialer{t: t, name: name, proto: proto, opts: make(options)}, nil
+}
+
+// NewListener implements the Transport NewListener method.
+func (t *shmTran) NewListener(addr string, proto nano.Protocol) (nano.PipeListener, error) {
+	name, err := nano.StripScheme(t, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkName(name); err != nil {
+		return nil, err
+	}
+	return &listener{t: t, name: name, proto: proto, opts: make(options)}, nil
+}
+
+func init() {
+	nano.RegisterTransport(NewTransport())
+}
+
+// NewTransport allocates a new shm transport.  Options are
+// nano.OptionShmSize and nano.OptionMaxRecvSize, also settable on its
+// dialers and listeners.
+func NewTransport(opts ...interface{}) nano.Transport {
+	t := &shmTran{opts: make(options)}
+	if len(opts)%2 != 0 {
+		return nil
+	}
+	for i := 0; i+1 < len(opts); i += 2 {
+		name, ok := opts[i].(string)
+		if !ok || t.opts.set(name, opts[i+1]) != nil {
+			return nil
+		}
+	}
+	return t
+}
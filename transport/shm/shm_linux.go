@@ -0,0 +1,242 @@
+// +build linux
+
+package shm
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/funkygao/nano"
+)
+
+// magic starts the hello a dialer sends along with the descriptors,
+// followed by the ring size as 8 bytes big endian.
+const magic = "NANOSHM1"
+
+// nfds is the number of descriptors handed over: the segment, then the
+// data and space eventfds of the dialer's ring, then those of the
+// listener's.
+const nfds = 5
+
+func rendezvous(name string) *net.UnixAddr {
+	return &net.UnixAddr{Name: "@nano-shm/" + name, Net: "unix"}
+}
+
+// Dial implements the PipeDialer Dial method.
+func (d *dialer) Dial() (nano.Pipe, error) {
+	opts := d.opts.merge(d.t.opts)
+	v, _ := opts.get(nano.OptionShmSize)
+	size := v.(int)
+
+	conn, err := net.DialUnix("unix", nil, rendezvous(d.name))
+	if err != nil {
+		return nil, err
+	}
+
+	mem, efds, err := handOver(conn, size)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newPipe(conn, mem, size, efds, true, d.proto, opts,
+		peerCredProps(conn))
+}
+
+// handOver sets a segment up for a connection, and sends it along with
+// the eventfds to the listener.
+func handOver(conn *net.UnixConn, size int) (mem []byte, efds []*os.File, err error) {
+	seg, err := newSegment(2 * ringSpan(size))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer seg.Close()
+
+	fds := []int{int(seg.Fd())}
+	defer func() {
+		if err != nil {
+			for _, fd := range fds[1:] {
+				syscall.Close(fd)
+			}
+		}
+	}()
+	for i := 1; i < nfds; i++ {
+		fd, _, errno := syscall.RawSyscall(syscall.SYS_EVENTFD2, 0,
+			syscall.O_CLOEXEC|syscall.O_NONBLOCK, 0)
+		if errno != 0 {
+			return nil, nil, os.NewSyscallError("eventfd2", errno)
+		}
+		fds = append(fds, int(fd))
+	}
+
+	if mem, err = syscall.Mmap(fds[0], 0, 2*ringSpan(size),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED); err != nil {
+		return nil, nil, err
+	}
+
+	var hello [16]byte
+	copy(hello[:], magic)
+	binary.BigEndian.PutUint64(hello[8:], uint64(size))
+	if _, _, err = conn.WriteMsgUnix(hello[:], syscall.UnixRights(fds...), nil); err != nil {
+		syscall.Munmap(mem)
+		return nil, nil, err
+	}
+
+	return mem, eventFiles(fds[1:]), nil
+}
+
+// newSegment returns a memfd of size bytes, sealed against resizing: the
+// peer holds it too, and would otherwise be able to shrink it under our
+// mapping, which then faults.
+func newSegment(size int) (*os.File, error) {
+	f, err := memfdCreate("nano-shm")
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Truncate(int64(size)); err == nil {
+		err = addSeals(f.Fd(), segmentSealsLock)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// eventFiles wraps the eventfds, which are non-blocking, for the runtime
+// poller to wait on them.  Their Fd method must not be called, that would
+// make them blocking.
+func eventFiles(fds []int) []*os.File {
+	files := make([]*os.File, len(fds))
+	for i, fd := range fds {
+		files[i] = os.NewFile(uintptr(fd), "eventfd")
+	}
+	return files
+}
+
+// Listen implements the PipeListener Listen method.
+func (l *listener) Listen() error {
+	sock, err := net.ListenUnix("unix", rendezvous(l.name))
+	if err != nil {
+		return err
+	}
+	l.sock = sock
+	return nil
+}
+
+// Accept implements the PipeListener Accept method.
+func (l *listener) Accept() (nano.Pipe, error) {
+	if l.sock == nil {
+		return nil, nano.ErrClosed
+	}
+	conn, err := l.sock.AcceptUnix()
+	if err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return nil, nano.ErrClosed
+		}
+		return nil, err
+	}
+
+	var c net.Conn = conn
+	if l.gate != nil {
+		// reject before the segment is mapped
+		if c, err = l.gate.Admit(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	mem, size, efds, err := takeOver(conn)
+	if err != nil {
+		nano.Debugf("%v", err)
+		c.Close()
+		return nil, err
+	}
+	return newPipe(c, mem, size, efds, false, l.proto, l.opts.merge(l.t.opts),
+		peerCredProps(conn))
+}
+
+// takeOver receives the hello of a dialer, and maps the segment it sent.
+func takeOver(conn *net.UnixConn) (mem []byte, size int, efds []*os.File, err error) {
+	var hello [16]byte
+	oob := make([]byte, syscall.CmsgSpace(nfds*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(hello[:], oob)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	var fds []int
+	if msgs, perr := syscall.ParseSocketControlMessage(oob[:oobn]); perr == nil {
+		for i := range msgs {
+			if got, perr := syscall.ParseUnixRights(&msgs[i]); perr == nil {
+				fds = append(fds, got...)
+			}
+		}
+	}
+	defer func() {
+		if err != nil {
+			for _, fd := range fds {
+				syscall.Close(fd)
+			}
+		}
+	}()
+
+	size = int(binary.BigEndian.Uint64(hello[8:]))
+	if n != len(hello) || string(hello[:8]) != magic || len(fds) != nfds ||
+		!validRingSize(size) {
+		return nil, 0, nil, nano.ErrBadHeader
+	}
+	var st syscall.Stat_t
+	if err = syscall.Fstat(fds[0], &st); err != nil {
+		return nil, 0, nil, err
+	}
+	if st.Size != int64(2*ringSpan(size)) {
+		return nil, 0, nil, nano.ErrBadHeader
+	}
+	// the dialer must not be able to resize it either
+	seals, err := getSeals(fds[0])
+	if err != nil || seals&segmentSeals != segmentSeals {
+		return nil, 0, nil, nano.ErrBadHeader
+	}
+
+	if mem, err = syscall.Mmap(fds[0], 0, 2*ringSpan(size),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED); err != nil {
+		return nil, 0, nil, err
+	}
+	syscall.Close(fds[0])
+	return mem, size, eventFiles(fds[1:]), nil
+}
+
+// Close implements the PipeListener Close method.
+func (l *listener) Close() error {
+	if l.sock != nil {
+		l.sock.Close()
+	}
+	return nil
+}
+
+// peerCredProps returns the SO_PEERCRED credentials of the peer as pipe
+// properties.
+func peerCredProps(uc *net.UnixConn) []interface{} {
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd),
+			syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		nano.Debugf("SO_PEERCRED: %v", err)
+		return nil
+	}
+
+	return []interface{}{
+		nano.PropPeerPid, int(cred.Pid),
+		nano.PropPeerUid, int(cred.Uid),
+		nano.PropPeerGid, int(cred.Gid),
+	}
+}
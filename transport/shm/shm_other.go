@@ -0,0 +1,27 @@
+// +build !linux
+
+package shm
+
+import (
+	"github.com/funkygao/nano"
+)
+
+// Dial implements the PipeDialer Dial method, shm needs Linux.
+func (d *dialer) Dial() (nano.Pipe, error) {
+	return nil, nano.ErrBadTran
+}
+
+// Listen implements the PipeListener Listen method, shm needs Linux.
+func (l *listener) Listen() error {
+	return nano.ErrBadTran
+}
+
+// Accept implements the PipeListener Accept method.
+func (l *listener) Accept() (nano.Pipe, error) {
+	return nil, nano.ErrClosed
+}
+
+// Close implements the PipeListener Close method.
+func (l *listener) Close() error {
+	return nil
+}
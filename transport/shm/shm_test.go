@@ -0,0 +1,238 @@
+// +build linux
+
+package shm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/pair"
+	"github.com/funkygao/nano/protocol/reqrep"
+	"github.com/funkygao/nano/test"
+)
+
+var tt = test.NewTranTest(NewTransport(), "shm://test1234")
+
+func TestShmAll(t *testing.T) {
+	tt.TranTestAll(t)
+}
+
+func TestShmOptions(t *testing.T) {
+	d, err := NewTransport().NewDialer("shm://opts", pair.NewSocket().GetProtocol())
+	assert.Equal(t, nil, err)
+	v, err := d.GetOption(nano.OptionShmSize)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, defaultRingSize, v)
+	assert.Equal(t, nano.ErrBadValue, d.SetOption(nano.OptionShmSize, 5000))
+	assert.Equal(t, nano.ErrBadValue, d.SetOption(nano.OptionShmSize, 1024))
+	assert.Equal(t, nil, d.SetOption(nano.OptionShmSize, 8192))
+
+	_, err = NewTransport().NewListener("shm://", pair.NewSocket().GetProtocol())
+	assert.Equal(t, nano.ErrBadAddr, err)
+}
+
+// pairs returns a pair of connected sockets over rings of size bytes.
+func pairs(t *testing.T, addr string, size int) (nano.Socket, nano.Socket) {
+	srv := pair.NewSocket()
+	assert.Equal(t, nil, srv.Listen(addr))
+	cli := pair.NewSocket()
+	d, err := cli.NewDialer(addr, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, d.SetOption(nano.OptionShmSize, size))
+	assert.Equal(t, nil, d.Dial())
+	for _, s := range []nano.Socket{srv, cli} {
+		s.SetOption(nano.OptionRecvDeadline, 5*time.Second)
+		s.SetOption(nano.OptionSendDeadline, 5*time.Second)
+	}
+	return srv, cli
+}
+
+func TestShmWrapAround(t *testing.T) {
+	srv, cli := pairs(t, "shm://wrap", minRingSize)
+	defer srv.Close()
+	defer cli.Close()
+
+	// larger than the ring, and enough to wrap it many times over
+	big := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	go func() {
+		for i := 0; i < 20; i++ {
+			cli.Send(big[:len(big)-i*77])
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		m, err := srv.Recv()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, bytes.Equal(big[:len(big)-i*77], m))
+	}
+}
+
+func TestShmPeerClose(t *testing.T) {
+	srv, cli := pairs(t, "shm://close", minRingSize)
+	defer srv.Close()
+
+	assert.Equal(t, nil, cli.Send([]byte("last words")))
+	time.Sleep(50 * time.Millisecond)
+	cli.Close()
+
+	// sent before the close, still delivered
+	m, err := srv.Recv()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "last words", string(m))
+	srv.SetOption(nano.OptionRecvDeadline, 200*time.Millisecond)
+	_, err = srv.Recv()
+	assert.Equal(t, nano.ErrRecvTimeout, err)
+}
+
+// TestShmChild is the peer process of TestShmProcess.
+func TestShmChild(t *testing.T) {
+	if os.Getenv("NANO_SHM_CHILD") == "" {
+		t.Skip("only run by TestShmProcess")
+	}
+
+	rep := reqrep.NewRepSocket()
+	defer rep.Close()
+	rep.SetOption(nano.OptionRecvDeadline, 5*time.Second)
+	if err := rep.Dial("shm://xproc"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		m, err := rep.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rep.Send(append(m, '!'))
+	}
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestShmProcess(t *testing.T) {
+	req := reqrep.NewReqSocket()
+	defer req.Close()
+	assert.Equal(t, nil, req.Listen("shm://xproc"))
+	req.SetOption(nano.OptionRecvDeadline, 5*time.Second)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestShmChild$")
+	cmd.Env = append(os.Environ(), "NANO_SHM_CHILD=1")
+	cmd.Stderr = os.Stderr
+	assert.Equal(t, nil, cmd.Start())
+
+	for _, s := range []string{"a", "b", "c"} {
+		assert.Equal(t, nil, req.Send([]byte(s)))
+		m, err := req.Recv()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, s+"!", string(m))
+	}
+	assert.Equal(t, nil, cmd.Wait())
+}
+
+// BenchmarkShmPipe measures round trips at the pipe level, below the
+// socket queues.
+func BenchmarkShmPipe(b *testing.B) {
+	proto := pair.NewSocket().GetProtocol()
+	l, _ := NewTransport().NewListener("shm://bench", proto)
+	if err := l.Listen(); err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		p, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer p.Close()
+		for {
+			m, err := p.RecvMsg()
+			if err != nil {
+				return
+			}
+			p.SendMsg(m)
+		}
+	}()
+
+	d, _ := NewTransport().NewDialer("shm://bench", proto)
+	p, err := d.Dial()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer p.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := nano.NewMessage(64)
+		m.Body = m.Body[:64]
+		if err = p.SendMsg(m); err != nil {
+			b.Fatal(err)
+		}
+		if m, err = p.RecvMsg(); err != nil {
+			b.Fatal(err)
+		}
+		m.Free()
+	}
+}
+
+func TestShmMaxConnsPerIP(t *testing.T) {
+	addr := "shm://perip"
+	srv := reqrep.NewRepSocket()
+	defer srv.Close()
+	assert.Equal(t, nil, srv.ListenOptions(addr, map[string]interface{}{
+		nano.OptionMaxConnsPerIP: 1,
+	}))
+
+	// the unix peers have no IP to be limited by
+	tran := NewTransport()
+	proto := reqrep.NewReqSocket().GetProtocol()
+	for i := 0; i < 2; i++ {
+		d, err := tran.NewDialer(addr, proto)
+		assert.Equal(t, nil, err)
+		p, err := d.Dial()
+		assert.Equal(t, nil, err)
+		defer p.Close()
+	}
+}
+
+func TestShmSegmentSealed(t *testing.T) {
+	seg, err := newSegment(2 * ringSpan(defaultRingSize))
+	assert.Equal(t, nil, err)
+	defer seg.Close()
+	assert.NotEqual(t, nil, seg.Truncate(0))
+	assert.NotEqual(t, nil, seg.Truncate(int64(4*ringSpan(defaultRingSize))))
+}
+
+func TestShmUnsealedSegment(t *testing.T) {
+	l, err := NewTransport().NewListener("shm://unsealed", pair.NewSocket().GetProtocol())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, l.Listen())
+	defer l.Close()
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+
+	// a dialer handing a resizable file over
+	f, err := os.CreateTemp("", "nano-shm-")
+	assert.Equal(t, nil, err)
+	os.Remove(f.Name())
+	defer f.Close()
+	assert.Equal(t, nil, f.Truncate(int64(2*ringSpan(defaultRingSize))))
+
+	conn, err := net.DialUnix("unix", nil, rendezvous("unsealed"))
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+	var hello [16]byte
+	copy(hello[:], magic)
+	binary.BigEndian.PutUint64(hello[8:], uint64(defaultRingSize))
+	fd := int(f.Fd())
+	_, _, err = conn.WriteMsgUnix(hello[:],
+		syscall.UnixRights(fd, fd, fd, fd, fd), nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nano.ErrBadHeader, <-accepted)
+}
@@ -1,7 +1,7 @@
 package tcp
 
 import (
-	"net"
+	"context"
 
 	"github.com/funkygao/nano"
 )
@@ -9,13 +9,16 @@ import (
 // dialer implements the nano.PipeDialer interface.
 type dialer struct {
 	t     *tcpTransport
-	addr  *net.TCPAddr
+	addr  *Target
 	proto nano.Protocol
 	opts  options
+
+	ctx    context.Context // cancelled by Close
+	cancel context.CancelFunc
 }
 
 func (this *dialer) Dial() (nano.Pipe, error) {
-	conn, err := net.DialTCP("tcp", nil, this.addr)
+	conn, _, err := this.addr.DialProxy(this.ctx, ProxyOf(this.opts))
 	if err != nil {
 		return nil, err
 	}
@@ -25,10 +28,16 @@ func (this *dialer) Dial() (nano.Pipe, error) {
 		return nil, err
 	}
 
-	nano.Debugf("dial tcp:%v done, NewConnPipe...", *this.addr)
+	nano.Debugf("dial tcp:%v done, NewConnPipe...", conn.RemoteAddr())
 
 	return nano.NewConnPipe(conn, this.proto,
-		nano.FlattenOptions(this.t.opts)...)
+		nano.PipeProps(this.t.opts, this.opts)...)
+}
+
+// Close implements the nano.ClosablePipeDialer Close method.
+func (this *dialer) Close() error {
+	this.cancel()
+	return nil
 }
 
 func (this *dialer) SetOption(name string, val interface{}) error {
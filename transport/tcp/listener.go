@@ -2,6 +2,7 @@ package tcp
 
 import (
 	"net"
+	"os"
 
 	"github.com/funkygao/nano"
 )
@@ -9,10 +10,11 @@ import (
 // listener implements the nano.PipeListener interface.
 type listener struct {
 	t        *tcpTransport
-	addr     *net.TCPAddr
+	addrs    []*net.TCPAddr
 	proto    nano.Protocol
-	listener *net.TCPListener
+	listener *Listener
 	opts     options
+	gate     nano.ConnGate
 }
 
 func (this *listener) Accept() (nano.Pipe, error) {
@@ -32,21 +34,42 @@ func (this *listener) Accept() (nano.Pipe, error) {
 		return nil, err
 	}
 
-	return nano.NewConnPipe(conn, this.proto,
-		nano.FlattenOptions(this.t.opts)...)
+	var c net.Conn = conn
+	if this.gate != nil {
+		// reject before handshake
+		if c, err = this.gate.Admit(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	return nano.NewConnPipe(c, this.proto,
+		nano.PipeProps(this.t.opts, this.opts)...)
 }
 
 func (this *listener) Listen() (err error) {
-	nano.Debugf("%s", this.addr)
-	this.listener, err = net.ListenTCP("tcp", this.addr)
+	nano.Debugf("%v", this.addrs)
+	this.listener, err = ListenTCP(this.addrs, this.opts)
 	return
 }
 
 func (this *listener) Close() error {
-	this.listener.Close()
+	if this.listener != nil {
+		this.listener.Close()
+	}
 	return nil
 }
 
+func (this *listener) Files() ([]*os.File, error) {
+	if this.listener == nil {
+		return nil, nano.ErrClosed
+	}
+	return this.listener.Files()
+}
+
+func (this *listener) SetGate(gate nano.ConnGate) {
+	this.gate = gate
+}
+
 func (this *listener) SetOption(name string, val interface{}) error {
 	return this.opts.set(name, val)
 }
@@ -0,0 +1,228 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/funkygao/nano"
+)
+
+// SplitLocal splits the optional local part off an address of the form
+// "[<iface>;]<host>:<port>".  The local part is either a network
+// interface name such as eth0, or a local IP.
+func SplitLocal(addr string) (local, rest string) {
+	if i := strings.Index(addr, ";"); i >= 0 {
+		return addr[:i], addr[i+1:]
+	}
+	return "", addr
+}
+
+// LocalIPs returns the IPs a local part stands for.  The link-local
+// addresses of an interface are left out: they are only usable along
+// with the zone, and cannot reach beyond the link anyway.
+func LocalIPs(local string) ([]net.IP, error) {
+	if ip := net.ParseIP(local); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	ifi, err := net.InterfaceByName(local)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, nano.ErrBadAddr
+	}
+	return ips, nil
+}
+
+// localIPFor picks the local IP of the same family as remote.
+func localIPFor(locals []net.IP, remote net.IP) net.IP {
+	v4 := remote == nil || remote.To4() != nil
+	for _, ip := range locals {
+		if (ip.To4() != nil) == v4 {
+			return ip
+		}
+	}
+	return nil
+}
+
+// ListenAddrs resolves a listener address into the TCP addresses to
+// listen on.  With a local part, all the addresses of the interface are
+// used along with the port.  A host of "*" means all interfaces.
+func ListenAddrs(addr string) ([]*net.TCPAddr, error) {
+	local, rest := SplitLocal(addr)
+	if strings.HasPrefix(rest, "*:") {
+		rest = rest[1:]
+	}
+
+	taddr, err := net.ResolveTCPAddr("tcp", rest)
+	if err != nil {
+		return nil, err
+	}
+	if local == "" {
+		return []*net.TCPAddr{taddr}, nil
+	}
+
+	ips, err := LocalIPs(local)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]*net.TCPAddr, 0, len(ips))
+	for _, ip := range ips {
+		r = append(r, &net.TCPAddr{IP: ip, Port: taddr.Port})
+	}
+	return r, nil
+}
+
+type acceptResult struct {
+	conn *net.TCPConn
+	err  error
+}
+
+// Listener listens on one or more TCP addresses, e.g. all the addresses
+// of an interface, and hands out their connections from one Accept.
+// It is shared with the tls+tcp transport.
+type Listener struct {
+	ls []*net.TCPListener
+
+	conns     chan acceptResult
+	closeChan chan struct{}
+	once      sync.Once
+}
+
+// ListenTCP listens on all the addresses, applying the TCP level options
+// that must be set before bind, such as nano.OptionReusePort.  A socket
+// given with nano.OptionListenFd, or inherited for an address, is adopted
+// instead of binding.
+func ListenTCP(addrs []*net.TCPAddr, opts map[string]interface{}) (*Listener, error) {
+	l := &Listener{closeChan: make(chan struct{})}
+	if v, ok := opts[nano.OptionListenFd]; ok {
+		tl, err := fileListener(v)
+		if err != nil {
+			return nil, err
+		}
+		l.ls = append(l.ls, tl)
+		return l, nil
+	}
+
+	for _, addr := range addrs {
+		var tl *net.TCPListener
+		if nl := nano.TakeListenFd(func(a net.Addr) bool {
+			return nano.SameListenAddr(a, addr)
+		}); nl != nil {
+			nano.Debugf("adopted inherited %v", nl.Addr())
+			tl = nl.(*net.TCPListener)
+		} else {
+			var err error
+			if tl, err = options(opts).listenTCP(addr); err != nil {
+				l.Close()
+				return nil, err
+			}
+		}
+		l.ls = append(l.ls, tl)
+	}
+
+	if len(l.ls) > 1 {
+		l.conns = make(chan acceptResult)
+		for _, tl := range l.ls {
+			go l.acceptor(tl)
+		}
+	}
+	return l, nil
+}
+
+func (this *Listener) acceptor(tl *net.TCPListener) {
+	for {
+		conn, err := tl.AcceptTCP()
+		select {
+		case this.conns <- acceptResult{conn, err}:
+		case <-this.closeChan:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// AcceptTCP waits for the next connection on any of the addresses.
+func (this *Listener) AcceptTCP() (*net.TCPConn, error) {
+	var r acceptResult
+	if this.conns == nil {
+		r.conn, r.err = this.ls[0].AcceptTCP()
+	} else {
+		select {
+		case r = <-this.conns:
+		case <-this.closeChan:
+			return nil, nano.ErrClosed
+		}
+	}
+
+	if errors.Is(r.err, net.ErrClosed) {
+		// let the core stop serving
+		return nil, nano.ErrClosed
+	}
+	return r.conn, r.err
+}
+
+func fileListener(v interface{}) (*net.TCPListener, error) {
+	nl, err := nano.FileListener(v)
+	if err != nil {
+		return nil, err
+	}
+	tl, ok := nl.(*net.TCPListener)
+	if !ok {
+		nl.Close()
+		return nil, nano.ErrBadValue
+	}
+	return tl, nil
+}
+
+// Files returns duplicates of the listening descriptors.
+func (this *Listener) Files() ([]*os.File, error) {
+	var files []*os.File
+	for _, tl := range this.ls {
+		f, err := nano.ListenerFile(tl, "tcp:"+tl.Addr().String())
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// Addr returns the address of the first listener.
+func (this *Listener) Addr() net.Addr {
+	return this.ls[0].Addr()
+}
+
+// Close closes all the listeners.
+func (this *Listener) Close() error {
+	this.once.Do(func() {
+		close(this.closeChan)
+		for _, tl := range this.ls {
+			tl.Close()
+		}
+	})
+	return nil
+}
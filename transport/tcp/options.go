@@ -1,7 +1,11 @@
 package tcp
 
 import (
+	"context"
 	"net"
+	"os"
+	"syscall"
+	"time"
 
 	"github.com/funkygao/nano"
 )
@@ -24,14 +28,84 @@ func (o options) get(name string) (interface{}, error) {
 
 func (o options) set(name string, val interface{}) error {
 	switch name {
-	case nano.OptionNoDelay:
+	case nano.OptionNoDelay, nano.OptionKeepAlive, nano.OptionReusePort:
 		switch v := val.(type) {
 		case bool:
+			if name == nano.OptionReusePort && !reusePortSupported {
+				return nano.ErrBadOption
+			}
 			o[name] = v
 			return nil
 		default:
 			return nano.ErrBadValue
 		}
+
+	case nano.OptionSendBuffer, nano.OptionRecvBuffer:
+		switch v := val.(type) {
+		case int:
+			if v <= 0 {
+				return nano.ErrBadValue
+			}
+			o[name] = v
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+
+	case nano.OptionKeepAliveCount:
+		if !sockoptSupported {
+			return nano.ErrBadOption
+		}
+		switch v := val.(type) {
+		case int:
+			if v <= 0 {
+				return nano.ErrBadValue
+			}
+			o[name] = v
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+
+	case nano.OptionKeepAliveInterval, nano.OptionUserTimeout:
+		if name == nano.OptionUserTimeout && !sockoptSupported {
+			return nano.ErrBadOption
+		}
+		switch v := val.(type) {
+		case time.Duration:
+			if v <= 0 {
+				return nano.ErrBadValue
+			}
+			o[name] = v
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+	}
+
+	if nano.IsPipeOption(name) {
+		return nano.SetPipeOption(o, name, val)
+	}
+	if name == nano.OptionProxy {
+		switch v := val.(type) {
+		case string:
+			if _, err := ParseProxy(v); err != nil {
+				return err
+			}
+			o[name] = v
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+	}
+	if name == nano.OptionListenFd {
+		switch val.(type) {
+		case int, *os.File:
+			o[name] = val
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
 	}
 	return nano.ErrBadOption
 }
@@ -43,5 +117,79 @@ func (o options) configTCP(conn *net.TCPConn) error {
 		}
 	}
 
+	if v, ok := o[nano.OptionKeepAlive]; ok {
+		if err := conn.SetKeepAlive(v.(bool)); err != nil {
+			return err
+		}
+	}
+	if v, ok := o[nano.OptionKeepAliveInterval]; ok {
+		if err := conn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := conn.SetKeepAlivePeriod(v.(time.Duration)); err != nil {
+			return err
+		}
+	}
+
+	if v, ok := o[nano.OptionSendBuffer]; ok {
+		if err := conn.SetWriteBuffer(v.(int)); err != nil {
+			return err
+		}
+	}
+	if v, ok := o[nano.OptionRecvBuffer]; ok {
+		if err := conn.SetReadBuffer(v.(int)); err != nil {
+			return err
+		}
+	}
+
+	return o.configSockopt(conn)
+}
+
+// listenConfig returns the net.ListenConfig that applies the options
+// that must be set before bind.
+func (o options) listenConfig() *net.ListenConfig {
+	lc := &net.ListenConfig{}
+	if v, ok := o[nano.OptionReusePort]; ok && v.(bool) {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = setReusePort(fd)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		}
+	}
+	return lc
+}
+
+// SetOption validates and stores a TCP level option in opts.  It returns
+// nano.ErrBadOption for options that are not TCP level.  Shared with the
+// tls+tcp transport.
+func SetOption(opts map[string]interface{}, name string, val interface{}) error {
+	return options(opts).set(name, val)
+}
+
+// ProxyOf returns the proxy set in opts, or nil to connect directly.
+func ProxyOf(opts map[string]interface{}) *Proxy {
+	if v, ok := opts[nano.OptionProxy]; ok {
+		// validated when set
+		p, _ := ParseProxy(v.(string))
+		return p
+	}
 	return nil
 }
+
+// ConfigTCP applies the TCP level options in opts to conn.
+func ConfigTCP(opts map[string]interface{}, conn *net.TCPConn) error {
+	return options(opts).configTCP(conn)
+}
+
+// listenTCP listens on addr honoring the options that apply before bind.
+func (o options) listenTCP(addr *net.TCPAddr) (*net.TCPListener, error) {
+	l, err := o.listenConfig().Listen(context.Background(), "tcp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return l.(*net.TCPListener), nil
+}
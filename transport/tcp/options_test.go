@@ -1,7 +1,10 @@
 package tcp
 
 import (
+	"net"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/funkygao/assert"
 	"github.com/funkygao/nano"
@@ -32,3 +35,47 @@ func TestOptionsValidName(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, false, noDelay)
 }
+
+func TestOptionsSocketTuning(t *testing.T) {
+	opt := newOptions()
+	assert.Equal(t, nil, opt.set(nano.OptionKeepAlive, true))
+	assert.Equal(t, nil, opt.set(nano.OptionKeepAliveInterval, time.Second*30))
+	assert.Equal(t, nil, opt.set(nano.OptionSendBuffer, 1<<20))
+	assert.Equal(t, nil, opt.set(nano.OptionRecvBuffer, 1<<20))
+	assert.Equal(t, nano.ErrBadValue, opt.set(nano.OptionSendBuffer, 0))
+	assert.Equal(t, nano.ErrBadValue, opt.set(nano.OptionKeepAliveInterval, 30))
+	if runtime.GOOS == "linux" {
+		assert.Equal(t, nil, opt.set(nano.OptionKeepAliveCount, 3))
+		assert.Equal(t, nil, opt.set(nano.OptionUserTimeout, time.Second*10))
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+	conn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+	assert.Equal(t, nil, opt.configTCP(conn))
+}
+
+func TestOptionsReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is linux only")
+	}
+
+	opt := newOptions()
+	assert.Equal(t, nil, opt.set(nano.OptionReusePort, true))
+	addrs, err := ListenAddrs("127.0.0.1:3361")
+	assert.Equal(t, nil, err)
+
+	l1, err := ListenTCP(addrs, opt)
+	assert.Equal(t, nil, err)
+	defer l1.Close()
+	l2, err := ListenTCP(addrs, opt)
+	assert.Equal(t, nil, err)
+	defer l2.Close()
+
+	// without the option the port stays exclusive
+	_, err = ListenTCP(addrs, newOptions())
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,234 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+// Proxy is an outbound proxy that dialers tunnel their connections
+// through, before the SP handshake:
+//
+//	socks5://[user:password@]host[:port]	SOCKS5, port 1080 by default
+//	http://[user:password@]host[:port]	HTTP CONNECT, port 80 by default
+//
+// Target names are sent to the proxy unresolved, the proxy resolves them.
+type Proxy struct {
+	url *url.URL
+}
+
+// ParseProxy parses a proxy URL.
+func ParseProxy(s string) (*Proxy, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, nano.ErrBadValue
+	}
+	switch u.Scheme {
+	case "socks5", "http":
+	default:
+		return nil, nano.ErrBadValue
+	}
+	if u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return nil, nano.ErrBadValue
+	}
+	return &Proxy{url: u}, nil
+}
+
+// String returns the proxy URL, without password.
+func (this *Proxy) String() string {
+	return this.url.Redacted()
+}
+
+// addr returns the host:port of the proxy itself.
+func (this *Proxy) addr() string {
+	if this.url.Port() != "" {
+		return this.url.Host
+	}
+	port := "80"
+	if this.url.Scheme == "socks5" {
+		port = "1080"
+	}
+	return net.JoinHostPort(this.url.Hostname(), port)
+}
+
+// tunnel has the proxy connected with conn connect to hostport.
+func (this *Proxy) tunnel(ctx context.Context, conn *net.TCPConn, hostport string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// the exchange blocks on conn, which only a deadline interrupts
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	var err error
+	if this.url.Scheme == "socks5" {
+		err = this.socks5(conn, hostport)
+	} else {
+		err = this.connect(conn, hostport)
+	}
+	close(stop)
+	<-stopped
+	if err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// socks5 runs the RFC 1928 CONNECT request, with the RFC 1929 username
+// and password authentication if the URL has credentials.
+func (this *Proxy) socks5(conn net.Conn, hostport string) error {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return err
+	}
+	portnum, err := strconv.Atoi(port)
+	if err != nil || portnum <= 0 || portnum > 0xffff {
+		return nano.ErrBadAddr
+	}
+
+	method := byte(0) // no authentication
+	if this.url.User != nil {
+		method = 2 // username/password
+	}
+	if _, err = conn.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	var b [2]byte
+	if _, err = io.ReadFull(conn, b[:]); err != nil {
+		return err
+	}
+	if b[0] != 5 {
+		return nano.ErrGarbled
+	}
+	if b[1] != method {
+		return nano.ErrProxyAuth
+	}
+
+	if method == 2 {
+		user := this.url.User.Username()
+		pass, _ := this.url.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return nano.ErrBadValue
+		}
+		req := append([]byte{1, byte(len(user))}, user...)
+		req = append(append(req, byte(len(pass))), pass...)
+		if _, err = conn.Write(req); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, b[:]); err != nil {
+			return err
+		}
+		if b[1] != 0 {
+			return nano.ErrProxyAuth
+		}
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nano.ErrBadAddr
+		}
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip.To16()...)
+	}
+	req = append(req, byte(portnum>>8), byte(portnum))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	// version, reply, reserved, then the bound address
+	var reply [4]byte
+	if _, err = io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return nano.ErrGarbled
+	}
+	if reply[1] != 0 {
+		nano.Debugf("socks5 %s: reply %d", hostport, reply[1])
+		return nano.ErrConnRefused
+	}
+	var n int
+	switch reply[3] {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		if _, err = io.ReadFull(conn, b[:1]); err != nil {
+			return err
+		}
+		n = int(b[0])
+	default:
+		return nano.ErrGarbled
+	}
+	// and the bound port
+	_, err = io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
+
+// maxConnectResponse bounds the response headers of an HTTP proxy.
+const maxConnectResponse = 8 << 10
+
+// connect runs an HTTP CONNECT request, with basic authentication if the
+// URL has credentials.
+func (this *Proxy) connect(conn net.Conn, hostport string) error {
+	var req bytes.Buffer
+	req.WriteString("CONNECT " + hostport + " HTTP/1.1\r\nHost: " + hostport + "\r\n")
+	if this.url.User != nil {
+		pass, _ := this.url.User.Password()
+		auth := this.url.User.Username() + ":" + pass
+		req.WriteString("Proxy-Authorization: Basic " +
+			base64.StdEncoding.EncodeToString([]byte(auth)) + "\r\n")
+	}
+	req.WriteString("\r\n")
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return err
+	}
+
+	// byte by byte, not to read into what the peer sends through the
+	// tunnel
+	var resp []byte
+	var b [1]byte
+	for !bytes.HasSuffix(resp, []byte("\r\n\r\n")) {
+		if len(resp) >= maxConnectResponse {
+			return nano.ErrGarbled
+		}
+		if _, err := io.ReadFull(conn, b[:]); err != nil {
+			return err
+		}
+		resp = append(resp, b[0])
+	}
+
+	status := strings.Fields(string(resp[:bytes.IndexByte(resp, '\n')]))
+	if len(status) < 2 || !strings.HasPrefix(status[0], "HTTP/1.") {
+		return nano.ErrGarbled
+	}
+	switch {
+	case status[1] == "407":
+		return nano.ErrProxyAuth
+	case !strings.HasPrefix(status[1], "2"):
+		nano.Debugf("connect %s: %s", hostport, strings.Join(status[1:], " "))
+		return nano.ErrConnRefused
+	}
+	return nil
+}
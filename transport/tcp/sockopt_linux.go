@@ -0,0 +1,55 @@
+// +build linux
+
+package tcp
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+// not exported by package syscall
+const (
+	soReusePort    = 0xf
+	tcpUserTimeout = 0x12
+)
+
+const (
+	reusePortSupported = true
+	sockoptSupported   = true
+)
+
+func setReusePort(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+}
+
+// configSockopt applies the options not covered by net.TCPConn.
+func (o options) configSockopt(conn *net.TCPConn) error {
+	cnt, hasCnt := o[nano.OptionKeepAliveCount]
+	ut, hasUt := o[nano.OptionUserTimeout]
+	if !hasCnt && !hasUt {
+		return nil
+	}
+
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if cerr := rc.Control(func(fd uintptr) {
+		if hasCnt {
+			if err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP,
+				syscall.TCP_KEEPCNT, cnt.(int)); err != nil {
+				return
+			}
+		}
+		if hasUt {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP,
+				tcpUserTimeout, int(ut.(time.Duration)/time.Millisecond))
+		}
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
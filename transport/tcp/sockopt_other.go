@@ -0,0 +1,23 @@
+// +build !linux
+
+package tcp
+
+import (
+	"net"
+
+	"github.com/funkygao/nano"
+)
+
+const (
+	reusePortSupported = false
+	sockoptSupported   = false
+)
+
+func setReusePort(fd uintptr) error {
+	return nano.ErrBadOption
+}
+
+// configSockopt has nothing to do, the options are refused by set.
+func (o options) configSockopt(conn *net.TCPConn) error {
+	return nil
+}
@@ -0,0 +1,211 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+// defaultDialTimeout bounds each lookup and connection attempt.
+var defaultDialTimeout = 10 * time.Second
+
+// Target is the remote side of a tcp based dialer.  It is a comma
+// separated list of host:port replicas, where a host without port that
+// starts with an underscore is a DNS SRV name, e.g.
+//
+//	tcp://10.0.0.1:9000,svc.internal:9000
+//	tcp://_nano._tcp.svc.internal
+//
+// Names are resolved again on every Dial, so that DNS changes are picked
+// up by redials.  An optional "<iface>;" or "<local ip>;" prefix binds
+// the outbound connections to that source.  Target is shared with the
+// tls+tcp transport.
+type Target struct {
+	local string
+	addrs []string
+}
+
+// ParseTarget parses addr, the address with scheme already stripped.
+// Only the syntax is checked, nothing is resolved.
+func ParseTarget(addr string) (*Target, error) {
+	t := &Target{}
+	t.local, addr = SplitLocal(addr)
+	if t.local != "" {
+		// fail early on unknown interface
+		if _, err := LocalIPs(t.local); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range strings.Split(addr, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			return nil, nano.ErrBadAddr
+		}
+		if !isSRV(a) {
+			if _, _, err := net.SplitHostPort(a); err != nil {
+				return nil, err
+			}
+		}
+		t.addrs = append(t.addrs, a)
+	}
+
+	return t, nil
+}
+
+func isSRV(addr string) bool {
+	return strings.HasPrefix(addr, "_") && !strings.Contains(addr, ":")
+}
+
+// String returns the original address list.
+func (this *Target) String() string {
+	s := strings.Join(this.addrs, ",")
+	if this.local != "" {
+		s = this.local + ";" + s
+	}
+	return s
+}
+
+// Dial resolves the target and tries every resolved address in turn till
+// one connects.  The returned host is the name that was dialed, suitable
+// for TLS server name verification.
+func (this *Target) Dial() (conn *net.TCPConn, host string, err error) {
+	return this.DialProxy(context.Background(), nil)
+}
+
+// DialProxy is like Dial, tunneling through proxy unless it is nil.  The
+// proxy then resolves the names, except for SRV ones, and the local
+// binding applies to the connection to the proxy.  Each lookup and
+// connection attempt has a timeout of its own, so that an unresponsive
+// replica does not hold up the others, and cancelling ctx aborts them all.
+func (this *Target) DialProxy(ctx context.Context, proxy *Proxy) (conn *net.TCPConn, host string, err error) {
+	err = nano.ErrConnRefused
+	for _, addr := range this.addrs {
+		var hostports []string
+		if hostports, err = this.expand(ctx, addr); err != nil {
+			nano.Debugf("%s: %v", addr, err)
+			continue
+		}
+
+		for _, hp := range hostports {
+			if proxy != nil {
+				conn, host, err = this.dialProxy(ctx, proxy, hp)
+			} else {
+				conn, host, err = this.dialHostPort(ctx, hp)
+			}
+			if err == nil {
+				return
+			}
+			nano.Debugf("%s: %v", hp, err)
+			if ctx.Err() != nil {
+				return nil, "", ctx.Err()
+			}
+		}
+	}
+
+	return nil, "", err
+}
+
+// expand turns an SRV name into its host:port targets, by priority.
+func (this *Target) expand(ctx context.Context, addr string) ([]string, error) {
+	if !isSRV(addr) {
+		return []string{addr}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultDialTimeout)
+	defer cancel()
+	_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", addr)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]string, 0, len(srvs))
+	for _, srv := range srvs {
+		r = append(r, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."),
+			strconv.Itoa(int(srv.Port))))
+	}
+	return r, nil
+}
+
+// dialProxy connects to proxy, and through it to hostport.
+func (this *Target) dialProxy(ctx context.Context, proxy *Proxy, hostport string) (*net.TCPConn, string, error) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, _, err := this.dialHostPort(ctx, proxy.addr())
+	if err != nil {
+		return nil, "", err
+	}
+	tctx, cancel := context.WithTimeout(ctx, defaultDialTimeout)
+	defer cancel()
+	if err = proxy.tunnel(tctx, conn, hostport); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	return conn, host, nil
+}
+
+// dialHostPort resolves all A/AAAA records of host and dials them in turn.
+func (this *Target) dialHostPort(ctx context.Context, hostport string) (*net.TCPConn, string, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, "", err
+	}
+	lctx, cancel := context.WithTimeout(ctx, defaultDialTimeout)
+	defer cancel()
+	portnum, err := net.DefaultResolver.LookupPort(lctx, "tcp", port)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var ips []net.IPAddr
+	if host == "" {
+		// same as net.Dial, local system
+		ips = []net.IPAddr{{}}
+	} else if ips, err = net.DefaultResolver.LookupIPAddr(lctx, host); err != nil {
+		return nil, "", err
+	}
+
+	var locals []net.IP
+	if this.local != "" {
+		// interface addresses may change, so look them up each time
+		if locals, err = LocalIPs(this.local); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var d net.Dialer
+	for _, ip := range ips {
+		raddr := &net.TCPAddr{IP: ip.IP, Port: portnum, Zone: ip.Zone}
+		d.LocalAddr = nil
+		if locals != nil {
+			lip := localIPFor(locals, ip.IP)
+			if lip == nil {
+				err = nano.ErrBadAddr
+				continue
+			}
+			d.LocalAddr = &net.TCPAddr{IP: lip}
+		}
+
+		var c net.Conn
+		if c, err = dialTimeout(ctx, &d, raddr); err == nil {
+			return c.(*net.TCPConn), host, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, "", err
+}
+
+// dialTimeout dials raddr, giving up after defaultDialTimeout.
+func dialTimeout(ctx context.Context, d *net.Dialer, raddr *net.TCPAddr) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultDialTimeout)
+	defer cancel()
+	return d.DialContext(ctx, "tcp", raddr.String())
+}
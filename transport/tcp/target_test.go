@@ -0,0 +1,162 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+)
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("10.0.0.1:9000, svc.internal:9000,_nano._tcp.svc.internal")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"10.0.0.1:9000", "svc.internal:9000",
+		"_nano._tcp.svc.internal"}, target.addrs)
+
+	_, err = ParseTarget("10.0.0.1:9000,,")
+	assert.NotEqual(t, nil, err)
+	_, err = ParseTarget("svc.internal")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestTargetFailover(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+
+	// port 19 is chargen, rarely in use, so the 1st replica is refused
+	target, err := ParseTarget("127.0.0.1:19,localhost:" +
+		portOf(ln.Addr()))
+	assert.Equal(t, nil, err)
+
+	conn, host, err := target.Dial()
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+	assert.Equal(t, "localhost", host)
+	assert.Equal(t, ln.Addr().String(), conn.RemoteAddr().String())
+}
+
+func TestTargetAttemptTimeout(t *testing.T) {
+	// a proxy that never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	proxy, err := ParseProxy("http://127.0.0.1:" + portOf(ln.Addr()))
+	assert.Equal(t, nil, err)
+
+	saved := defaultDialTimeout
+	defer func() { defaultDialTimeout = saved }()
+	defaultDialTimeout = 100 * time.Millisecond
+
+	// each replica has its own time
+	target, err := ParseTarget("10.0.0.1:9000,10.0.0.2:9000")
+	assert.Equal(t, nil, err)
+	_, _, err = target.DialProxy(context.Background(), proxy)
+	assert.NotEqual(t, nil, err)
+	for i := 0; i < 2; i++ {
+		select {
+		case c := <-accepted:
+			c.Close()
+		case <-time.After(time.Second):
+			t.Fatalf("replica %d not tried", i+1)
+		}
+	}
+
+	// cancelling aborts the attempt in progress
+	defaultDialTimeout = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	t0 := time.Now()
+	_, _, err = target.DialProxy(ctx, proxy)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, true, time.Since(t0) < 5*time.Second)
+	(<-accepted).Close()
+}
+
+func portOf(addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	return port
+}
+
+func TestTargetLocalBind(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+
+	_, err = ParseTarget("nosuchif0;127.0.0.1:" + portOf(ln.Addr()))
+	assert.NotEqual(t, nil, err)
+
+	target, err := ParseTarget("127.0.0.2;127.0.0.1:" + portOf(ln.Addr()))
+	assert.Equal(t, nil, err)
+	conn, _, err := target.Dial()
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+	assert.Equal(t, "127.0.0.2", conn.LocalAddr().(*net.TCPAddr).IP.String())
+}
+
+func TestListenAddrs(t *testing.T) {
+	addrs, err := ListenAddrs("*:3360")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(addrs))
+	assert.Equal(t, 3360, addrs[0].Port)
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no lo interface")
+	}
+	ifaddrs, _ := lo.Addrs()
+	addrs, err = ListenAddrs("lo;:3360")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(ifaddrs), len(addrs))
+
+	l, err := ListenTCP(addrs, newOptions())
+	assert.Equal(t, nil, err)
+	l.Close()
+	_, err = l.AcceptTCP()
+	assert.Equal(t, nano.ErrClosed, err)
+}
+
+func TestListenAddrsLinkLocal(t *testing.T) {
+	ifs, _ := net.Interfaces()
+	for _, ifi := range ifs {
+		ifaddrs, _ := ifi.Addrs()
+		linkLocal := 0
+		for _, a := range ifaddrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.IsLinkLocalUnicast() {
+				linkLocal++
+			}
+		}
+		if linkLocal == 0 || linkLocal == len(ifaddrs) {
+			continue
+		}
+
+		addrs, err := ListenAddrs(ifi.Name + ";*:0")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, len(ifaddrs)-linkLocal, len(addrs))
+		for _, addr := range addrs {
+			assert.Equal(t, false, addr.IP.IsLinkLocalUnicast())
+		}
+		l, err := ListenTCP(addrs, newOptions())
+		assert.Equal(t, nil, err)
+		l.Close()
+
+		ips, err := LocalIPs(ifi.Name)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, false, localIPFor(ips, net.ParseIP("2001:db8::1")).IsLinkLocalUnicast())
+		return
+	}
+	t.Skip("no interface with a link-local address")
+}
@@ -1,7 +1,7 @@
 package tcp
 
 import (
-	"net"
+	"context"
 
 	"github.com/funkygao/nano"
 )
@@ -25,7 +25,9 @@ func (this *tcpTransport) NewDialer(addr string, proto nano.Protocol) (nano.Pipe
 		proto: proto,
 		opts:  newOptions(),
 	}
-	if d.addr, err = net.ResolveTCPAddr("tcp", addr); err != nil {
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	// resolved on every Dial
+	if d.addr, err = ParseTarget(addr); err != nil {
 		return nil, err
 	}
 
@@ -44,7 +46,7 @@ func (this *tcpTransport) NewListener(addr string, proto nano.Protocol) (nano.Pi
 		proto: proto,
 		opts:  newOptions(),
 	}
-	if l.addr, err = net.ResolveTCPAddr("tcp", addr); err != nil {
+	if l.addrs, err = ListenAddrs(addr); err != nil {
 		return nil, err
 	}
 
@@ -56,6 +58,11 @@ var validOpts = map[string]bool{
 	nano.OptionNoHandshake: true,
 	nano.OptionDeflate:     true,
 	nano.OptionSnappy:      true,
+	nano.OptionMaxRecvSize: true,
+}
+
+func init() {
+	nano.RegisterTransport(NewTransport())
 }
 
 // NewTransport allocates a new TCP Transport.
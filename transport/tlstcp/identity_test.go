@@ -0,0 +1,207 @@
+package tlstcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/reqrep"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a leaf certificate and its PEM encoded cert and key.
+func (ca *testCA) issue(t *testing.T, serial int64, cn string,
+	uri string) (tls.Certificate, []byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth},
+	}
+	if uri != "" {
+		u, _ := url.Parse(uri)
+		tmpl.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, _ := x509.MarshalECPrivateKey(key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair, certPEM, keyPEM
+}
+
+func TestTLSMutualIdentity(t *testing.T) {
+	addr := "tls+tcp://127.0.0.1:3335"
+	ca := newTestCA(t)
+	srvCert, _, _ := ca.issue(t, 2, "server", "")
+	cliCert, _, _ := ca.issue(t, 3, "client", "spiffe://example.org/svc/worker")
+
+	tran := NewTransport()
+	l, err := tran.NewListener(addr, reqrep.NewRepSocket().GetProtocol())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.SetOption(nano.OptionTlsConfig, &tls.Config{
+		Certificates: []tls.Certificate{srvCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	d, err := tran.NewDialer(addr, reqrep.NewReqSocket().GetProtocol())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.SetOption(nano.OptionTlsConfig, &tls.Config{
+		Certificates: []tls.Certificate{cliCert},
+		RootCAs:      ca.pool,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		if c, err := d.Dial(); err == nil {
+			defer c.Close()
+			v, err := c.GetProp(nano.PropPeerIdentity)
+			if err != nil || v.(*nano.PeerIdentity).Subject.CommonName != "server" {
+				t.Errorf("server identity: %v %v", v, err)
+			}
+			c.RecvMsg() // till the server closes
+		} else {
+			t.Error(err)
+		}
+	}()
+
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	v, err := server.GetProp(nano.PropTlsConnState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := v.(tls.ConnectionState); state.Version != tls.VersionTLS13 {
+		t.Errorf("expected TLS 1.3, got %x", state.Version)
+	}
+
+	v, err = server.GetProp(nano.PropPeerIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := v.(*nano.PeerIdentity)
+	if id.Subject.CommonName != "client" {
+		t.Errorf("subject: %v", id.Subject)
+	}
+	if id.SPIFFEID != "spiffe://example.org/svc/worker" {
+		t.Errorf("spiffe id: %q", id.SPIFFEID)
+	}
+	if len(id.IPs) != 1 || !id.IPs[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("IP SANs: %v", id.IPs)
+	}
+}
+
+func TestTLSCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "a.crt")
+	keyFile := filepath.Join(dir, "a.key")
+	ca := newTestCA(t)
+
+	_, c1, k1 := ca.issue(t, 10, "v1", "")
+	os.WriteFile(certFile, c1, 0600)
+	os.WriteFile(keyFile, k1, 0600)
+
+	if _, err := NewCertReloader(filepath.Join(dir, "none"), keyFile); err == nil {
+		t.Error("missing file accepted")
+	}
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn := func() string {
+		cert, _ := r.GetCertificate(nil)
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.Subject.CommonName
+	}
+	if cn() != "v1" {
+		t.Fatalf("loaded %s", cn())
+	}
+
+	// renewal is picked up once the files change
+	_, c2, k2 := ca.issue(t, 11, "v2", "")
+	os.WriteFile(certFile, c2, 0600)
+	os.WriteFile(keyFile, k2, 0600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+	r.checked = time.Time{}
+	if cn() != "v2" {
+		t.Errorf("renewed cert not loaded, got %s", cn())
+	}
+
+	// a broken pair keeps the previous certificate
+	os.WriteFile(keyFile, k1, 0600)
+	os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute))
+	r.checked = time.Time{}
+	if cn() != "v2" {
+		t.Errorf("mismatched pair replaced the cert, got %s", cn())
+	}
+	if r.Reload() == nil {
+		t.Error("mismatched pair reloaded")
+	}
+}
@@ -0,0 +1,116 @@
+package tlstcp
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/funkygao/nano"
+)
+
+// reloadCheckInterval is how often at most the files are checked for
+// changes, as handshakes may be frequent.
+const reloadCheckInterval = time.Second
+
+// CertReloader serves a certificate and key loaded from PEM files, and
+// reloads them when the files change.  Long-lived listeners and dialers
+// thus pick up renewed certificates without a restart:
+//
+//	r, err := tlstcp.NewCertReloader("server.crt", "server.key")
+//	cfg := &tls.Config{GetCertificate: r.GetCertificate}
+//	sock.SetOption(nano.OptionTlsConfig, cfg)
+//
+// Only new handshakes see the new certificate, established connections
+// are left alone.  If a reload fails, e.g. because the pair is caught half
+// written, the previous certificate is kept.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+	checked time.Time
+
+	sync.Mutex
+}
+
+// NewCertReloader loads the certificate and key, failing if they are not
+// a valid pair.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload unconditionally loads the files again, e.g. on SIGHUP.
+func (this *CertReloader) Reload() error {
+	this.Lock()
+	defer this.Unlock()
+	return this.load()
+}
+
+// load must be called with the lock held.
+func (this *CertReloader) load() error {
+	certMod, keyMod, err := this.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(this.certFile, this.keyFile)
+	if err != nil {
+		return err
+	}
+
+	this.cert = &cert
+	this.certMod, this.keyMod = certMod, keyMod
+	this.checked = time.Now()
+	return nil
+}
+
+func (this *CertReloader) modTimes() (certMod, keyMod time.Time, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(this.certFile); err != nil {
+		return
+	}
+	certMod = fi.ModTime()
+	if fi, err = os.Stat(this.keyFile); err != nil {
+		return
+	}
+	keyMod = fi.ModTime()
+	return
+}
+
+// current returns the certificate, reloading it first if the files have
+// changed since.
+func (this *CertReloader) current() *tls.Certificate {
+	this.Lock()
+	defer this.Unlock()
+
+	if time.Since(this.checked) < reloadCheckInterval {
+		return this.cert
+	}
+	this.checked = time.Now()
+
+	certMod, keyMod, err := this.modTimes()
+	if err != nil || (certMod.Equal(this.certMod) && keyMod.Equal(this.keyMod)) {
+		return this.cert
+	}
+	if err = this.load(); err != nil {
+		nano.Debugf("reload %s: %v", this.certFile, err)
+	}
+	return this.cert
+}
+
+// GetCertificate is for tls.Config GetCertificate, on the server side.
+func (this *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return this.current(), nil
+}
+
+// GetClientCertificate is for tls.Config GetClientCertificate, on the
+// client side of mutual TLS.
+func (this *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return this.current(), nil
+}
@@ -3,6 +3,8 @@ package tlstcp
 import (
 	"testing"
 
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
 	"github.com/funkygao/nano/test"
 )
 
@@ -27,3 +29,12 @@ func TestTLSSendRecv(t *testing.T) {
 func TestTLSAll(t *testing.T) {
 	tt.TranTestAll(t)
 }
+
+func TestTLSProxy(t *testing.T) {
+	p := test.NewProxy(t, "http", "user", "secret")
+	defer p.Close()
+
+	test.NewTranTest(NewTransport(), "tls+tcp://127.0.0.1:3335").
+		WithDialerOption(nano.OptionProxy, p.URL).TranTestSendRecv(t)
+	assert.Equal(t, 1, p.Tunnels())
+}
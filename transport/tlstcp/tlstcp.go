@@ -2,10 +2,13 @@
 package tlstcp
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
+	"os"
 
 	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/transport/tcp"
 )
 
 type options map[string]interface{}
@@ -23,28 +26,28 @@ func (o options) set(name string, val interface{}) error {
 		switch v := val.(type) {
 		case *tls.Config:
 			// Make a private copy
-			cfg := *v
-			// TLS versions prior to 1.2 are insecure/broken
-			cfg.MinVersion = tls.VersionTLS12
-			cfg.MaxVersion = tls.VersionTLS12
-			o[name] = &cfg
+			cfg := v.Clone()
+			// TLS versions prior to 1.2 are insecure/broken, 1.3 and
+			// whatever comes next are welcome.
+			if cfg.MinVersion < tls.VersionTLS12 {
+				cfg.MinVersion = tls.VersionTLS12
+			}
+			if cfg.MaxVersion != 0 && cfg.MaxVersion < tls.VersionTLS12 {
+				return nano.ErrBadValue
+			}
+			o[name] = cfg
 		default:
 			return nano.ErrBadValue
 		}
 	default:
-		return nano.ErrBadOption
+		// TCP level options
+		return tcp.SetOption(o, name, val)
 	}
 	return nil
 }
 
 func (o options) configTCP(conn *net.TCPConn) error {
-	if v, ok := o[nano.OptionNoDelay]; ok {
-		if err := conn.SetNoDelay(v.(bool)); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return tcp.ConfigTCP(o, conn)
 }
 
 func newOptions(t *tlsTran) options {
@@ -54,15 +57,18 @@ func newOptions(t *tlsTran) options {
 }
 
 type dialer struct {
-	addr  *net.TCPAddr
+	addr  *tcp.Target
 	proto nano.Protocol
 	opts  options
+
+	ctx    context.Context // cancelled by Close
+	cancel context.CancelFunc
 }
 
 func (d *dialer) Dial() (nano.Pipe, error) {
 
 	var config *tls.Config
-	tconn, err := net.DialTCP("tcp", nil, d.addr)
+	tconn, host, err := d.addr.DialProxy(d.ctx, tcp.ProxyOf(d.opts))
 	if err != nil {
 		return nil, err
 	}
@@ -73,9 +79,45 @@ func (d *dialer) Dial() (nano.Pipe, error) {
 	if v, ok := d.opts[nano.OptionTlsConfig]; ok {
 		config = v.(*tls.Config)
 	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" && host != "" {
+		// verify against the name we dialed, it may resolve to
+		// different IPs over time; IPs are checked against IP SANs
+		config = config.Clone()
+		config.ServerName = host
+	}
 	conn := tls.Client(tconn, config)
-	return nano.NewConnPipe(conn, d.proto,
-		nano.PropTlsConnState, conn.ConnectionState())
+	props, err := handshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	props = append(props, nano.PipeProps(nil, d.opts)...)
+	return nano.NewConnPipe(conn, d.proto, props...)
+}
+
+// handshake completes the TLS handshake up front, so that the connection
+// state and peer identity can be reported as pipe properties, in time for
+// the PortHook to authorize on them.
+func handshake(conn *tls.Conn) ([]interface{}, error) {
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	state := conn.ConnectionState()
+	props := []interface{}{nano.PropTlsConnState, state}
+	if id := nano.PeerIdentityOf(&state); id != nil {
+		props = append(props, nano.PropPeerIdentity, id)
+	}
+	return props, nil
+}
+
+// Close implements the nano.ClosablePipeDialer Close method.
+func (d *dialer) Close() error {
+	d.cancel()
+	return nil
 }
 
 func (d *dialer) SetOption(n string, v interface{}) error {
@@ -88,10 +130,11 @@ func (d *dialer) GetOption(n string) (interface{}, error) {
 
 type listener struct {
 	proto    nano.Protocol
-	addr     *net.TCPAddr
-	listener *net.TCPListener
+	addrs    []*net.TCPAddr
+	listener *tcp.Listener
 	opts     options
 	config   *tls.Config
+	gate     nano.ConnGate
 }
 
 func (l *listener) Listen() error {
@@ -105,11 +148,12 @@ func (l *listener) Listen() error {
 	if l.config == nil {
 		return nano.ErrTlsNoConfig
 	}
-	if l.config.Certificates == nil || len(l.config.Certificates) == 0 {
+	if len(l.config.Certificates) == 0 && l.config.GetCertificate == nil &&
+		l.config.GetConfigForClient == nil {
 		return nano.ErrTlsNoCert
 	}
 
-	if l.listener, err = net.ListenTCP("tcp", l.addr); err != nil {
+	if l.listener, err = tcp.ListenTCP(l.addrs, l.opts); err != nil {
 		return err
 	}
 	return nil
@@ -127,11 +171,38 @@ func (l *listener) Accept() (nano.Pipe, error) {
 		return nil, err
 	}
 
-	return nano.NewConnPipe(tls.Server(conn, l.config), l.proto)
+	var c net.Conn = conn
+	if l.gate != nil {
+		// reject before the TLS and SP handshakes
+		if c, err = l.gate.Admit(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	tconn := tls.Server(c, l.config)
+	props, err := handshake(tconn)
+	if err != nil {
+		return nil, err
+	}
+	props = append(props, nano.PipeProps(nil, l.opts)...)
+	return nano.NewConnPipe(tconn, l.proto, props...)
+}
+
+func (l *listener) Files() ([]*os.File, error) {
+	if l.listener == nil {
+		return nil, nano.ErrClosed
+	}
+	return l.listener.Files()
+}
+
+func (l *listener) SetGate(gate nano.ConnGate) {
+	l.gate = gate
 }
 
 func (l *listener) Close() error {
-	l.listener.Close()
+	if l.listener != nil {
+		l.listener.Close()
+	}
 	return nil
 }
 
@@ -160,7 +231,9 @@ func (t *tlsTran) NewDialer(addr string, proto nano.Protocol) (nano.PipeDialer,
 	}
 
 	d := &dialer{proto: proto, opts: newOptions(t)}
-	if d.addr, err = net.ResolveTCPAddr("tcp", addr); err != nil {
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	// resolved on every Dial
+	if d.addr, err = tcp.ParseTarget(addr); err != nil {
 		return nil, err
 	}
 	return d, nil
@@ -174,14 +247,18 @@ func (t *tlsTran) NewListener(addr string, proto nano.Protocol) (nano.PipeListen
 	if addr, err = nano.StripScheme(t, addr); err != nil {
 		return nil, err
 	}
-	if l.addr, err = net.ResolveTCPAddr("tcp", addr); err != nil {
+	if l.addrs, err = tcp.ListenAddrs(addr); err != nil {
 		return nil, err
 	}
 
 	return l, nil
 }
 
-// NewTransport allocates a new inproc transport.
+func init() {
+	nano.RegisterTransport(NewTransport())
+}
+
+// NewTransport allocates a new tls+tcp transport.
 func NewTransport(opts ...interface{}) nano.Transport {
 	return &tlsTran{}
 }
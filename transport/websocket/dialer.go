@@ -0,0 +1,55 @@
+package websocket
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/funkygao/nano"
+	"github.com/gorilla/websocket"
+)
+
+// dialer implements the nano.PipeDialer interface.
+type dialer struct {
+	t     *wsTransport
+	url   *url.URL
+	proto nano.Protocol
+	opts  options
+}
+
+func (this *dialer) Dial() (nano.Pipe, error) {
+	wd := &websocket.Dialer{
+		// ask for the protocol the server side speaks
+		Subprotocols:    []string{subprotocol(this.proto.PeerNumber())},
+		TLSClientConfig: this.opts.tlsConfig(),
+	}
+
+	conn, resp, err := wd.Dial(this.url.String(), http.Header{})
+	if err != nil {
+		if err == websocket.ErrBadHandshake && resp != nil &&
+			resp.StatusCode == http.StatusBadRequest {
+			// the peer does not speak our peer protocol
+			return nil, nano.ErrBadProto
+		}
+		return nil, err
+	}
+	if conn.Subprotocol() != subprotocol(this.proto.PeerNumber()) {
+		conn.Close()
+		return nil, nano.ErrBadProto
+	}
+
+	nano.Debugf("dial %s done", this.url)
+
+	props := nano.PipeProps(this.t.opts, this.opts)
+	if resp.TLS != nil {
+		props = append(props, nano.PropTlsConnState, *resp.TLS)
+	}
+	return newWsPipe(conn, this.proto, props...), nil
+}
+
+func (this *dialer) SetOption(name string, val interface{}) error {
+	return this.opts.set(name, val)
+}
+
+func (this *dialer) GetOption(name string) (interface{}, error) {
+	return this.opts.get(name)
+}
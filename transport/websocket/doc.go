@@ -0,0 +1,11 @@
+// Package websocket implements the WebSocket transport for nano, with
+// the ws:// and wss:// schemes.
+//
+// Each SP message is carried in one binary websocket message, and the SP
+// handshake is replaced by the websocket subprotocol negotiation: the
+// dialer requests "<peer>.sp.nanomsg.org" and the listener only accepts
+// "<self>.sp.nanomsg.org", e.g. a REQ dialer asks for "rep.sp.nanomsg.org".
+//
+// Listeners either run their own HTTP server, or are mounted on an
+// existing http.ServeMux with nano.OptionWebSocketMux.
+package websocket
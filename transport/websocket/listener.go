@@ -0,0 +1,188 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/funkygao/nano"
+	"github.com/gorilla/websocket"
+)
+
+// listener implements the nano.PipeListener interface.  It is also the
+// http.Handler mounted at the path of the address.
+type listener struct {
+	t        *wsTransport
+	url      *url.URL
+	proto    nano.Protocol
+	opts     options
+	upgrader websocket.Upgrader
+	listener net.Listener // nil if mounted on an application mux
+	gate     nano.ConnGate
+
+	pipes  chan *wsPipe
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (this *listener) Listen() (err error) {
+	this.upgrader = websocket.Upgrader{
+		Subprotocols: []string{subprotocol(this.proto.Number())},
+	}
+	if !this.opts[nano.OptionWebSocketCheckOrigin].(bool) {
+		this.upgrader.CheckOrigin = func(*http.Request) bool { return true }
+	}
+
+	if v, ok := this.opts[nano.OptionWebSocketMux]; ok {
+		// The application serves the mux.
+		return this.mount(v.(*http.ServeMux))
+	}
+
+	var config *tls.Config
+	if this.t.secure {
+		if config = this.opts.tlsConfig(); config == nil {
+			return nano.ErrTlsNoConfig
+		}
+		if len(config.Certificates) == 0 && config.GetCertificate == nil {
+			return nano.ErrTlsNoCert
+		}
+	}
+
+	if this.listener, err = net.Listen("tcp", this.url.Host); err != nil {
+		return err
+	}
+	if this.gate != nil {
+		this.listener = &gatedListener{Listener: this.listener, gate: this.gate}
+	}
+	if config != nil {
+		this.listener = tls.NewListener(this.listener, config)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(this.url.Path, this)
+	server := &http.Server{Handler: mux}
+	go server.Serve(this.listener)
+
+	nano.Debugf("serving %s", this.url)
+	return nil
+}
+
+// mount registers the listener on mux, ServeMux panics on duplicated
+// patterns.
+func (this *listener) mount(mux *http.ServeMux) (err error) {
+	defer func() {
+		if recover() != nil {
+			err = nano.ErrAddrInUse
+		}
+	}()
+
+	mux.Handle(this.url.Path, this)
+	nano.Debugf("mounted %s", this.url.Path)
+	return nil
+}
+
+// ServeHTTP implements the http.Handler interface and upgrades requests
+// for our subprotocol to websocket pipes.
+func (this *listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-this.closed:
+		// ServeMux has no way to unregister a handler
+		http.Error(w, "listener closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	wanted := false
+	for _, proto := range websocket.Subprotocols(r) {
+		if proto == this.upgrader.Subprotocols[0] {
+			wanted = true
+			break
+		}
+	}
+	if !wanted {
+		http.Error(w, "unsupported SP protocol", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := this.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// upgrader has already replied to the client
+		nano.Debugf("%v", err)
+		return
+	}
+
+	props := append(nano.PipeProps(this.t.opts, this.opts),
+		nano.PropHttpRequest, r)
+	if r.TLS != nil {
+		props = append(props, nano.PropTlsConnState, *r.TLS)
+	}
+	p := newWsPipe(conn, this.proto, props...)
+	if this.listener == nil && this.gate != nil {
+		// the application's HTTP server accepted the connection, it
+		// can only be vetted now
+		if p.admitted, err = this.gate.Admit(conn.UnderlyingConn()); err != nil {
+			return
+		}
+	}
+
+	select {
+	case this.pipes <- p:
+	case <-this.closed:
+		p.Close()
+	}
+}
+
+func (this *listener) Accept() (nano.Pipe, error) {
+	select {
+	case p := <-this.pipes:
+		return p, nil
+	case <-this.closed:
+		return nil, nano.ErrClosed
+	}
+}
+
+func (this *listener) Close() error {
+	this.once.Do(func() {
+		close(this.closed)
+		if this.listener != nil {
+			this.listener.Close()
+		}
+	})
+	return nil
+}
+
+// SetGate implements the GatedPipeListener SetGate method.  Listeners
+// mounted on an application mux vet connections once upgraded, not
+// before the HTTP request.
+func (this *listener) SetGate(gate nano.ConnGate) {
+	this.gate = gate
+}
+
+func (this *listener) SetOption(name string, val interface{}) error {
+	return this.opts.set(name, val)
+}
+
+func (this *listener) GetOption(name string) (interface{}, error) {
+	return this.opts.get(name)
+}
+
+// gatedListener vets connections before the HTTP server sees them.
+type gatedListener struct {
+	net.Listener
+	gate nano.ConnGate
+}
+
+func (this *gatedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := this.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if conn, err = this.gate.Admit(conn); err == nil {
+			return conn, nil
+		}
+		// rejected conn is already closed, keep accepting
+	}
+}
@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/funkygao/nano"
+)
+
+type options map[string]interface{}
+
+func newOptions(t *wsTransport) options {
+	opt := make(options)
+	opt[nano.OptionWebSocketCheckOrigin] = true
+	if t.secure {
+		opt[nano.OptionTlsConfig] = (*tls.Config)(nil)
+	}
+	return opt
+}
+
+func (o options) get(name string) (interface{}, error) {
+	if v, ok := o[name]; !ok {
+		return nil, nano.ErrBadOption
+	} else {
+		return v, nil
+	}
+}
+
+func (o options) set(name string, val interface{}) error {
+	switch name {
+	case nano.OptionWebSocketMux:
+		switch v := val.(type) {
+		case *http.ServeMux:
+			o[name] = v
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+
+	case nano.OptionWebSocketCheckOrigin:
+		switch v := val.(type) {
+		case bool:
+			o[name] = v
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+
+	case nano.OptionMaxRecvSize:
+		return nano.SetPipeOption(o, name, val)
+
+	case nano.OptionTlsConfig:
+		if _, present := o[name]; !present {
+			// ws:// has no use of TLS
+			return nano.ErrBadOption
+		}
+		switch v := val.(type) {
+		case *tls.Config:
+			o[name] = v
+			return nil
+		default:
+			return nano.ErrBadValue
+		}
+	}
+	return nano.ErrBadOption
+}
+
+func (o options) tlsConfig() *tls.Config {
+	if v, ok := o[nano.OptionTlsConfig]; ok {
+		return v.(*tls.Config)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/funkygao/nano"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// defaultMaxRecvSize mitigates Denial-of-Service attacks, same as
+	// the stream transports.  nano.OptionMaxRecvSize changes it.
+	defaultMaxRecvSize = 1 << 20
+
+	subprotocolSuffix = ".sp.nanomsg.org"
+)
+
+var protoNames = map[uint16]string{
+	nano.ProtoPair:       "pair",
+	nano.ProtoPub:        "pub",
+	nano.ProtoSub:        "sub",
+	nano.ProtoReq:        "req",
+	nano.ProtoRep:        "rep",
+	nano.ProtoPush:       "push",
+	nano.ProtoPull:       "pull",
+	nano.ProtoSurveyor:   "surveyor",
+	nano.ProtoRespondent: "respondent",
+	nano.ProtoBus:        "bus",
+	nano.ProtoXPub:       "xpub",
+	nano.ProtoXSub:       "xsub",
+	nano.ProtoStar:       "star2",
+}
+
+// subprotocol returns the websocket subprotocol name of a SP protocol.
+func subprotocol(proto uint16) string {
+	if name, present := protoNames[proto]; present {
+		return name + subprotocolSuffix
+	}
+	return fmt.Sprintf("x%d%s", proto, subprotocolSuffix)
+}
+
+// wsPipe implements the Pipe interface on top of a websocket connection.
+type wsPipe struct {
+	conn     *websocket.Conn
+	admitted net.Conn // if vetted after the upgrade, releases the slot
+	proto    nano.Protocol
+	props    map[string]interface{}
+
+	rlock sync.Mutex
+	wlock sync.Mutex
+
+	open bool
+}
+
+func newWsPipe(conn *websocket.Conn, proto nano.Protocol, props ...interface{}) *wsPipe {
+	p := &wsPipe{
+		conn:  conn,
+		proto: proto,
+		props: make(map[string]interface{}),
+		open:  true,
+	}
+
+	p.props[nano.PropLocalAddr] = conn.LocalAddr()
+	p.props[nano.PropRemoteAddr] = conn.RemoteAddr()
+	for i := 0; i+1 < len(props); i += 2 {
+		p.props[props[i].(string)] = props[i+1]
+	}
+
+	limit := int64(defaultMaxRecvSize)
+	if v, ok := p.props[nano.OptionMaxRecvSize]; ok {
+		limit = int64(v.(int))
+	}
+	conn.SetReadLimit(limit)
+	return p
+}
+
+// SendMsg implements the Pipe SendMsg method.  Each message is sent as
+// one binary websocket message.
+func (p *wsPipe) SendMsg(msg *nano.Message) error {
+	p.wlock.Lock()
+	w, err := p.conn.NextWriter(websocket.BinaryMessage)
+	if err == nil {
+		if _, err = w.Write(msg.Header); err == nil {
+			if _, err = w.Write(msg.Body); err == nil {
+				err = w.Close()
+			}
+		}
+	}
+	p.wlock.Unlock()
+
+	msg.Free()
+	return err
+}
+
+// RecvMsg implements the Pipe RecvMsg method.
+func (p *wsPipe) RecvMsg() (*nano.Message, error) {
+	p.rlock.Lock()
+	defer p.rlock.Unlock()
+
+	for {
+		mt, r, err := p.conn.NextReader()
+		if err != nil {
+			return nil, err
+		}
+		if mt != websocket.BinaryMessage {
+			// SP is only carried in binary messages
+			continue
+		}
+
+		// read straight into the message slab, growing if needed
+		msg := nano.NewMessage(0)
+		buf := bytes.NewBuffer(msg.Body)
+		if _, err = buf.ReadFrom(r); err != nil {
+			msg.Free()
+			return nil, err
+		}
+		msg.Body = buf.Bytes()
+		return msg, nil
+	}
+}
+
+// Flush implements the Pipe Flush method.  Messages are never buffered.
+func (p *wsPipe) Flush() error {
+	return nil
+}
+
+func (p *wsPipe) LocalProtocol() uint16 {
+	return p.proto.Number()
+}
+
+func (p *wsPipe) RemoteProtocol() uint16 {
+	return p.proto.PeerNumber()
+}
+
+func (p *wsPipe) Close() error {
+	p.open = false
+	if p.admitted != nil {
+		p.admitted.Close()
+	}
+	return p.conn.Close()
+}
+
+func (p *wsPipe) IsOpen() bool {
+	return p.open
+}
+
+func (p *wsPipe) GetProp(name string) (interface{}, error) {
+	if v, ok := p.props[name]; ok {
+		return v, nil
+	}
+	return nil, nano.ErrBadProperty
+}
+
+type wsTransport struct {
+	secure bool
+	opts   options
+}
+
+func (this *wsTransport) Scheme() string {
+	if this.secure {
+		return "wss"
+	}
+	return "ws"
+}
+
+// KeepsQuery leaves the address query in the URL, e.g. an access token
+// for the HTTP server.
+func (this *wsTransport) KeepsQuery() bool {
+	return true
+}
+
+func (this *wsTransport) parseURL(addr string) (*url.URL, error) {
+	if _, err := nano.StripScheme(this, addr); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, nano.ErrBadAddr
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u, nil
+}
+
+func (this *wsTransport) NewDialer(addr string, proto nano.Protocol) (nano.PipeDialer, error) {
+	u, err := this.parseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &dialer{
+		t:     this,
+		url:   u,
+		proto: proto,
+		opts:  newOptions(this),
+	}
+	nano.Debugf("dialer:%s", u)
+	return d, nil
+}
+
+func (this *wsTransport) NewListener(addr string, proto nano.Protocol) (nano.PipeListener, error) {
+	u, err := this.parseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &listener{
+		t:      this,
+		url:    u,
+		proto:  proto,
+		opts:   newOptions(this),
+		pipes:  make(chan *wsPipe),
+		closed: make(chan struct{}),
+	}
+	nano.Debugf("listener:%s", u)
+	return l, nil
+}
+
+func init() {
+	nano.RegisterTransport(NewTransport())
+	nano.RegisterTransport(NewTLSTransport())
+}
+
+// NewTransport allocates a new ws:// transport.  The only option is
+// nano.OptionMaxRecvSize, for all its pipes.
+func NewTransport(opts ...interface{}) nano.Transport {
+	return newTransport(false, opts)
+}
+
+// NewTLSTransport allocates a new wss:// transport.  The TLS config is
+// supplied with nano.OptionTlsConfig.
+func NewTLSTransport(opts ...interface{}) nano.Transport {
+	return newTransport(true, opts)
+}
+
+func newTransport(secure bool, opts []interface{}) nano.Transport {
+	t := &wsTransport{secure: secure, opts: make(options)}
+	if len(opts)%2 != 0 {
+		return nil
+	}
+	for i := 0; i+1 < len(opts); i += 2 {
+		name, ok := opts[i].(string)
+		if !ok || name != nano.OptionMaxRecvSize || t.opts.set(name, opts[i+1]) != nil {
+			return nil
+		}
+	}
+	return t
+}
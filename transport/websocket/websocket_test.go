@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/nano"
+	"github.com/funkygao/nano/protocol/reqrep"
+)
+
+var tran = NewTransport()
+var protoRep = reqrep.NewRepSocket().GetProtocol()
+var protoReq = reqrep.NewReqSocket().GetProtocol()
+
+func TestWsSendRecv(t *testing.T) {
+	addr := "ws://127.0.0.1:3350/nano"
+	l, err := tran.NewListener(addr, protoRep)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, l.Listen())
+	defer l.Close()
+
+	d, err := tran.NewDialer(addr, protoReq)
+	assert.Equal(t, nil, err)
+	client, err := d.Dial()
+	assert.Equal(t, nil, err)
+	defer client.Close()
+
+	server, err := l.Accept()
+	assert.Equal(t, nil, err)
+	defer server.Close()
+	_, err = server.GetProp(nano.PropHttpRequest)
+	assert.Equal(t, nil, err)
+
+	ping := []byte("REQUEST_MESSAGE")
+	req := nano.NewMessage(len(ping))
+	req.Header = append(req.Header, 0x80, 0, 0, 1)
+	req.Body = append(req.Body, ping...)
+	assert.Equal(t, nil, client.SendMsg(req))
+
+	m, err := server.RecvMsg()
+	assert.Equal(t, nil, err)
+	// header is flattened into body on the wire
+	assert.Equal(t, true, bytes.Equal(m.Body[4:], ping))
+	assert.Equal(t, 0, len(m.Header))
+}
+
+func TestWsBadProto(t *testing.T) {
+	addr := "ws://127.0.0.1:3351/"
+	l, err := tran.NewListener(addr, protoRep)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, l.Listen())
+	defer l.Close()
+
+	// REP dialing REP asks for the req subprotocol
+	d, err := tran.NewDialer(addr, protoRep)
+	assert.Equal(t, nil, err)
+	_, err = d.Dial()
+	assert.Equal(t, nano.ErrBadProto, err)
+}
+
+func TestWsSharedMux(t *testing.T) {
+	mux := http.NewServeMux()
+	ln, err := net.Listen("tcp", "127.0.0.1:3352")
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+	go http.Serve(ln, mux)
+
+	for _, path := range []string{"/a", "/b"} {
+		addr := "ws://127.0.0.1:3352" + path
+		l, err := tran.NewListener(addr, protoRep)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, l.SetOption(nano.OptionWebSocketMux, mux))
+		assert.Equal(t, nil, l.Listen())
+		defer l.Close()
+
+		d, _ := tran.NewDialer(addr, protoReq)
+		c, err := d.Dial()
+		assert.Equal(t, nil, err)
+		defer c.Close()
+		s, err := l.Accept()
+		assert.Equal(t, nil, err)
+		defer s.Close()
+	}
+
+	// same path twice on one mux
+	l, _ := tran.NewListener("ws://127.0.0.1:3352/a", protoRep)
+	l.SetOption(nano.OptionWebSocketMux, mux)
+	assert.Equal(t, nano.ErrAddrInUse, l.Listen())
+}
+
+func TestWsMaxRecvSize(t *testing.T) {
+	addr := "ws://127.0.0.1:3354/"
+	l, err := NewTransport(nano.OptionMaxRecvSize, 64).NewListener(addr, protoRep)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, l.Listen())
+	defer l.Close()
+	assert.Equal(t, nano.ErrBadValue, l.SetOption(nano.OptionMaxRecvSize, 0))
+
+	d, _ := tran.NewDialer(addr, protoReq)
+	assert.Equal(t, nil, d.SetOption(nano.OptionMaxRecvSize, 16))
+	client, err := d.Dial()
+	assert.Equal(t, nil, err)
+	defer client.Close()
+	server, err := l.Accept()
+	assert.Equal(t, nil, err)
+	defer server.Close()
+
+	// the transport limit on the listener side, the dialer's own
+	send := func(p nano.Pipe, n int) {
+		m := nano.NewMessage(n)
+		m.Body = append(m.Body, bytes.Repeat([]byte("x"), n)...)
+		assert.Equal(t, nil, p.SendMsg(m))
+	}
+	send(client, 64)
+	m, err := server.RecvMsg()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 64, len(m.Body))
+	send(server, 17)
+	_, err = client.RecvMsg()
+	assert.NotEqual(t, nil, err)
+
+	assert.Equal(t, nil, NewTransport(nano.OptionSnappy, true))
+}
+
+func TestWsAddressQuery(t *testing.T) {
+	mux := http.NewServeMux()
+	queries := make(chan string, 1)
+	ln, err := net.Listen("tcp", "127.0.0.1:3355")
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+		mux.ServeHTTP(w, r)
+	}))
+
+	rep := reqrep.NewRepSocket()
+	defer rep.Close()
+	assert.Equal(t, nil, rep.ListenOptions("ws://127.0.0.1:3355/nano",
+		map[string]interface{}{nano.OptionWebSocketMux: mux}))
+
+	// the options are taken off the query, the rest is for the server
+	req := reqrep.NewReqSocket()
+	defer req.Close()
+	d, err := req.NewDialer("ws://127.0.0.1:3355/nano?token=x&maxrecv=1m", nil)
+	assert.Equal(t, nil, err)
+	v, _ := d.GetOption(nano.OptionMaxRecvSize)
+	assert.Equal(t, 1<<20, v)
+	assert.Equal(t, nil, d.Dial())
+	select {
+	case q := <-queries:
+		assert.Equal(t, "token=x", q)
+	case <-time.After(time.Second):
+		t.Fatal("no request")
+	}
+}
+
+func TestWssNoConfig(t *testing.T) {
+	l, err := NewTLSTransport().NewListener("wss://127.0.0.1:3353/", protoRep)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nano.ErrTlsNoConfig, l.Listen())
+
+	_, err = l.GetOption(nano.OptionTlsConfig)
+	assert.Equal(t, nil, err)
+	_, err = tran.NewListener("wss://127.0.0.1:3353/", protoRep)
+	assert.Equal(t, nano.ErrBadTran, err)
+}
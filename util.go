@@ -1,6 +1,7 @@
 package nano
 
 import (
+	"compress/flate"
 	"strings"
 	"time"
 )
@@ -26,6 +27,58 @@ func StripScheme(t Transport, addr string) (string, error) {
 	return addr[len(s):], nil
 }
 
+// pipeOpts are the options interpreted by the pipes of stream transports,
+// which hand them over to NewConnPipe as properties.
+var pipeOpts = map[string]bool{
+	OptionNoHandshake: true,
+	OptionSnappy:      true,
+	OptionDeflate:     true,
+	OptionMaxRecvSize: true,
+}
+
+// IsPipeOption returns true for the options interpreted by NewConnPipe.
+func IsPipeOption(name string) bool {
+	return pipeOpts[name]
+}
+
+// SetPipeOption validates and stores a pipe option in opts, for the
+// dialers and listeners of stream transports.
+func SetPipeOption(opts map[string]interface{}, name string, val interface{}) error {
+	switch name {
+	case OptionNoHandshake, OptionSnappy:
+		if _, ok := val.(bool); !ok {
+			return ErrBadValue
+		}
+	case OptionDeflate:
+		if v, ok := val.(int); !ok || v < flate.HuffmanOnly || v > flate.BestCompression {
+			return ErrBadValue
+		}
+	case OptionMaxRecvSize:
+		if v, ok := val.(int); !ok || v <= 0 {
+			return ErrBadValue
+		}
+	default:
+		return ErrBadOption
+	}
+
+	opts[name] = val
+	return nil
+}
+
+// PipeProps merges the transport wide pipe options with those of a dialer
+// or listener, which take precedence, into properties for NewConnPipe.
+func PipeProps(topts, opts map[string]interface{}) []interface{} {
+	merged := make(map[string]interface{})
+	for _, o := range []map[string]interface{}{topts, opts} {
+		for k, v := range o {
+			if pipeOpts[k] {
+				merged[k] = v
+			}
+		}
+	}
+	return FlattenOptions(merged)
+}
+
 // FlattenOptions flattens options from map to slice.
 func FlattenOptions(opts map[string]interface{}) []interface{} {
 	r := make([]interface{}, 0, len(opts)*2)
@@ -0,0 +1,105 @@
+package nano
+
+import (
+	"os"
+	"strings"
+)
+
+// WrapTransport returns t with every pipe it makes passed through wrap,
+// for transports that decorate another one, e.g. to inject faults or to
+// record traffic.  The scheme is the one of t with prefix, which is
+// stripped from addresses before they reach t; an empty prefix keeps the
+// scheme, so that AddTransport replaces t for a socket.  The optional
+// interfaces of t and of its dialers and listeners are preserved.
+func WrapTransport(t Transport, prefix string, wrap func(Pipe) (Pipe, error)) Transport {
+	return &wrappedTran{t: t, prefix: prefix, wrap: wrap}
+}
+
+// wrappedTran implements the Transport and QueryTransport interfaces.
+type wrappedTran struct {
+	t      Transport
+	prefix string
+	wrap   func(Pipe) (Pipe, error)
+}
+
+func (this *wrappedTran) Scheme() string {
+	return this.prefix + this.t.Scheme()
+}
+
+func (this *wrappedTran) KeepsQuery() bool {
+	return keepsQuery(this.t)
+}
+
+func (this *wrappedTran) NewDialer(addr string, proto Protocol) (PipeDialer, error) {
+	d, err := this.t.NewDialer(strings.TrimPrefix(addr, this.prefix), proto)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedDialer{PipeDialer: d, wrap: this.wrap}, nil
+}
+
+func (this *wrappedTran) NewListener(addr string, proto Protocol) (PipeListener, error) {
+	l, err := this.t.NewListener(strings.TrimPrefix(addr, this.prefix), proto)
+	if err != nil {
+		return nil, err
+	}
+	wl := &wrappedListener{PipeListener: l, wrap: this.wrap}
+	if _, ok := l.(GatedPipeListener); ok {
+		return &wrappedGatedListener{wl}, nil
+	}
+	return wl, nil
+}
+
+// wrappedDialer implements the PipeDialer and ClosablePipeDialer
+// interfaces.
+type wrappedDialer struct {
+	PipeDialer
+	wrap func(Pipe) (Pipe, error)
+}
+
+func (this *wrappedDialer) Dial() (Pipe, error) {
+	p, err := this.PipeDialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return this.wrap(p)
+}
+
+func (this *wrappedDialer) Close() error {
+	if cd, ok := this.PipeDialer.(ClosablePipeDialer); ok {
+		return cd.Close()
+	}
+	return nil
+}
+
+// wrappedListener implements the PipeListener and FilePipeListener
+// interfaces.
+type wrappedListener struct {
+	PipeListener
+	wrap func(Pipe) (Pipe, error)
+}
+
+func (this *wrappedListener) Accept() (Pipe, error) {
+	p, err := this.PipeListener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return this.wrap(p)
+}
+
+func (this *wrappedListener) Files() ([]*os.File, error) {
+	if fl, ok := this.PipeListener.(FilePipeListener); ok {
+		return fl.Files()
+	}
+	return nil, ErrNoListenFd
+}
+
+// wrappedGatedListener is a wrappedListener whose transport supports
+// admission control.
+type wrappedGatedListener struct {
+	*wrappedListener
+}
+
+func (this *wrappedGatedListener) SetGate(gate ConnGate) {
+	this.PipeListener.(GatedPipeListener).SetGate(gate)
+}